@@ -4,12 +4,16 @@ import (
 	"context"
 	"embed"
 	"log"
+	"math/rand"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 
@@ -23,6 +27,8 @@ func main() {
 	//decimals at json as string
 	//https://github.com/shopspring/decimal/issues/21
 	decimal.MarshalJSONWithoutQuotes = true
+	//jitter of accrual retries
+	rand.Seed(time.Now().UnixNano())
 
 	cfg := app.NewConfig()
 	z, err := zap.NewProduction()
@@ -31,6 +37,11 @@ func main() {
 	}
 	sugaredLogger := z.Sugar()
 
+	tracerProvider, err := app.NewTracerProvider(context.Background(), cfg.TracesExporter)
+	if err != nil {
+		sugaredLogger.Fatal(err)
+	}
+
 	repository, err := app.NewRepository(cfg.DatabaseURI, embedMigrations, sugaredLogger)
 	if err != nil {
 		sugaredLogger.Fatal(err)
@@ -38,31 +49,113 @@ func main() {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	accrualService := app.NewAccrualService(repository, cfg.AccrualSystemAddress, ctx, sugaredLogger)
-	service := app.NewService(repository, accrualService, cfg.JWTSecret, sugaredLogger)
-	handlers := app.NewHandlers(service, cfg.JWTSecret, sugaredLogger)
+	accrualService := app.NewAccrualService(repository, cfg.AccrualSystemAddress, cfg.AccrualWorkers, cfg.AccrualRateLimit, ctx, sugaredLogger)
+	go accrualService.Run()
+	pointsExpiry := app.NewPointsExpiry(repository, cfg.PointsTTL, cfg.PointsExpiryInterval, ctx, sugaredLogger)
+	go pointsExpiry.Run()
+	tierReview := app.NewTierReview(repository, cfg.TierReviewInterval, ctx, sugaredLogger)
+	go tierReview.Run()
+	rules := app.LoyaltyRules{
+		PointsTTL:          cfg.PointsTTL,
+		ReferralBonus:      decimal.NewFromInt(int64(cfg.ReferralBonus)),
+		ReferralCap:        cfg.ReferralCap,
+		TransferDailyLimit: decimal.NewFromInt(int64(cfg.TransferDailyLimit)),
+	}
+	service := app.NewService(repository, accrualService, rules, cfg.JWTSecret, sugaredLogger)
+	handlers := app.NewHandlers(service, cfg.JWTSecret, cfg.OperatorToken, sugaredLogger)
+	prometheus.MustRegister(
+		collectors.NewDBStatsCollector(repository.Conn, "gophermart"),
+		app.NewQueueCollector(repository, sugaredLogger),
+	)
+	health := app.NewHealth(repository, accrualService, repository.MigrationVersion, cfg.AccrualBacklogLimit, sugaredLogger)
+
+	fiberApp := fiber.New(fiber.Config{
+		ErrorHandler: app.NewErrorHandler(sugaredLogger),
+		//Shutdown waits for keep-alive connections until they are idle for this long
+		IdleTimeout: idleTimeout,
+	})
+	fiberApp.Use(requestid.New())
+	fiberApp.Use(app.Tracing())
+	fiberApp.Use(app.RequestLogger(sugaredLogger))
+	fiberApp.Use(app.Metrics())
+	fiberApp.Use(app.Compress(app.DefaultCompressMinSize))
+	fiberApp.Use(app.Decompress(app.DefaultDecompressMaxSize))
+
+	app.RegisterHealthRoutes(fiberApp, health)
+	app.RegisterMetricsRoutes(fiberApp, prometheus.DefaultGatherer)
+	app.RegisterRoutes(fiberApp, handlers)
+
+	go func() {
+		//Listen returns nil after Shutdown
+		if err := fiberApp.Listen(cfg.RunAddress); err != nil {
+			sugaredLogger.Fatal(err)
+		}
+	}()
 
-	app := fiber.New()
-	app.Use(logger.New())
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	sugaredLogger.Info("Shutting down service...")
+	health.Shutdown()
+
+	// the server keeps serving until the orchestrator notices failing readiness, a second signal skips the wait
+	drain := time.NewTimer(cfg.ReadinessDrainDelay)
+	select {
+	case <-drain.C:
+	case <-quit:
+		drain.Stop()
+	}
 
-	api := app.Group("/api")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+
+	err = shutdownHTTP(shutdownCtx, fiberApp)
+	if err != nil {
+		sugaredLogger.Errorf("HTTP server shutdown error: %s", err.Error())
+	}
+
+	err = accrualService.Stop(shutdownCtx)
+	if err != nil {
+		sugaredLogger.Errorf("Accrual service shutdown error: %s", err.Error())
+	}
+	err = pointsExpiry.Stop(shutdownCtx)
+	if err != nil {
+		sugaredLogger.Errorf("Points expiry shutdown error: %s", err.Error())
+	}
+	err = tierReview.Stop(shutdownCtx)
+	if err != nil {
+		sugaredLogger.Errorf("Tier review shutdown error: %s", err.Error())
+	}
+	//aborts accrual requests, expiry and tier review which didn't finish in time
+	cancel()
 
-	usr := api.Group("/user")
-	usr.Post("/login", handlers.Login)
-	usr.Post("/register", handlers.Register)
+	err = tracerProvider.Shutdown(shutdownCtx)
+	if err != nil {
+		sugaredLogger.Errorf("Tracer provider shutdown error: %s", err.Error())
+	}
 
-	usr.Get("/orders", handlers.GetOrders)
-	usr.Post("/orders", handlers.CreateOrder)
+	err = repository.Close()
+	if err != nil {
+		sugaredLogger.Errorf("Database close error: %s", err.Error())
+	}
 
-	usr.Get("/balance", handlers.GetBalance)
+	sugaredLogger.Info("Service is stopped")
+	_ = z.Sync()
+}
 
-	usr.Get("/balance/withdraw", handlers.WithdrawHistory)
-	usr.Post("/balance/withdraw", handlers.Withdraw)
+const idleTimeout = 5 * time.Second
 
-	go sugaredLogger.Fatal(app.Listen(cfg.RunAddress))
+// shutdownHTTP stops accepting connections and waits for in-flight requests until ctx is done.
+func shutdownHTTP(ctx context.Context, app *fiber.App) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Shutdown()
+	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	sugaredLogger.Info("Shutting down service...")
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE accrual_jobs
+(
+    id              SERIAL PRIMARY KEY,
+    order_number    VARCHAR(255) UNIQUE NOT NULL REFERENCES orders (number),
+    user_id         INT                 NOT NULL REFERENCES users,
+    attempts        INT                 NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP           NOT NULL DEFAULT now(),
+    last_error      TEXT,
+    created_at      TIMESTAMP           NOT NULL DEFAULT now()
+);
+
+CREATE INDEX accrual_jobs_next_attempt_at_idx ON accrual_jobs (next_attempt_at);
+
+-- orders which were waiting in the in-memory queue before it became persistent
+INSERT INTO accrual_jobs (order_number, user_id)
+SELECT number, user_id
+FROM orders
+WHERE status IN ('NEW', 'REGISTERED', 'PROCESSING');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE accrual_jobs;
+-- +goose StatementEnd
@@ -0,0 +1,74 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE ledger_transactions
+(
+    id           SERIAL PRIMARY KEY,
+    kind         VARCHAR(32) NOT NULL,
+    order_number VARCHAR(255),
+    created_at   TIMESTAMP   NOT NULL
+);
+
+-- every transaction has a leg on the user's account and a leg on a system account,
+-- amounts of all legs of a transaction sum up to zero
+CREATE TABLE ledger_entries
+(
+    id             SERIAL PRIMARY KEY,
+    transaction_id INT             NOT NULL REFERENCES ledger_transactions,
+    account        VARCHAR(32)     NOT NULL,
+    user_id        INT REFERENCES users,
+    amount         DECIMAL(36, 18) NOT NULL
+);
+
+CREATE INDEX ledger_entries_transaction_id_idx ON ledger_entries (transaction_id);
+CREATE INDEX ledger_entries_user_id_idx ON ledger_entries (user_id);
+
+-- move the history which was kept only in users.balance and users.withdrawn into the ledger
+DO
+$$
+    DECLARE
+        r   RECORD;
+        tid INT;
+    BEGIN
+        FOR r IN SELECT number, user_id, accrual, uploaded_at FROM orders WHERE status = 'PROCESSED' AND accrual > 0
+            LOOP
+                INSERT INTO ledger_transactions (kind, order_number, created_at)
+                VALUES ('ACCRUAL', r.number, r.uploaded_at)
+                RETURNING id INTO tid;
+                INSERT INTO ledger_entries (transaction_id, account, user_id, amount)
+                VALUES (tid, 'USER', r.user_id, r.accrual),
+                       (tid, 'ACCRUALS', NULL, -r.accrual);
+            END LOOP;
+
+        FOR r IN SELECT order_number, user_id, amount, processed_at FROM withdraw_history
+            LOOP
+                INSERT INTO ledger_transactions (kind, order_number, created_at)
+                VALUES ('WITHDRAWAL', r.order_number, r.processed_at)
+                RETURNING id INTO tid;
+                INSERT INTO ledger_entries (transaction_id, account, user_id, amount)
+                VALUES (tid, 'USER', r.user_id, -r.amount),
+                       (tid, 'WITHDRAWALS', NULL, r.amount);
+            END LOOP;
+
+        -- whatever can't be explained by orders and withdrawals becomes an adjustment
+        FOR r IN SELECT u.id, u.balance - COALESCE(SUM(e.amount), 0) AS diff
+                 FROM users u
+                          LEFT JOIN ledger_entries e ON e.user_id = u.id AND e.account = 'USER'
+                 GROUP BY u.id
+                 HAVING u.balance - COALESCE(SUM(e.amount), 0) <> 0
+            LOOP
+                INSERT INTO ledger_transactions (kind, created_at)
+                VALUES ('ADJUSTMENT', now())
+                RETURNING id INTO tid;
+                INSERT INTO ledger_entries (transaction_id, account, user_id, amount)
+                VALUES (tid, 'USER', r.id, r.diff),
+                       (tid, 'ADJUSTMENTS', NULL, -r.diff);
+            END LOOP;
+    END
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE ledger_entries;
+DROP TABLE ledger_transactions;
+-- +goose StatementEnd
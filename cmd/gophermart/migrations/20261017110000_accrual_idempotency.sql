@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- an order can be credited only once
+CREATE UNIQUE INDEX ledger_transactions_accrual_order_number_idx ON ledger_transactions (order_number) WHERE kind = 'ACCRUAL';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX ledger_transactions_accrual_order_number_idx;
+-- +goose StatementEnd
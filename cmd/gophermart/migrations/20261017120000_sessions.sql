@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE sessions
+(
+    id                 VARCHAR(64) PRIMARY KEY,
+    user_id            INT                 NOT NULL REFERENCES users,
+    refresh_token_hash VARCHAR(255) UNIQUE NOT NULL,
+    created_at         TIMESTAMP           NOT NULL,
+    expires_at         TIMESTAMP           NOT NULL,
+    revoked_at         TIMESTAMP
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE sessions;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX orders_user_id_uploaded_at_idx ON orders (user_id, uploaded_at, id);
+CREATE INDEX withdraw_history_user_id_processed_at_idx ON withdraw_history (user_id, processed_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX withdraw_history_user_id_processed_at_idx;
+DROP INDEX orders_user_id_uploaded_at_idx;
+-- +goose StatementEnd
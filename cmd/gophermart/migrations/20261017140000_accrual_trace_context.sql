@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE accrual_jobs ADD COLUMN trace_context VARCHAR(55);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE accrual_jobs DROP COLUMN trace_context;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE accrual_jobs ADD COLUMN request_id VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE accrual_jobs DROP COLUMN request_id;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- every accrual is a lot which expires some months after it was credited,
+-- withdrawals consume lots oldest first, so the sum of remaining points equals users.balance
+CREATE TABLE accrual_lots
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INT             NOT NULL REFERENCES users,
+    order_number VARCHAR(255),
+    amount       DECIMAL(36, 18) NOT NULL,
+    remaining    DECIMAL(36, 18) NOT NULL,
+    credited_at  TIMESTAMP       NOT NULL
+);
+
+CREATE INDEX accrual_lots_user_id_idx ON accrual_lots (user_id, credited_at, id) WHERE remaining > 0;
+CREATE INDEX accrual_lots_credited_at_idx ON accrual_lots (credited_at) WHERE remaining > 0;
+
+-- points credited before lots existed can't be split by orders anymore,
+-- the balance becomes one lot which expires as if it was credited now
+INSERT INTO accrual_lots (user_id, amount, remaining, credited_at)
+SELECT id, balance, balance, now()
+FROM users
+WHERE balance > 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE accrual_lots;
+-- +goose StatementEnd
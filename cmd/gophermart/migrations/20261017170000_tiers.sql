@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- tier_review_at is when the oldest accrual of the rolling window leaves it and the tier may go down
+ALTER TABLE users
+    ADD COLUMN tier           VARCHAR(16) NOT NULL DEFAULT 'BRONZE',
+    ADD COLUMN tier_review_at TIMESTAMP;
+
+CREATE TABLE tier_events
+(
+    id         SERIAL PRIMARY KEY,
+    user_id    INT             NOT NULL REFERENCES users,
+    from_tier  VARCHAR(16)     NOT NULL,
+    to_tier    VARCHAR(16)     NOT NULL,
+    points     DECIMAL(36, 18) NOT NULL,
+    created_at TIMESTAMP       NOT NULL
+);
+
+CREATE INDEX tier_events_user_id_idx ON tier_events (user_id, created_at);
+
+CREATE INDEX ledger_transactions_kind_created_at_idx ON ledger_transactions (kind, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX ledger_transactions_kind_created_at_idx;
+DROP TABLE tier_events;
+ALTER TABLE users
+    DROP COLUMN tier,
+    DROP COLUMN tier_review_at;
+-- +goose StatementEnd
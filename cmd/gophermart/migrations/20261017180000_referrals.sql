@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN referral_code VARCHAR(16);
+
+UPDATE users SET referral_code = UPPER(SUBSTRING(MD5(random()::text || id::text) FROM 1 FOR 8));
+
+ALTER TABLE users
+    ALTER COLUMN referral_code SET NOT NULL,
+    ADD CONSTRAINT users_referral_code_key UNIQUE (referral_code);
+
+-- referrer_bonus and referee_bonus are fixed at registration, capped is set if the referrer
+-- exceeded the cap and gets no bonus. rewarded_at is set when the referee's first order is processed.
+CREATE TABLE referrals
+(
+    id             SERIAL PRIMARY KEY,
+    referrer_id    INT             NOT NULL REFERENCES users,
+    referee_id     INT             NOT NULL UNIQUE REFERENCES users,
+    referrer_bonus DECIMAL(36, 18) NOT NULL,
+    referee_bonus  DECIMAL(36, 18) NOT NULL,
+    capped         BOOLEAN         NOT NULL DEFAULT FALSE,
+    created_at     TIMESTAMP       NOT NULL,
+    rewarded_at    TIMESTAMP
+);
+
+CREATE INDEX referrals_referrer_id_idx ON referrals (referrer_id, created_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE referrals;
+ALTER TABLE users
+    DROP COLUMN referral_code;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a transfer has a row of each side, direction is OUT for the sender and IN for the recipient
+CREATE TABLE transfer_history
+(
+    id              SERIAL PRIMARY KEY,
+    user_id         INT             NOT NULL REFERENCES users,
+    counterparty_id INT             NOT NULL REFERENCES users,
+    direction       VARCHAR(8)      NOT NULL,
+    amount          DECIMAL(36, 18) NOT NULL,
+    processed_at    TIMESTAMP       NOT NULL
+);
+
+CREATE INDEX transfer_history_user_id_idx ON transfer_history (user_id, processed_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE transfer_history;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- withdraw_history rows stay immutable, the reversed part of a withdrawal is the sum of its reversals
+CREATE TABLE withdrawal_reversals
+(
+    id            SERIAL PRIMARY KEY,
+    withdrawal_id INT             NOT NULL REFERENCES withdraw_history,
+    amount        DECIMAL(36, 18) NOT NULL,
+    reason        TEXT            NOT NULL DEFAULT '',
+    created_at    TIMESTAMP       NOT NULL
+);
+
+CREATE INDEX withdrawal_reversals_withdrawal_id_idx ON withdrawal_reversals (withdrawal_id);
+CREATE INDEX withdraw_history_order_number_idx ON withdraw_history (order_number);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX withdraw_history_order_number_idx;
+DROP TABLE withdrawal_reversals;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX users_tier_review_at_idx ON users (tier_review_at, id) WHERE tier_review_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX users_tier_review_at_idx;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- an order is paid with points once, so a reversal always finds a single withdrawal of the order.
+-- The migration fails if the table already has several withdrawals of an order, they have to be resolved by hand.
+DROP INDEX withdraw_history_order_number_idx;
+CREATE UNIQUE INDEX withdraw_history_order_number_key ON withdraw_history (order_number);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX withdraw_history_order_number_key;
+CREATE INDEX withdraw_history_order_number_idx ON withdraw_history (order_number);
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- failures counts failed attempts in a row, attempts also grow with not final answers and rate limit pauses
+ALTER TABLE accrual_jobs
+    ADD COLUMN failures INT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE accrual_jobs
+    DROP COLUMN failures;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- parts of the lots a withdrawal consumed, a reversal returns them with their credit time,
+-- so reversed points expire as if they were never withdrawn. remaining is the part which isn't reversed yet
+CREATE TABLE withdrawal_lots
+(
+    id            SERIAL PRIMARY KEY,
+    withdrawal_id INT             NOT NULL REFERENCES withdraw_history,
+    amount        DECIMAL(36, 18) NOT NULL,
+    remaining     DECIMAL(36, 18) NOT NULL,
+    credited_at   TIMESTAMP       NOT NULL
+);
+
+CREATE INDEX withdrawal_lots_withdrawal_id_idx ON withdrawal_lots (withdrawal_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE withdrawal_lots;
+-- +goose StatementEnd
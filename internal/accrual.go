@@ -5,13 +5,24 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 
 	"github.com/DrGermanius/Gophermart/internal/model"
 )
@@ -20,125 +31,414 @@ import (
 
 type IAccrual interface {
 	Run()
-	SendToQueue(context.Context, int, string)
-	ProcessAccrual(context.Context, int, string)
+	Stop(context.Context) error
+	Notify()
+	ProcessAccrual(context.Context, int, string) error
+	Ping(context.Context) error
 }
 
+const (
+	accrualPollInterval = 1 * time.Second
+	accrualLeaseTimeout = 1 * time.Minute
+	accrualRetryDelay   = 1 * time.Second
+	// accrualNotRegisteredDelay is the wait for an order which accrual system doesn't know yet
+	accrualNotRegisteredDelay = 10 * time.Second
+	accrualDefaultRetryAfter  = 60 * time.Second
+)
+
+var accrualBackoff = Backoff{Base: 1 * time.Second, Max: 5 * time.Minute}
+
+// AccrualService polls accrual_jobs table, so orders waiting for accrual survive restarts
+// and can be shared between several instances. Jobs are processed by a pool of workers,
+// requests of all workers go through one limiter tuned by 429 answers of accrual system.
 type AccrualService struct {
-	repo   IRepository
-	url    string
-	ch     chan input
-	ctx    context.Context
-	logger *zap.SugaredLogger
+	repo    IRepository
+	url     string
+	client  *http.Client
+	workers int
+	limiter *rate.Limiter
+	wake    chan struct{}
+	ctx     context.Context
+	logger  *zap.SugaredLogger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+	inFlight    map[string]struct{}
 }
 
-func NewAccrualService(repo IRepository, url string, ctx context.Context, logger *zap.SugaredLogger) IAccrual {
-	s := &AccrualService{
-		repo:   repo,
-		url:    url,
-		ch:     make(chan input),
-		ctx:    ctx,
-		logger: logger,
+// NewAccrualService creates the service with given number of workers.
+// rateLimit is the initial limit of requests per minute, 0 means no limit until accrual system reports one.
+func NewAccrualService(repo IRepository, url string, workers int, rateLimit int, ctx context.Context, logger *zap.SugaredLogger) *AccrualService {
+	if workers < 1 {
+		workers = 1
 	}
 
-	go s.Run()
-	return s
-}
+	limit := rate.Inf
+	if rateLimit > 0 {
+		limit = perMinute(rateLimit)
+	}
 
-type input struct {
-	uid         int
-	orderNumber string
-	ctx         context.Context
+	return &AccrualService{
+		repo:     repo,
+		url:      url,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		workers:  workers,
+		limiter:  rate.NewLimiter(limit, 1),
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		logger:   logger,
+		inFlight: make(map[string]struct{}),
+	}
 }
 
-func (s AccrualService) Run() {
+// Run processes the queue until Stop is called or ctx of the service is done.
+func (s *AccrualService) Run() {
+	defer close(s.done)
+
+	jobs := make(chan model.AccrualJob)
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(jobs)
+		}()
+	}
+
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
 	for {
+		if s.stopped() {
+			s.logger.Info("accrual service is stopped")
+			return
+		}
+
+		// whole batch was leased, there are probably more due jobs
+		if s.dispatch(jobs) == s.workers && s.ctx.Err() == nil {
+			continue
+		}
+
+		wait := accrualPollInterval
+		if d := s.pausedFor(); d > wait {
+			wait = d
+		}
+		timer := time.NewTimer(wait)
+
 		select {
-		case v := <-s.ch:
-			s.ProcessAccrual(v.ctx, v.uid, v.orderNumber)
-			time.Sleep(1 * time.Second) // avoid too many requests
+		case <-s.wake:
+		case <-timer.C:
+		case <-s.stop:
 		case <-s.ctx.Done():
+			timer.Stop()
 			s.logger.Info("context is done")
 			return
 		}
+		timer.Stop()
+	}
+}
+
+// Stop makes Run lease no more jobs and waits until workers finish their current orders.
+// If ctx is done first, Stop returns its error, in-flight orders can be aborted by cancelling ctx of the service.
+func (s *AccrualService) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *AccrualService) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Notify makes Run poll the queue without waiting for the next tick.
+func (s *AccrualService) Notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch leases a batch of jobs and hands them to workers, it returns the number of leased jobs.
+func (s *AccrualService) dispatch(jobs chan<- model.AccrualJob) int {
+	if s.pausedFor() > 0 {
+		return 0
+	}
+
+	leased, err := s.repo.LeaseAccrualJobs(s.ctx, s.workers, accrualLeaseTimeout)
+	if err != nil {
+		s.logger.Errorf("LeaseAccrualJobs error: %s", err.Error())
+		return 0
+	}
+
+	for _, j := range leased {
+		// the lease of the order has expired while it is still processed,
+		// the job will be available again after the current attempt
+		if !s.acquire(j.OrderNumber) {
+			continue
+		}
+
+		select {
+		case jobs <- j:
+		case <-s.stop:
+			s.release(j.OrderNumber)
+			return len(leased) // leased jobs will be taken again after the lease expires
+		case <-s.ctx.Done():
+			s.release(j.OrderNumber)
+			return len(leased)
+		}
 	}
+
+	return len(leased)
 }
 
-func (s AccrualService) SendToQueue(ctx context.Context, uid int, orderNumber string) {
-	s.ch <- input{
-		uid:         uid,
-		orderNumber: orderNumber,
-		ctx:         ctx,
+func (s *AccrualService) work(jobs <-chan model.AccrualJob) {
+	for j := range jobs {
+		// accrual system asked to wait, give the job back to the queue
+		if d := s.pausedFor(); d > 0 {
+			s.reschedule(s.jobLogger(j), j, d, j.Failures, ErrTooManyRequests)
+		} else {
+			s.processJob(j)
+		}
+
+		s.release(j.OrderNumber)
 	}
 }
 
+func (s *AccrualService) processJob(j model.AccrualJob) {
+	logger := s.jobLogger(j)
+
+	// the attempt continues the trace of the order upload
+	ctx, span := startSpan(withLogger(extractTraceContext(s.ctx, j.TraceContext), logger), "AccrualService.ProcessAccrual",
+		append(orderAttributes(j.OrderNumber, j.UserID), attribute.Int("accrual.attempt", j.Attempts))...)
+
+	err := s.ProcessAccrual(ctx, j.UserID, j.OrderNumber)
+	// not final status is expected, the attempt is not failed
+	if errors.Is(err, ErrAccrualIsNotFinal) {
+		endSpan(span, nil)
+	} else {
+		endSpan(span, err)
+	}
+
+	if err == nil {
+		accrualLag.Observe(time.Since(j.CreatedAt).Seconds())
+		err = s.repo.CompleteAccrualJob(s.ctx, j.ID)
+		if err != nil {
+			logger.Errorf("CompleteAccrualJob error: %s", err.Error())
+		}
+		return
+	}
+
+	var rle *RateLimitError
+	switch {
+	case errors.As(err, &rle):
+		logger.Warnf("accrual system rate limit is exceeded, pausing for %s", rle.RetryAfter)
+		s.pause(rle.RetryAfter)
+		if rle.Limit > 0 && s.limiter.Limit() != perMinute(rle.Limit) {
+			logger.Infof("accrual system rate limit is set to %d requests per minute", rle.Limit)
+			s.limiter.SetLimit(perMinute(rle.Limit))
+		}
+		s.reschedule(logger, j, rle.RetryAfter, j.Failures, err)
+	case errors.Is(err, ErrAccrualIsNotFinal):
+		s.reschedule(logger, j, accrualRetryDelay, 0, err)
+	case errors.Is(err, ErrOrderIsNotRegistered):
+		// the order may be registered in accrual system later, it isn't a failure
+		logger.Infof("order is not registered in accrual system yet, retrying in %s", accrualNotRegisteredDelay)
+		s.reschedule(logger, j, accrualNotRegisteredDelay, 0, err)
+	case errors.Is(err, context.Canceled):
+		// shutting down, the lease will expire and the job will be taken again
+	default:
+		logger.Errorf("ProcessAccrual error: %s", err.Error())
+		// the backoff grows with failures only, leases of not final orders and pauses don't count
+		s.reschedule(logger, j, accrualBackoff.Delay(j.Failures+1), j.Failures+1, err)
+	}
+}
+
+// reschedule gives the job back to the queue, failures is the new number of failed attempts in a row.
+func (s *AccrualService) reschedule(logger *zap.SugaredLogger, j model.AccrualJob, delay time.Duration, failures int, cause error) {
+	err := s.repo.RescheduleAccrualJob(s.ctx, j.ID, delay, failures, cause.Error())
+	if err != nil {
+		logger.Errorf("RescheduleAccrualJob error: %s", err.Error())
+	}
+}
+
+// jobLogger returns the logger of the job, it carries the fields of the request which uploaded the order.
+func (s *AccrualService) jobLogger(j model.AccrualJob) *zap.SugaredLogger {
+	return s.logger.With(
+		"request_id", j.RequestID,
+		"user_id", j.UserID,
+		"order_number", j.OrderNumber,
+		"accrual_job_id", j.ID,
+		"attempt", j.Attempts,
+	)
+}
+
+// acquire marks the order as in flight, it returns false if the order is already processed by another worker.
+func (s *AccrualService) acquire(orderNumber string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.inFlight[orderNumber]; ok {
+		return false
+	}
+
+	s.inFlight[orderNumber] = struct{}{}
+	return true
+}
+
+func (s *AccrualService) release(orderNumber string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inFlight, orderNumber)
+}
+
+func (s *AccrualService) pause(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(s.pausedUntil) {
+		s.pausedUntil = until
+	}
+}
+
+func (s *AccrualService) pausedFor() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Until(s.pausedUntil)
+}
+
+func perMinute(n int) rate.Limit {
+	return rate.Limit(float64(n) / 60)
+}
+
+// Ping checks that accrual system answers HTTP requests, any status means it is reachable.
+func (s *AccrualService) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
 type accrualResponse struct {
 	Order   string          `json:"order"`
 	Status  string          `json:"status"`
 	Accrual decimal.Decimal `json:"accrual,omitempty"`
 }
 
-func (s AccrualService) ProcessAccrual(ctx context.Context, uid int, orderNumber string) {
-	body, err := s.makeRequest(orderNumber)
+// ProcessAccrual asks accrual system about the order and credits the user if the result is final.
+// The accrual of the system is multiplied by the multiplier of the user's tier.
+// ErrAccrualIsNotFinal means that the order has to be checked again later.
+func (s *AccrualService) ProcessAccrual(ctx context.Context, uid int, orderNumber string) error {
+	body, err := s.makeRequest(ctx, orderNumber)
 	if err != nil {
-		if errors.Is(err, ErrTooManyRequests) {
-			go s.SendToQueue(ctx, uid, orderNumber)
-		}
-		s.logger.Errorf("ProcessAccrual error: %s", err.Error())
-		return
+		return err
 	}
 
 	res := accrualResponse{}
 
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		s.logger.Errorf("json.Unmarshal ProcessAccrual error: %s", err.Error())
-		return
+		return err
 	}
 
 	if res.Status == model.OrderStatusRegistered || res.Status == model.OrderStatusProcessing {
 		err = s.repo.UpdateOrderStatus(ctx, orderNumber, res.Status)
 		if err != nil {
-			s.logger.Errorf("ProcessAccrual error: %s", err.Error())
-			return
+			return err
 		}
-		go s.SendToQueue(ctx, uid, orderNumber)
-		return
+		return ErrAccrualIsNotFinal
 	}
 
-	bw, err := s.repo.GetBalanceByUserID(ctx, uid)
-	if err != nil {
-		s.logger.Errorf("ProcessAccrual error: %s", err.Error())
-		return
+	if res.Status != model.OrderStatusInvalid && res.Status != model.OrderStatusProcessed {
+		return fmt.Errorf("unexpected accrual status %q", res.Status)
 	}
 
-	newBalance := bw.Balance.Add(res.Accrual)
+	// only the accrual itself counts toward the tier, the bonus of the tier is credited apart,
+	// so the tier doesn't boost its own qualification
+	accrual, tierBonus := res.Accrual, decimal.Zero
+	if res.Status == model.OrderStatusProcessed && accrual.IsPositive() {
+		tier, err := evaluateTier(ctx, s.repo, uid, time.Now())
+		if err != nil {
+			return err
+		}
+		tierBonus = accrual.Mul(tierRule(tier.Tier).Multiplier).Sub(accrual)
+	}
 
-	err = s.repo.MakeAccrual(ctx, uid, res.Status, orderNumber, res.Accrual, newBalance)
+	err = s.repo.MakeAccrual(ctx, uid, res.Status, orderNumber, accrual, tierBonus)
 	if err != nil {
-		s.logger.Errorf("ProcessAccrual error: %s", err.Error())
-		return
+		return err
+	}
+
+	// the accrual may lift the user to the next tier. The credit is already committed, so a failed evaluation
+	// doesn't fail the job, the tier is evaluated again later.
+	if res.Status == model.OrderStatusProcessed && accrual.IsPositive() {
+		_, err = evaluateTier(ctx, s.repo, uid, time.Now())
+		if err != nil {
+			loggerFromContext(ctx, s.logger).Errorf("evaluateTier error after accrual of order %s: %s", orderNumber, err.Error())
+		}
 	}
+
+	return nil
 }
 
-func (s AccrualService) makeRequest(orderNumber string) ([]byte, error) {
-	client := &http.Client{}
+func (s *AccrualService) makeRequest(ctx context.Context, orderNumber string) ([]byte, error) {
+	err := s.limiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := tracer.Start(ctx, "GET /api/orders/{number}", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPMethod(http.MethodGet), attribute.String("order.number", orderNumber)))
+	defer span.End()
 
 	url := s.url + "/api/orders/" + orderNumber
-	req, err := http.NewRequest(http.MethodGet, url, strings.NewReader(""))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, strings.NewReader(""))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Length", "0")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
-	res, err := client.Do(req)
+	res, err := s.client.Do(req)
 	if err != nil {
+		accrualRequests.WithLabelValues("error").Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
 	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return nil, ErrTooManyRequests
+	accrualRequests.WithLabelValues(strconv.Itoa(res.StatusCode)).Inc()
+	span.SetAttributes(semconv.HTTPStatusCode(res.StatusCode))
+	if res.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, fmt.Sprintf("status %d", res.StatusCode))
 	}
 
 	var buf bytes.Buffer
@@ -147,5 +447,61 @@ func (s AccrualService) makeRequest(orderNumber string) ([]byte, error) {
 		return nil, err
 	}
 
-	return buf.Bytes(), nil
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return buf.Bytes(), nil
+	case res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotFound:
+		return nil, ErrOrderIsNotRegistered
+	case res.StatusCode == http.StatusTooManyRequests:
+		return nil, newRateLimitError(res.Header.Get("Retry-After"), buf.String())
+	case res.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: status %d", ErrAccrualIsUnavailable, res.StatusCode)
+	default:
+		return nil, fmt.Errorf("unexpected accrual system status %d", res.StatusCode)
+	}
+}
+
+// RateLimitError is returned when accrual system answers with 429 Too Many Requests.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	// Limit is the number of requests per minute announced by accrual system, 0 if unknown.
+	Limit int
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: retry after %s, limit %d rpm", ErrTooManyRequests.Error(), e.RetryAfter, e.Limit)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+var rateLimitBodyRe = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+func newRateLimitError(retryAfter string, body string) *RateLimitError {
+	e := &RateLimitError{RetryAfter: parseRetryAfter(retryAfter)}
+
+	if m := rateLimitBodyRe.FindStringSubmatch(body); m != nil {
+		e.Limit, _ = strconv.Atoi(m[1])
+	}
+
+	return e
+}
+
+// parseRetryAfter supports both forms of Retry-After header: delay in seconds and HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+
+	return accrualDefaultRetryAfter
 }
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+const requestIDKey = "requestid"
+
+type apiError struct {
+	err    error
+	status int
+	code   string
+}
+
+// apiErrors maps sentinel errors to HTTP status and machine-readable code of the error response.
+var apiErrors = []apiError{
+	{ErrInvalidRequestBody, fiber.StatusBadRequest, "MALFORMED_BODY"},
+	{ErrInvalidContentType, fiber.StatusBadRequest, "UNSUPPORTED_CONTENT_TYPE"},
+	{ErrInvalidWithdrawSum, fiber.StatusBadRequest, "WITHDRAW_SUM_INVALID"},
+	{ErrInvalidQuery, fiber.StatusBadRequest, "INVALID_QUERY"},
+	{ErrInvalidReferralCode, fiber.StatusBadRequest, "INVALID_REFERRAL_CODE"},
+	{ErrInvalidTransferSum, fiber.StatusBadRequest, "TRANSFER_SUM_INVALID"},
+	{ErrSelfTransfer, fiber.StatusBadRequest, "SELF_TRANSFER"},
+	{ErrInvalidReversalSum, fiber.StatusBadRequest, "REVERSAL_SUM_INVALID"},
+	{ErrInvalidCredentials, fiber.StatusUnauthorized, "INVALID_CREDENTIALS"},
+	{ErrInvalidToken, fiber.StatusUnauthorized, "UNAUTHORIZED"},
+	{ErrInsufficientFunds, fiber.StatusPaymentRequired, "INSUFFICIENT_FUNDS"},
+	{ErrRecipientNotFound, fiber.StatusNotFound, "RECIPIENT_NOT_FOUND"},
+	{ErrWithdrawalNotFound, fiber.StatusNotFound, "WITHDRAWAL_NOT_FOUND"},
+	{ErrUserNotFound, fiber.StatusNotFound, "USER_NOT_FOUND"},
+	{ErrLoginIsAlreadyTaken, fiber.StatusConflict, "LOGIN_ALREADY_TAKEN"},
+	{ErrOrderIsAlreadySentByOtherUser, fiber.StatusConflict, "ORDER_OWNED_BY_OTHER_USER"},
+	{ErrOrderIsAlreadyWithdrawn, fiber.StatusConflict, "ORDER_ALREADY_WITHDRAWN"},
+	{ErrRequestBodyTooLarge, fiber.StatusRequestEntityTooLarge, "REQUEST_BODY_TOO_LARGE"},
+	{ErrUnsupportedContentEncoding, fiber.StatusUnsupportedMediaType, "UNSUPPORTED_CONTENT_ENCODING"},
+	{ErrOrderNumberIsNotNumeric, fiber.StatusUnprocessableEntity, "ORDER_NUMBER_NOT_NUMERIC"},
+	{ErrLuhnInvalid, fiber.StatusUnprocessableEntity, "ORDER_LUHN_INVALID"},
+	{ErrTransferLimitExceeded, fiber.StatusUnprocessableEntity, "TRANSFER_LIMIT_EXCEEDED"},
+	{ErrReversalExceedsWithdrawal, fiber.StatusUnprocessableEntity, "REVERSAL_EXCEEDS_WITHDRAWAL"},
+}
+
+// NewErrorHandler returns fiber error handler which answers with model.ErrorResponse,
+// handlers return errors and don't write error responses by themselves.
+func NewErrorHandler(logger *zap.SugaredLogger) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		status, code, message := resolveError(err)
+
+		l := loggerFromContext(c.UserContext(), logger)
+		if status >= fiber.StatusInternalServerError {
+			l.Errorf("Error on %s %s request: %s", c.Method(), c.Path(), err.Error())
+		} else {
+			l.Infof("Error on %s %s request: %s", c.Method(), c.Path(), err.Error())
+		}
+
+		requestID, _ := c.Locals(requestIDKey).(string)
+
+		return c.Status(status).JSON(model.ErrorResponse{
+			Error: model.ErrorBody{
+				Code:      code,
+				Message:   message,
+				RequestID: requestID,
+			},
+		})
+	}
+}
+
+func resolveError(err error) (int, string, string) {
+	for _, e := range apiErrors {
+		if errors.Is(err, e.err) {
+			return e.status, e.code, e.err.Error()
+		}
+	}
+
+	// errors of fiber itself, e.g. unknown route
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe.Code, errorCode(fe.Code), fe.Error()
+	}
+
+	// details of unexpected errors are only logged
+	return fiber.StatusInternalServerError, "INTERNAL_ERROR", "internal server error"
+}
+
+func errorCode(status int) string {
+	return strings.ToUpper(strings.ReplaceAll(utils.StatusMessage(status), " ", "_"))
+}
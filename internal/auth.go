@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	jwtIssuer   = "gophermart"
+	jwtAudience = "gophermart-api"
+)
+
+const principalKey = "principal"
+
+// Claims of the access token, the user id is kept in the subject.
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+}
+
+// Valid makes exp, nbf, iss and aud mandatory, RegisteredClaims checks them only if they are present.
+func (c Claims) Valid() error {
+	now := time.Now()
+
+	if !c.VerifyExpiresAt(now, true) {
+		return errors.New("token is expired")
+	}
+	if !c.VerifyNotBefore(now, true) {
+		return errors.New("token is not valid yet")
+	}
+	if !c.VerifyIssuer(jwtIssuer, true) {
+		return errors.New("token has invalid issuer")
+	}
+	if !c.VerifyAudience(jwtAudience, true) {
+		return errors.New("token has invalid audience")
+	}
+	if c.Subject == "" || c.SessionID == "" {
+		return errors.New("token has no subject or session")
+	}
+
+	return nil
+}
+
+// Principal is the authenticated user of the request.
+type Principal struct {
+	UserID    int
+	SessionID string
+}
+
+// Authorize checks the access token from Authorization header or cookie and stores Principal in c.Locals.
+// Tokens of revoked sessions are rejected.
+func (h *Handlers) Authorize(c *fiber.Ctx) error {
+	p, err := h.authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	c.Locals(principalKey, p)
+	trace.SpanFromContext(c.UserContext()).SetAttributes(attribute.Int("user.id", p.UserID))
+	c.SetUserContext(withLogFields(c.UserContext(), h.logger, "user_id", p.UserID, "route", c.Route().Path))
+	return c.Next()
+}
+
+// AuthorizeOperator checks the operator token from Authorization header. All requests are rejected
+// if the operator token isn't configured.
+func (h *Handlers) AuthorizeOperator(c *fiber.Ctx) error {
+	token := bearerToken(c.Get(fiber.HeaderAuthorization))
+	if h.operatorToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.operatorToken)) != 1 {
+		return ErrInvalidToken
+	}
+
+	c.SetUserContext(withLogFields(c.UserContext(), h.logger, "operator", true, "route", c.Route().Path))
+	return c.Next()
+}
+
+func (h *Handlers) authenticate(c *fiber.Ctx) (Principal, error) {
+	tokenString := bearerToken(c.Get(fiber.HeaderAuthorization))
+	if tokenString == "" {
+		tokenString = c.Cookies(accessTokenCookie)
+	}
+	if tokenString == "" {
+		return Principal{}, ErrInvalidToken
+	}
+
+	claims, err := ParseAccessToken(tokenString, h.secret)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+
+	uid, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return Principal{}, ErrInvalidToken
+	}
+
+	active, err := h.service.IsSessionActive(c.UserContext(), claims.SessionID)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !active {
+		return Principal{}, ErrInvalidToken
+	}
+
+	return Principal{UserID: uid, SessionID: claims.SessionID}, nil
+}
+
+// ParseAccessToken verifies the signature and the claims of the token, only HS256 is accepted.
+func ParseAccessToken(tokenString string, secret string) (Claims, error) {
+	var claims Claims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	_, err := parser.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return claims, nil
+}
+
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+		return strings.TrimSpace(header[len(prefix):])
+	}
+	return ""
+}
+
+// principal returns the user authenticated by Authorize.
+func principal(c *fiber.Ctx) Principal {
+	p, _ := c.Locals(principalKey).(Principal)
+	return p
+}
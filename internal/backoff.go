@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff calculates exponentially growing delays between retries.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Delay returns a delay before the next retry, attempts are counted from 1.
+// The result is randomized between half and full exponential delay, so retries of
+// many orders failed at once don't hit accrual system at the same moment.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Base
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
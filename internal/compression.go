@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// DefaultCompressMinSize is the size of responses which are worth compressing
+	DefaultCompressMinSize = 1024
+	// DefaultDecompressMaxSize limits decompressed request bodies, protects from decompression bombs
+	DefaultDecompressMaxSize = 1 << 20
+)
+
+// Compress compresses responses with brotli, gzip or deflate, the encoding is negotiated via Accept-Encoding.
+// Responses shorter than minSize are sent as is.
+func Compress(minSize int) fiber.Handler {
+	compressor := fasthttp.CompressHandlerBrotliLevel(func(*fasthttp.RequestCtx) {},
+		fasthttp.CompressBrotliDefaultCompression,
+		fasthttp.CompressDefaultCompression,
+	)
+
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		if len(c.Response().Body()) >= minSize {
+			compressor(c.Context())
+		}
+
+		return nil
+	}
+}
+
+// Decompress decodes request bodies sent with Content-Encoding gzip, deflate or br.
+// Bodies which are larger than maxSize after decompression are rejected.
+func Decompress(maxSize int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		encoding := strings.ToLower(strings.TrimSpace(c.Get(fiber.HeaderContentEncoding)))
+		if encoding == "" || encoding == "identity" {
+			return c.Next()
+		}
+
+		body, err := decompress(encoding, c.Request().Body(), maxSize)
+		if err != nil {
+			return err
+		}
+
+		c.Request().Header.Del(fiber.HeaderContentEncoding)
+		c.Request().SetBodyRaw(body)
+		return c.Next()
+	}
+}
+
+func decompress(encoding string, body []byte, maxSize int) ([]byte, error) {
+	var r io.Reader
+	var err error
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRequestBody, err.Error())
+	}
+
+	// one byte more than allowed tells that the body is too large
+	res, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRequestBody, err.Error())
+	}
+	if len(res) > maxSize {
+		return nil, ErrRequestBodyTooLarge
+	}
+
+	return res, nil
+}
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 var c *config
@@ -13,12 +15,37 @@ const (
 	DatabaseURI          = "DATABASE_URI"
 	AccrualSystemAddress = "ACCRUAL_SYSTEM_ADDRESS"
 	JWTSecret            = "JWT_Secret"
+	AccrualWorkers       = "ACCRUAL_WORKERS"
+	AccrualRateLimit     = "ACCRUAL_RATE_LIMIT"
+	AccrualBacklogLimit  = "ACCRUAL_BACKLOG_LIMIT"
+	ShutdownTimeout      = "SHUTDOWN_TIMEOUT"
+	ReadinessDrainDelay  = "READINESS_DRAIN_DELAY"
+	TracesExporter       = "TRACES_EXPORTER"
+	PointsTTL            = "POINTS_TTL_MONTHS"
+	PointsExpiryInterval = "POINTS_EXPIRY_INTERVAL"
+	TierReviewInterval   = "TIER_REVIEW_INTERVAL"
+	ReferralBonus        = "REFERRAL_BONUS"
+	ReferralCap          = "REFERRAL_CAP"
+	TransferDailyLimit   = "TRANSFER_DAILY_LIMIT"
+	OperatorToken        = "OPERATOR_TOKEN"
 )
 
 const (
 	defaultRunAddress           = "localhost:8081"
 	defaultAccrualSystemAddress = "http://localhost:8080"
 	defaultJWTSecret            = "secret"
+	defaultAccrualWorkers       = 4
+	defaultAccrualRateLimit     = 0
+	defaultAccrualBacklogLimit  = 10000
+	defaultShutdownTimeout      = 15 * time.Second
+	defaultReadinessDrainDelay  = 5 * time.Second
+	defaultTracesExporter       = TracesExporterNone
+	defaultPointsTTL            = 12
+	defaultPointsExpiryInterval = time.Hour
+	defaultTierReviewInterval   = time.Hour
+	defaultReferralBonus        = 100
+	defaultReferralCap          = 10
+	defaultTransferDailyLimit   = 1000
 )
 
 const (
@@ -33,6 +60,19 @@ type config struct {
 	DatabaseURI          string
 	AccrualSystemAddress string
 	JWTSecret            string
+	AccrualWorkers       int
+	AccrualRateLimit     int
+	AccrualBacklogLimit  int
+	ShutdownTimeout      time.Duration
+	ReadinessDrainDelay  time.Duration
+	TracesExporter       string
+	PointsTTL            int
+	PointsExpiryInterval time.Duration
+	TierReviewInterval   time.Duration
+	ReferralBonus        int
+	ReferralCap          int
+	TransferDailyLimit   int
+	OperatorToken        string
 }
 
 func NewConfig() *config {
@@ -46,6 +86,19 @@ func NewConfig() *config {
 	flag.StringVar(&c.DatabaseURI, "d", setEnvOrDefault(DatabaseURI, defaultConn), "postgres connection path")
 	flag.StringVar(&c.AccrualSystemAddress, "r", setEnvOrDefault(AccrualSystemAddress, defaultAccrualSystemAddress), "Accrual system address")
 	flag.StringVar(&c.JWTSecret, "s", setEnvOrDefault(JWTSecret, defaultJWTSecret), "JWT secret")
+	flag.IntVar(&c.AccrualWorkers, "w", setEnvOrDefaultInt(AccrualWorkers, defaultAccrualWorkers), "number of accrual workers")
+	flag.IntVar(&c.AccrualRateLimit, "l", setEnvOrDefaultInt(AccrualRateLimit, defaultAccrualRateLimit), "initial limit of requests per minute to accrual system, 0 means no limit")
+	flag.IntVar(&c.AccrualBacklogLimit, "b", setEnvOrDefaultInt(AccrualBacklogLimit, defaultAccrualBacklogLimit), "number of pending accrual jobs above which the service is not ready")
+	flag.DurationVar(&c.ShutdownTimeout, "t", setEnvOrDefaultDuration(ShutdownTimeout, defaultShutdownTimeout), "time to finish in-flight requests and accrual orders on shutdown")
+	flag.DurationVar(&c.ReadinessDrainDelay, "p", setEnvOrDefaultDuration(ReadinessDrainDelay, defaultReadinessDrainDelay), "time between failing readiness and stopping the server on shutdown, so the orchestrator stops sending requests")
+	flag.StringVar(&c.TracesExporter, "e", setEnvOrDefault(TracesExporter, defaultTracesExporter), "traces exporter: none, stdout or otlp")
+	flag.IntVar(&c.PointsTTL, "m", setEnvOrDefaultInt(PointsTTL, defaultPointsTTL), "number of months accrued points live, 0 means they don't expire")
+	flag.DurationVar(&c.PointsExpiryInterval, "i", setEnvOrDefaultDuration(PointsExpiryInterval, defaultPointsExpiryInterval), "how often expired points are taken away")
+	flag.DurationVar(&c.TierReviewInterval, "v", setEnvOrDefaultDuration(TierReviewInterval, defaultTierReviewInterval), "how often tiers whose accruals left the window are lowered")
+	flag.IntVar(&c.ReferralBonus, "f", setEnvOrDefaultInt(ReferralBonus, defaultReferralBonus), "points credited to both the referrer and the referee, 0 disables bonuses")
+	flag.IntVar(&c.ReferralCap, "c", setEnvOrDefaultInt(ReferralCap, defaultReferralCap), "number of referral bonuses of a referrer in 30 days, 0 means no cap")
+	flag.IntVar(&c.TransferDailyLimit, "x", setEnvOrDefaultInt(TransferDailyLimit, defaultTransferDailyLimit), "points a user may transfer to others in a UTC day, 0 means no limit")
+	flag.StringVar(&c.OperatorToken, "o", setEnvOrDefault(OperatorToken, ""), "token of the operator API, the API is disabled if it is empty")
 
 	flag.Parse()
 	return c
@@ -58,3 +111,29 @@ func setEnvOrDefault(env, def string) string {
 	}
 	return res
 }
+
+func setEnvOrDefaultInt(env string, def int) int {
+	v, e := os.LookupEnv(env)
+	if !e {
+		return def
+	}
+
+	res, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return res
+}
+
+func setEnvOrDefaultDuration(env string, def time.Duration) time.Duration {
+	v, e := os.LookupEnv(env)
+	if !e {
+		return def
+	}
+
+	res, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return res
+}
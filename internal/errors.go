@@ -11,4 +11,25 @@ var (
 	ErrLuhnInvalid                   = errors.New("number invalid by luhn")
 	ErrInsufficientFunds             = errors.New("insufficient funds")
 	ErrTooManyRequests               = errors.New("too many requests")
+	ErrAccrualIsNotFinal             = errors.New("accrual is not final yet")
+	ErrOrderIsNotRegistered          = errors.New("order is not registered in accrual system")
+	ErrAccrualIsUnavailable          = errors.New("accrual system is unavailable")
+	ErrInvalidToken                  = errors.New("invalid token")
+	ErrOrderNumberIsNotNumeric       = errors.New("order number is not numeric")
+	ErrInvalidRequestBody            = errors.New("malformed request body")
+	ErrInvalidContentType            = errors.New("unsupported content type")
+	ErrInvalidWithdrawSum            = errors.New("withdraw sum must be positive")
+	ErrUnsupportedContentEncoding    = errors.New("unsupported content encoding")
+	ErrRequestBodyTooLarge           = errors.New("request body is too large")
+	ErrInvalidQuery                  = errors.New("invalid query parameters")
+	ErrInvalidReferralCode           = errors.New("invalid referral code")
+	ErrInvalidTransferSum            = errors.New("transfer sum must be positive")
+	ErrSelfTransfer                  = errors.New("points can't be transferred to the sender")
+	ErrRecipientNotFound             = errors.New("recipient not found")
+	ErrTransferLimitExceeded         = errors.New("daily transfer limit is exceeded")
+	ErrInvalidReversalSum            = errors.New("reversal sum must be positive")
+	ErrWithdrawalNotFound            = errors.New("withdrawal not found")
+	ErrReversalExceedsWithdrawal     = errors.New("reversal exceeds the part of the withdrawal which isn't reversed")
+	ErrOrderIsAlreadyWithdrawn       = errors.New("order is already paid with points")
+	ErrUserNotFound                  = errors.New("user not found")
 )
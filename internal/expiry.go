@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ExpiringSoonWindow is the period for which the balance reports expiring points
+	ExpiringSoonWindow = 30 * 24 * time.Hour
+
+	pointsExpiryBatch = 100
+)
+
+// expiryCutoff returns the time before which lots had to be credited to be expired at the moment,
+// lots live ttl months. Zero ttl means points don't expire.
+func expiryCutoff(at time.Time, ttl int) time.Time {
+	return at.AddDate(0, -ttl, 0)
+}
+
+// PointsExpiry takes away points of accrual lots older than ttl months, it checks lots every interval.
+type PointsExpiry struct {
+	repo     IRepository
+	ttl      int
+	interval time.Duration
+	ctx      context.Context
+	logger   *zap.SugaredLogger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func NewPointsExpiry(repo IRepository, ttl int, interval time.Duration, ctx context.Context, logger *zap.SugaredLogger) *PointsExpiry {
+	return &PointsExpiry{
+		repo:     repo,
+		ttl:      ttl,
+		interval: interval,
+		ctx:      ctx,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run expires lots right away and then every interval until Stop is called or ctx of the job is done.
+func (e *PointsExpiry) Run() {
+	defer close(e.done)
+
+	if e.ttl <= 0 {
+		e.logger.Info("points don't expire, expiry job is disabled")
+		return
+	}
+
+	for {
+		_, err := e.Expire(e.ctx, time.Now())
+		if err != nil {
+			e.logger.Errorf("Expire error: %s", err.Error())
+		}
+
+		timer := time.NewTimer(e.interval)
+		select {
+		case <-timer.C:
+		case <-e.stop:
+			timer.Stop()
+			e.logger.Info("expiry job is stopped")
+			return
+		case <-e.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Expire takes away points of all lots which are expired at the moment, it returns the number of users who lost points.
+func (e *PointsExpiry) Expire(ctx context.Context, now time.Time) (int, error) {
+	cutoff := expiryCutoff(now, e.ttl)
+	users := 0
+
+	for {
+		uids, err := e.repo.GetUsersWithExpiredLots(ctx, cutoff, pointsExpiryBatch)
+		if err != nil {
+			return users, err
+		}
+
+		for _, uid := range uids {
+			expired, err := e.repo.ExpireLots(ctx, uid, cutoff)
+			if err != nil {
+				return users, err
+			}
+
+			e.logger.Infow("points expired", "user_id", uid, "points", expired)
+			pointsExpired.Add(expired.InexactFloat64())
+			users++
+		}
+
+		if len(uids) < pointsExpiryBatch {
+			return users, nil
+		}
+	}
+}
+
+// Stop makes Run return and waits for the current pass over lots unless ctx is done first.
+func (e *PointsExpiry) Stop(ctx context.Context) error {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
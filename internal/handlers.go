@@ -2,12 +2,8 @@ package internal
 
 import (
 	"errors"
-	"strconv"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 
 	"github.com/DrGermanius/Gophermart/internal/model"
@@ -16,193 +12,346 @@ import (
 type Handlers struct {
 	service IService
 	secret  string
-	logger  *zap.SugaredLogger
+	// operatorToken authorizes the operator API, it is disabled if the token is empty
+	operatorToken string
+	logger        *zap.SugaredLogger
 }
 
-func NewHandlers(Service IService, secret string, logger *zap.SugaredLogger) *Handlers {
-	return &Handlers{service: Service, secret: secret, logger: logger}
+func NewHandlers(Service IService, secret, operatorToken string, logger *zap.SugaredLogger) *Handlers {
+	return &Handlers{service: Service, secret: secret, operatorToken: operatorToken, logger: logger}
+}
+
+// log returns the logger of the request, it carries request id, user id and route.
+func (h *Handlers) log(c *fiber.Ctx) *zap.SugaredLogger {
+	return loggerFromContext(c.UserContext(), h.logger)
 }
 
 func (h *Handlers) Login(c *fiber.Ctx) error {
 	var i model.LoginInput
 
-	if err := c.BodyParser(&i); err != nil {
-		h.logger.Errorf("Error on login request: %s", err.Error())
-		return c.SendStatus(fiber.StatusBadRequest)
+	if err := c.BodyParser(&i); err != nil || i.Login == "" || i.Password == "" {
+		return ErrInvalidRequestBody
 	}
 
-	t, err := h.service.Login(c.Context(), i.Login, i.Password)
+	t, err := h.service.Login(c.UserContext(), i.Login, i.Password)
 	if err != nil {
-		h.logger.Errorf("Error on login request: %s", err.Error())
-		if errors.Is(err, ErrInvalidCredentials) {
-			return c.SendStatus(fiber.StatusUnauthorized)
-		}
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return err
 	}
 
-	setAuthCookie(c, t)
+	setAuthCookies(c, t)
 	return c.SendStatus(fiber.StatusOK)
 }
 
 func (h *Handlers) Register(c *fiber.Ctx) error {
 	var i model.LoginInput
 
-	if err := c.BodyParser(&i); err != nil {
-		h.logger.Errorf("Error on register request: %s", err.Error())
-		return c.SendStatus(fiber.StatusBadRequest)
+	if err := c.BodyParser(&i); err != nil || i.Login == "" || i.Password == "" {
+		return ErrInvalidRequestBody
 	}
 
-	t, err := h.service.Register(c.Context(), i.Login, i.Password)
+	t, err := h.service.Register(c.UserContext(), i.Login, i.Password, i.ReferralCode)
 	if err != nil {
-		h.logger.Errorf("Error on register request: %s", err.Error())
-		if errors.Is(err, ErrLoginIsAlreadyTaken) {
-			return c.SendStatus(fiber.StatusConflict)
-		}
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return err
 	}
 
-	setAuthCookie(c, t)
+	setAuthCookies(c, t)
 	return c.SendStatus(fiber.StatusOK)
 }
 
 func (h *Handlers) CreateOrder(c *fiber.Ctx) error {
-	uid, err := h.getUserIDFromToken(c)
-	if err != nil {
-		h.logger.Errorf("Error on CreateOrder request: %s", err.Error())
-		return c.SendStatus(fiber.StatusUnauthorized)
-	}
+	uid := principal(c).UserID
 
 	if c.GetReqHeaders()["Content-Type"] != "text/plain" {
-		h.logger.Errorf("Error on CreateOrder request: %s", "incorrect Content-Type")
-		return c.SendStatus(fiber.StatusBadRequest)
+		return ErrInvalidContentType
 	}
 
 	orderNumber := string(c.Body())
-	err = h.service.SendOrder(c.Context(), orderNumber, uid)
+	if orderNumber == "" {
+		return ErrInvalidRequestBody
+	}
+
+	err := h.service.SendOrder(c.UserContext(), orderNumber, uid)
+	if errors.Is(err, ErrOrderIsAlreadySent) {
+		return c.SendStatus(fiber.StatusOK)
+	}
 	if err != nil {
-		h.logger.Errorf("Error on CreateOrder request: %s", err.Error())
-		if errors.Is(err, ErrLuhnInvalid) {
-			return c.SendStatus(fiber.StatusUnprocessableEntity)
-		}
-		if errors.Is(err, ErrOrderIsAlreadySent) {
-			return c.SendStatus(fiber.StatusOK)
-		}
-		if errors.Is(err, ErrOrderIsAlreadySentByOtherUser) {
-			return c.SendStatus(fiber.StatusConflict)
-		}
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return err
 	}
 
 	return c.SendStatus(fiber.StatusAccepted)
 }
 
 func (h *Handlers) GetOrders(c *fiber.Ctx) error {
-	uid, err := h.getUserIDFromToken(c)
+	uid := principal(c).UserID
+
+	q, err := parsePageQuery(c, true)
 	if err != nil {
-		return c.SendStatus(fiber.StatusUnauthorized)
+		return err
 	}
 
-	orders, err := h.service.GetOrders(c.Context(), uid)
+	page, err := h.service.GetOrders(c.UserContext(), uid, q)
+	if errors.Is(err, ErrNoRecords) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
 	if err != nil {
-		h.logger.Errorf("Error on GetOrders request: %s", err.Error())
-		if errors.Is(err, ErrNoRecords) {
-			return c.SendStatus(fiber.StatusNoContent)
-		}
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return err
 	}
 
-	h.logger.Infof("ORDERS: %s", orders)
-	return c.Status(fiber.StatusOK).JSON(orders)
+	h.log(c).Debugw("orders", "orders", page.Orders)
+	setNextPage(c, page.Next)
+	return c.Status(fiber.StatusOK).JSON(page.Orders)
 }
 
 func (h *Handlers) GetBalance(c *fiber.Ctx) error {
-	uid, err := h.getUserIDFromToken(c)
-	if err != nil {
-		h.logger.Errorf("Error on GetBalance request: %s", err.Error())
-		return c.SendStatus(fiber.StatusUnauthorized)
-	}
+	uid := principal(c).UserID
 
-	bw, err := h.service.GetBalanceByUserID(c.Context(), uid)
+	bw, err := h.service.GetBalanceByUserID(c.UserContext(), uid)
 	if err != nil {
-		h.logger.Errorf("Error on GetBalance request: %s", err.Error())
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return err
 	}
 
-	h.logger.Infof("BALANCE: %s", bw)
+	h.log(c).Debugw("balance", "balance", bw)
 	return c.Status(fiber.StatusOK).JSON(bw)
 }
 
 func (h *Handlers) Withdraw(c *fiber.Ctx) error {
-	uid, err := h.getUserIDFromToken(c)
+	uid := principal(c).UserID
+
+	var i model.WithdrawInput
+
+	if err := c.BodyParser(&i); err != nil || i.OrderNumber == "" {
+		return ErrInvalidRequestBody
+	}
+	if !i.Sum.IsPositive() {
+		return ErrInvalidWithdrawSum
+	}
+
+	err := h.service.Withdraw(c.UserContext(), i, uid)
 	if err != nil {
-		h.logger.Errorf("Error on Withdraw request: %s", err.Error())
-		return c.SendStatus(fiber.StatusUnauthorized)
+		return err
 	}
 
-	var i model.WithdrawInput
+	return c.SendStatus(fiber.StatusOK)
+}
 
-	if err = c.BodyParser(&i); err != nil || i.OrderNumber == "" || i.Sum.Equal(decimal.NewFromInt(0)) {
-		return c.SendStatus(fiber.StatusBadRequest)
+func (h *Handlers) Transfer(c *fiber.Ctx) error {
+	uid := principal(c).UserID
+
+	var i model.TransferInput
+
+	if err := c.BodyParser(&i); err != nil || i.Login == "" {
+		return ErrInvalidRequestBody
+	}
+	if !i.Sum.IsPositive() {
+		return ErrInvalidTransferSum
 	}
 
-	err = h.service.Withdraw(c.Context(), i, uid)
+	err := h.service.Transfer(c.UserContext(), i, uid)
 	if err != nil {
-		h.logger.Errorf("Error on Withdraw request: %s", err.Error())
-		if errors.Is(err, ErrLuhnInvalid) {
-			return c.SendStatus(fiber.StatusUnprocessableEntity)
-		}
-		if errors.Is(err, ErrInsufficientFunds) {
-			return c.SendStatus(fiber.StatusPaymentRequired)
-		}
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return err
 	}
 
 	return c.SendStatus(fiber.StatusOK)
 }
 
+func (h *Handlers) TransferHistory(c *fiber.Ctx) error {
+	uid := principal(c).UserID
+
+	q, err := parsePageQuery(c, false)
+	if err != nil {
+		return err
+	}
+
+	page, err := h.service.GetTransferHistory(c.UserContext(), uid, q)
+	if errors.Is(err, ErrNoRecords) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	if err != nil {
+		return err
+	}
+
+	h.log(c).Debugw("transfers", "transfers", page.Transfers)
+	setNextPage(c, page.Next)
+	return c.Status(fiber.StatusOK).JSON(page.Transfers)
+}
+
 func (h *Handlers) WithdrawHistory(c *fiber.Ctx) error {
-	uid, err := h.getUserIDFromToken(c)
+	uid := principal(c).UserID
+
+	q, err := parsePageQuery(c, false)
+	if err != nil {
+		return err
+	}
+
+	page, err := h.service.GetWithdrawHistory(c.UserContext(), uid, q)
+	if errors.Is(err, ErrNoRecords) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	if err != nil {
+		return err
+	}
+
+	h.log(c).Debugw("withdrawals", "withdrawals", page.Withdrawals)
+	setNextPage(c, page.Next)
+	return c.Status(fiber.StatusOK).JSON(page.Withdrawals)
+}
+
+func (h *Handlers) GetTier(c *fiber.Ctx) error {
+	uid := principal(c).UserID
+
+	t, err := h.service.GetTier(c.UserContext(), uid)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(t)
+}
+
+func (h *Handlers) GetReferrals(c *fiber.Ctx) error {
+	uid := principal(c).UserID
+
+	q, err := parsePageQuery(c, false)
 	if err != nil {
-		h.logger.Errorf("Error on WithdrawHistory request: %s", err.Error())
-		return c.SendStatus(fiber.StatusUnauthorized)
+		return err
 	}
 
-	wh, err := h.service.GetWithdrawHistory(c.Context(), uid)
+	page, err := h.service.GetReferrals(c.UserContext(), uid, q)
 	if err != nil {
-		h.logger.Errorf("Error on WithdrawHistory request: %s", err.Error())
-		if errors.Is(err, ErrNoRecords) {
-			return c.SendStatus(fiber.StatusNoContent)
+		return err
+	}
+
+	out := model.ReferralsOutput{Code: page.Code, Referrals: page.Referrals}
+	if out.Referrals == nil {
+		out.Referrals = []model.ReferralOutput{}
+	}
+
+	setNextPage(c, page.Next)
+	return c.Status(fiber.StatusOK).JSON(out)
+}
+
+func (h *Handlers) ReverseWithdrawal(c *fiber.Ctx) error {
+	var i model.ReversalInput
+
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&i); err != nil {
+			return ErrInvalidRequestBody
 		}
-		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	if i.Sum != nil && !i.Sum.IsPositive() {
+		return ErrInvalidReversalSum
+	}
+	i.OrderNumber = c.Params("number")
 
-	h.logger.Infof("WITHDRAWS: %s", wh)
-	return c.Status(fiber.StatusOK).JSON(wh)
+	out, err := h.service.ReverseWithdrawal(c.UserContext(), i)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(out)
 }
 
-func setAuthCookie(c *fiber.Ctx, token string) {
-	cookie := &fiber.Cookie{
-		Name:    "token",
-		Value:   token,
-		Path:    "/",
-		MaxAge:  100,
-		Expires: time.Now().Add(24 * time.Hour),
+func (h *Handlers) RefreshToken(c *fiber.Ctx) error {
+	refreshToken := c.Cookies(refreshTokenCookie)
+	if refreshToken == "" {
+		var i model.RefreshInput
+		if err := c.BodyParser(&i); err != nil {
+			return ErrInvalidRequestBody
+		}
+		refreshToken = i.RefreshToken
 	}
 
-	c.Cookie(cookie)
+	t, err := h.service.RefreshTokens(c.UserContext(), refreshToken)
+	if err != nil {
+		return err
+	}
+
+	setAuthCookies(c, t)
+	return c.Status(fiber.StatusOK).JSON(t)
 }
 
-func (h *Handlers) getUserIDFromToken(c *fiber.Ctx) (int, error) {
-	tokenString := c.Cookies("token")
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(h.secret), nil
-	})
+// Logout revokes the session of the refresh token from refresh_token cookie or from the body, so the client
+// can log out after the access token has expired. Without the refresh token the access token is required.
+func (h *Handlers) Logout(c *fiber.Ctx) error {
+	refreshToken := c.Cookies(refreshTokenCookie)
+	if refreshToken == "" && len(c.Body()) > 0 {
+		var i model.RefreshInput
+		if err := c.BodyParser(&i); err != nil {
+			return ErrInvalidRequestBody
+		}
+		refreshToken = i.RefreshToken
+	}
+
+	var err error
+	if refreshToken != "" {
+		err = h.service.LogoutByRefreshToken(c.UserContext(), refreshToken)
+	} else {
+		err = h.logoutByAccessToken(c)
+	}
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	id := claims["id"].(string)
+	clearAuthCookies(c)
+	return c.SendStatus(fiber.StatusOK)
+}
 
-	return strconv.Atoi(id)
+func (h *Handlers) logoutByAccessToken(c *fiber.Ctx) error {
+	p, err := h.authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	return h.service.Logout(c.UserContext(), p.SessionID)
+}
+
+func (h *Handlers) LogoutAll(c *fiber.Ctx) error {
+	uid := principal(c).UserID
+
+	err := h.service.LogoutAll(c.UserContext(), uid)
+	if err != nil {
+		return err
+	}
+
+	clearAuthCookies(c)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+const (
+	accessTokenCookie  = "token"
+	refreshTokenCookie = "refresh_token"
+	refreshTokenPath   = apiPrefix
+)
+
+func setAuthCookies(c *fiber.Ctx, t model.Tokens) {
+	c.Cookie(&fiber.Cookie{
+		Name:     accessTokenCookie,
+		Value:    t.AccessToken,
+		Path:     "/",
+		MaxAge:   int(AccessTokenTTL.Seconds()),
+		HTTPOnly: true,
+	})
+
+	c.Cookie(&fiber.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    t.RefreshToken,
+		Path:     refreshTokenPath,
+		MaxAge:   int(RefreshTokenTTL.Seconds()),
+		HTTPOnly: true,
+	})
+}
+
+func clearAuthCookies(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     accessTokenCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HTTPOnly: true,
+	})
+
+	c.Cookie(&fiber.Cookie{
+		Name:     refreshTokenCookie,
+		Path:     refreshTokenPath,
+		MaxAge:   -1,
+		HTTPOnly: true,
+	})
 }
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// Health reports to the orchestrator whether the process is alive and whether it can serve requests.
+type Health struct {
+	repo             IRepository
+	accrual          IAccrual
+	migrationVersion int64
+	backlogLimit     int
+	logger           *zap.SugaredLogger
+
+	shuttingDown int32
+}
+
+// NewHealth creates the health reporter. migrationVersion is the latest migration the binary expects,
+// backlogLimit is the number of accrual jobs above which the service is not ready.
+func NewHealth(repo IRepository, accrual IAccrual, migrationVersion int64, backlogLimit int, logger *zap.SugaredLogger) *Health {
+	return &Health{
+		repo:             repo,
+		accrual:          accrual,
+		migrationVersion: migrationVersion,
+		backlogLimit:     backlogLimit,
+		logger:           logger,
+	}
+}
+
+// RegisterHealthRoutes mounts /healthz and /readyz. They have to be registered before RegisterRoutes,
+// which answers unknown routes.
+func RegisterHealthRoutes(app *fiber.App, h *Health) {
+	app.Get("/healthz", h.Liveness)
+	app.Get("/readyz", h.Readiness)
+}
+
+// Shutdown makes readiness fail, so the orchestrator stops sending requests before the server stops.
+func (h *Health) Shutdown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+// Liveness answers while the process is able to handle requests, it doesn't check dependencies.
+func (h *Health) Liveness(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(model.Health{Status: model.HealthStatusOK})
+}
+
+// Readiness answers 503 if any dependency check fails or the service is shutting down.
+func (h *Health) Readiness(c *fiber.Ctx) error {
+	res := h.Check(c.Context())
+	if res.Status != model.HealthStatusOK {
+		h.logger.Warnf("Service is not ready: %v", res.Checks)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(res)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// Check runs all dependency checks in parallel, every check is limited by healthCheckTimeout.
+func (h *Health) Check(ctx context.Context) model.Health {
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		return model.Health{
+			Status: model.HealthStatusFail,
+			Checks: map[string]model.HealthCheck{
+				"shutdown": {Status: model.HealthStatusFail, Error: "service is shutting down"},
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) (string, error){
+		"database":        h.checkDatabase,
+		"migrations":      h.checkMigrations,
+		"accrual":         h.checkAccrual,
+		"accrual_backlog": h.checkBacklog,
+	}
+
+	res := model.Health{Status: model.HealthStatusOK, Checks: make(map[string]model.HealthCheck, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) (string, error)) {
+			defer wg.Done()
+
+			detail, err := check(ctx)
+			hc := model.HealthCheck{Status: model.HealthStatusOK, Detail: detail}
+			if err != nil {
+				hc.Status = model.HealthStatusFail
+				hc.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[name] = hc
+			if err != nil {
+				res.Status = model.HealthStatusFail
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return res
+}
+
+func (h *Health) checkDatabase(ctx context.Context) (string, error) {
+	return "", h.repo.Ping(ctx)
+}
+
+func (h *Health) checkMigrations(ctx context.Context) (string, error) {
+	version, err := h.repo.GetMigrationVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	detail := fmt.Sprintf("version %d", version)
+	if version < h.migrationVersion {
+		return detail, fmt.Errorf("database is at version %d, service expects %d", version, h.migrationVersion)
+	}
+	return detail, nil
+}
+
+func (h *Health) checkAccrual(ctx context.Context) (string, error) {
+	return "", h.accrual.Ping(ctx)
+}
+
+func (h *Health) checkBacklog(ctx context.Context) (string, error) {
+	n, err := h.repo.CountAccrualJobs(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	detail := fmt.Sprintf("%d of %d jobs", n, h.backlogLimit)
+	if n > h.backlogLimit {
+		return detail, fmt.Errorf("accrual backlog exceeds %d jobs", h.backlogLimit)
+	}
+	return detail, nil
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+type requestIDCtxKey struct{}
+
+// RequestLogger puts a logger with the request id into the user context of the request and logs the request
+// when it is finished. It must be used after requestid middleware.
+func RequestLogger(logger *zap.SugaredLogger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		requestID, _ := c.Locals(requestIDKey).(string)
+
+		l := logger.With("request_id", requestID)
+		ctx := context.WithValue(c.UserContext(), requestIDCtxKey{}, requestID)
+		c.SetUserContext(withLogger(ctx, l))
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status, _, _ = resolveError(err)
+		}
+
+		loggerFromContext(c.UserContext(), logger).Infow("request",
+			"method", c.Method(),
+			"route", routeOf(c),
+			"status", status,
+			"duration", time.Since(start),
+		)
+		return err
+	}
+}
+
+// withLogger returns a copy of ctx carrying the logger, later layers log with it.
+func withLogger(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// withLogFields adds key-value pairs to the logger of ctx.
+func withLogFields(ctx context.Context, fallback *zap.SugaredLogger, keysAndValues ...interface{}) context.Context {
+	return withLogger(ctx, loggerFromContext(ctx, fallback).With(keysAndValues...))
+}
+
+// loggerFromContext returns the logger of ctx or fallback if ctx has none, e.g. in background jobs.
+func loggerFromContext(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return l
+	}
+	return fallback
+}
+
+// requestIDFromContext returns the id of the request which started ctx, it is empty outside requests.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return id
+}
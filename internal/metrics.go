@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"go.uber.org/zap"
+)
+
+const (
+	metricsNamespace = "gophermart"
+	// unmatchedRoute labels requests which didn't reach a route, so unknown paths don't blow up cardinality
+	unmatchedRoute        = "unmatched"
+	metricsCollectTimeout = 5 * time.Second
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	accrualRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "accrual_requests_total",
+		Help:      "Requests to accrual system by status code, error means no answer.",
+	}, []string{"code"})
+
+	accrualLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "accrual_lag_seconds",
+		Help:      "Time from upload of an order to its final accrual status.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 4 * 3600, 24 * 3600},
+	})
+
+	pointsAccrued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "points_accrued_total",
+		Help:      "Points credited to users for processed orders.",
+	})
+
+	pointsWithdrawn = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "points_withdrawn_total",
+		Help:      "Points withdrawn by users.",
+	})
+
+	pointsReversed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "points_reversed_total",
+		Help:      "Points returned to users by reversals of withdrawals.",
+	})
+
+	pointsTransferred = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "points_transferred_total",
+		Help:      "Points transferred between users.",
+	})
+
+	pointsExpired = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "points_expired_total",
+		Help:      "Points of accrual lots which expired unspent.",
+	})
+
+	referralsRewarded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "referrals_rewarded_total",
+		Help:      "Referrals whose bonuses were credited after the first processed order of the referee.",
+	})
+)
+
+// Metrics counts requests and measures their latency by route template, e.g. /api/user/orders.
+func Metrics() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		err := c.Next()
+
+		// the error handler writes the status after the middleware returns
+		status := c.Response().StatusCode()
+		if err != nil {
+			status, _, _ = resolveError(err)
+		}
+
+		route := routeOf(c)
+
+		httpRequests.WithLabelValues(c.Method(), route, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(c.Method(), route).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// routeOf returns the route template of the request. Middlewares and the catch-all of RegisterRoutes
+// are mounted at /, there is no endpoint at /, so such requests didn't reach a route.
+func routeOf(c *fiber.Ctx) string {
+	route := c.Route().Path
+	if route == "/" {
+		return unmatchedRoute
+	}
+	return route
+}
+
+// RegisterMetricsRoutes mounts /metrics, it has to be registered before RegisterRoutes like health routes.
+func RegisterMetricsRoutes(app *fiber.App, gatherer prometheus.Gatherer) {
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	})
+}
+
+// QueueCollector reports orders waiting for accrual on every scrape.
+type QueueCollector struct {
+	repo   IRepository
+	logger *zap.SugaredLogger
+
+	orders    *prometheus.Desc
+	oldest    *prometheus.Desc
+	jobs      *prometheus.Desc
+	scrapeErr *prometheus.Desc
+}
+
+func NewQueueCollector(repo IRepository, logger *zap.SugaredLogger) *QueueCollector {
+	return &QueueCollector{
+		repo:   repo,
+		logger: logger,
+		orders: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "orders_pending"),
+			"Orders without final accrual status by status.", []string{"status"}, nil),
+		oldest: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "orders_pending_oldest_age_seconds"),
+			"Age of the oldest order without final accrual status by status.", []string{"status"}, nil),
+		jobs: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "accrual_queue_depth"),
+			"Jobs in the accrual queue.", nil, nil),
+		scrapeErr: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "queue_scrape_error"),
+			"1 if the queue metrics couldn't be read from the database.", nil, nil),
+	}
+}
+
+func (q *QueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- q.orders
+	ch <- q.oldest
+	ch <- q.jobs
+	ch <- q.scrapeErr
+}
+
+func (q *QueueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsCollectTimeout)
+	defer cancel()
+
+	failed := 0.0
+
+	stats, err := q.repo.GetPendingOrderStats(ctx)
+	if err != nil {
+		q.logger.Errorf("GetPendingOrderStats error: %s", err.Error())
+		failed = 1
+	}
+	for _, s := range stats {
+		ch <- prometheus.MustNewConstMetric(q.orders, prometheus.GaugeValue, float64(s.Count), s.Status)
+		ch <- prometheus.MustNewConstMetric(q.oldest, prometheus.GaugeValue, time.Since(s.OldestUploadedAt).Seconds(), s.Status)
+	}
+
+	jobs, err := q.repo.CountAccrualJobs(ctx)
+	if err != nil {
+		q.logger.Errorf("CountAccrualJobs error: %s", err.Error())
+		failed = 1
+	} else {
+		ch <- prometheus.MustNewConstMetric(q.jobs, prometheus.GaugeValue, float64(jobs))
+	}
+
+	ch <- prometheus.MustNewConstMetric(q.scrapeErr, prometheus.GaugeValue, failed)
+}
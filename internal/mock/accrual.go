@@ -34,10 +34,38 @@ func (m *MockIAccrual) EXPECT() *MockIAccrualMockRecorder {
 	return m.recorder
 }
 
+// Notify mocks base method.
+func (m *MockIAccrual) Notify() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Notify")
+}
+
+// Notify indicates an expected call of Notify.
+func (mr *MockIAccrualMockRecorder) Notify() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockIAccrual)(nil).Notify))
+}
+
+// Ping mocks base method.
+func (m *MockIAccrual) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockIAccrualMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockIAccrual)(nil).Ping), arg0)
+}
+
 // ProcessAccrual mocks base method.
-func (m *MockIAccrual) ProcessAccrual(arg0 context.Context, arg1 int, arg2 string) {
+func (m *MockIAccrual) ProcessAccrual(arg0 context.Context, arg1 int, arg2 string) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "ProcessAccrual", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "ProcessAccrual", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // ProcessAccrual indicates an expected call of ProcessAccrual.
@@ -58,14 +86,16 @@ func (mr *MockIAccrualMockRecorder) Run() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockIAccrual)(nil).Run))
 }
 
-// SendToQueue mocks base method.
-func (m *MockIAccrual) SendToQueue(arg0 context.Context, arg1 int, arg2 string) {
+// Stop mocks base method.
+func (m *MockIAccrual) Stop(arg0 context.Context) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "SendToQueue", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "Stop", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// SendToQueue indicates an expected call of SendToQueue.
-func (mr *MockIAccrualMockRecorder) SendToQueue(arg0, arg1, arg2 interface{}) *gomock.Call {
+// Stop indicates an expected call of Stop.
+func (mr *MockIAccrualMockRecorder) Stop(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendToQueue", reflect.TypeOf((*MockIAccrual)(nil).SendToQueue), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockIAccrual)(nil).Stop), arg0)
 }
@@ -7,6 +7,7 @@ package mock_internal
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	model "github.com/DrGermanius/Gophermart/internal/model"
 	gomock "github.com/golang/mock/gomock"
@@ -36,19 +37,62 @@ func (m *MockIRepository) EXPECT() *MockIRepositoryMockRecorder {
 	return m.recorder
 }
 
-// CheckCredentials mocks base method.
-func (m *MockIRepository) CheckCredentials(arg0 context.Context, arg1, arg2 string) (int, error) {
+// CompleteAccrualJob mocks base method.
+func (m *MockIRepository) CompleteAccrualJob(arg0 context.Context, arg1 int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CheckCredentials", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "CompleteAccrualJob", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteAccrualJob indicates an expected call of CompleteAccrualJob.
+func (mr *MockIRepositoryMockRecorder) CompleteAccrualJob(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteAccrualJob", reflect.TypeOf((*MockIRepository)(nil).CompleteAccrualJob), arg0, arg1)
+}
+
+// CountAccrualJobs mocks base method.
+func (m *MockIRepository) CountAccrualJobs(arg0 context.Context) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountAccrualJobs", arg0)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// CheckCredentials indicates an expected call of CheckCredentials.
-func (mr *MockIRepositoryMockRecorder) CheckCredentials(arg0, arg1, arg2 interface{}) *gomock.Call {
+// CountAccrualJobs indicates an expected call of CountAccrualJobs.
+func (mr *MockIRepositoryMockRecorder) CountAccrualJobs(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckCredentials", reflect.TypeOf((*MockIRepository)(nil).CheckCredentials), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountAccrualJobs", reflect.TypeOf((*MockIRepository)(nil).CountAccrualJobs), arg0)
+}
+
+// CreateSession mocks base method.
+func (m *MockIRepository) CreateSession(arg0 context.Context, arg1 model.Session, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSession", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateSession indicates an expected call of CreateSession.
+func (mr *MockIRepositoryMockRecorder) CreateSession(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSession", reflect.TypeOf((*MockIRepository)(nil).CreateSession), arg0, arg1, arg2)
+}
+
+// ExpireLots mocks base method.
+func (m *MockIRepository) ExpireLots(arg0 context.Context, arg1 int, arg2 time.Time) (decimal.Decimal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExpireLots", arg0, arg1, arg2)
+	ret0, _ := ret[0].(decimal.Decimal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExpireLots indicates an expected call of ExpireLots.
+func (mr *MockIRepositoryMockRecorder) ExpireLots(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpireLots", reflect.TypeOf((*MockIRepository)(nil).ExpireLots), arg0, arg1, arg2)
 }
 
 // GetBalanceByUserID mocks base method.
@@ -66,6 +110,36 @@ func (mr *MockIRepositoryMockRecorder) GetBalanceByUserID(arg0, arg1 interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalanceByUserID", reflect.TypeOf((*MockIRepository)(nil).GetBalanceByUserID), arg0, arg1)
 }
 
+// GetExpiringPoints mocks base method.
+func (m *MockIRepository) GetExpiringPoints(arg0 context.Context, arg1 int, arg2 time.Time) (decimal.Decimal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpiringPoints", arg0, arg1, arg2)
+	ret0, _ := ret[0].(decimal.Decimal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpiringPoints indicates an expected call of GetExpiringPoints.
+func (mr *MockIRepositoryMockRecorder) GetExpiringPoints(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpiringPoints", reflect.TypeOf((*MockIRepository)(nil).GetExpiringPoints), arg0, arg1, arg2)
+}
+
+// GetMigrationVersion mocks base method.
+func (m *MockIRepository) GetMigrationVersion(arg0 context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMigrationVersion", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMigrationVersion indicates an expected call of GetMigrationVersion.
+func (mr *MockIRepositoryMockRecorder) GetMigrationVersion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMigrationVersion", reflect.TypeOf((*MockIRepository)(nil).GetMigrationVersion), arg0)
+}
+
 // GetOrderByNumber mocks base method.
 func (m *MockIRepository) GetOrderByNumber(arg0 context.Context, arg1 string) (model.Order, error) {
 	m.ctrl.T.Helper()
@@ -82,33 +156,168 @@ func (mr *MockIRepositoryMockRecorder) GetOrderByNumber(arg0, arg1 interface{})
 }
 
 // GetOrders mocks base method.
-func (m *MockIRepository) GetOrders(arg0 context.Context, arg1 int) ([]model.OrderOutput, error) {
+func (m *MockIRepository) GetOrders(arg0 context.Context, arg1 int, arg2 model.PageQuery) (model.OrdersPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrders", arg0, arg1)
-	ret0, _ := ret[0].([]model.OrderOutput)
+	ret := m.ctrl.Call(m, "GetOrders", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.OrdersPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetOrders indicates an expected call of GetOrders.
-func (mr *MockIRepositoryMockRecorder) GetOrders(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockIRepositoryMockRecorder) GetOrders(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockIRepository)(nil).GetOrders), arg0, arg1, arg2)
+}
+
+// GetPendingOrderStats mocks base method.
+func (m *MockIRepository) GetPendingOrderStats(arg0 context.Context) ([]model.PendingOrderStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPendingOrderStats", arg0)
+	ret0, _ := ret[0].([]model.PendingOrderStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPendingOrderStats indicates an expected call of GetPendingOrderStats.
+func (mr *MockIRepositoryMockRecorder) GetPendingOrderStats(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockIRepository)(nil).GetOrders), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingOrderStats", reflect.TypeOf((*MockIRepository)(nil).GetPendingOrderStats), arg0)
+}
+
+// GetReferrals mocks base method.
+func (m *MockIRepository) GetReferrals(arg0 context.Context, arg1 int, arg2 model.PageQuery) (model.ReferralsPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReferrals", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.ReferralsPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetReferrals indicates an expected call of GetReferrals.
+func (mr *MockIRepositoryMockRecorder) GetReferrals(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferrals", reflect.TypeOf((*MockIRepository)(nil).GetReferrals), arg0, arg1, arg2)
+}
+
+// GetSessionByRefreshToken mocks base method.
+func (m *MockIRepository) GetSessionByRefreshToken(arg0 context.Context, arg1 string) (model.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSessionByRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(model.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSessionByRefreshToken indicates an expected call of GetSessionByRefreshToken.
+func (mr *MockIRepositoryMockRecorder) GetSessionByRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSessionByRefreshToken", reflect.TypeOf((*MockIRepository)(nil).GetSessionByRefreshToken), arg0, arg1)
+}
+
+// GetTransferHistory mocks base method.
+func (m *MockIRepository) GetTransferHistory(arg0 context.Context, arg1 int, arg2 model.PageQuery) (model.TransfersPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTransferHistory", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.TransfersPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTransferHistory indicates an expected call of GetTransferHistory.
+func (mr *MockIRepositoryMockRecorder) GetTransferHistory(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransferHistory", reflect.TypeOf((*MockIRepository)(nil).GetTransferHistory), arg0, arg1, arg2)
+}
+
+// GetUserByLogin mocks base method.
+func (m *MockIRepository) GetUserByLogin(arg0 context.Context, arg1 string) (model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByLogin", arg0, arg1)
+	ret0, _ := ret[0].(model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByLogin indicates an expected call of GetUserByLogin.
+func (mr *MockIRepositoryMockRecorder) GetUserByLogin(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByLogin", reflect.TypeOf((*MockIRepository)(nil).GetUserByLogin), arg0, arg1)
+}
+
+// GetUserTier mocks base method.
+func (m *MockIRepository) GetUserTier(arg0 context.Context, arg1 int, arg2 time.Time) (model.TierState, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserTier", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.TierState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserTier indicates an expected call of GetUserTier.
+func (mr *MockIRepositoryMockRecorder) GetUserTier(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserTier", reflect.TypeOf((*MockIRepository)(nil).GetUserTier), arg0, arg1, arg2)
+}
+
+// GetUsersForTierReview mocks base method.
+func (m *MockIRepository) GetUsersForTierReview(arg0 context.Context, arg1 time.Time, arg2, arg3 int) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUsersForTierReview", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsersForTierReview indicates an expected call of GetUsersForTierReview.
+func (mr *MockIRepositoryMockRecorder) GetUsersForTierReview(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersForTierReview", reflect.TypeOf((*MockIRepository)(nil).GetUsersForTierReview), arg0, arg1, arg2, arg3)
+}
+
+// GetUsersWithExpiredLots mocks base method.
+func (m *MockIRepository) GetUsersWithExpiredLots(arg0 context.Context, arg1 time.Time, arg2 int) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUsersWithExpiredLots", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsersWithExpiredLots indicates an expected call of GetUsersWithExpiredLots.
+func (mr *MockIRepositoryMockRecorder) GetUsersWithExpiredLots(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersWithExpiredLots", reflect.TypeOf((*MockIRepository)(nil).GetUsersWithExpiredLots), arg0, arg1, arg2)
 }
 
 // GetWithdrawHistory mocks base method.
-func (m *MockIRepository) GetWithdrawHistory(arg0 context.Context, arg1 int) ([]model.WithdrawOutput, error) {
+func (m *MockIRepository) GetWithdrawHistory(arg0 context.Context, arg1 int, arg2 model.PageQuery) (model.WithdrawalsPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetWithdrawHistory", arg0, arg1)
-	ret0, _ := ret[0].([]model.WithdrawOutput)
+	ret := m.ctrl.Call(m, "GetWithdrawHistory", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.WithdrawalsPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetWithdrawHistory indicates an expected call of GetWithdrawHistory.
-func (mr *MockIRepositoryMockRecorder) GetWithdrawHistory(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockIRepositoryMockRecorder) GetWithdrawHistory(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawHistory", reflect.TypeOf((*MockIRepository)(nil).GetWithdrawHistory), arg0, arg1, arg2)
+}
+
+// IsSessionActive mocks base method.
+func (m *MockIRepository) IsSessionActive(arg0 context.Context, arg1 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsSessionActive", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsSessionActive indicates an expected call of IsSessionActive.
+func (mr *MockIRepositoryMockRecorder) IsSessionActive(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawHistory", reflect.TypeOf((*MockIRepository)(nil).GetWithdrawHistory), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsSessionActive", reflect.TypeOf((*MockIRepository)(nil).IsSessionActive), arg0, arg1)
 }
 
 // IsUserExist mocks base method.
@@ -126,6 +335,21 @@ func (mr *MockIRepositoryMockRecorder) IsUserExist(arg0, arg1 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsUserExist", reflect.TypeOf((*MockIRepository)(nil).IsUserExist), arg0, arg1)
 }
 
+// LeaseAccrualJobs mocks base method.
+func (m *MockIRepository) LeaseAccrualJobs(arg0 context.Context, arg1 int, arg2 time.Duration) ([]model.AccrualJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LeaseAccrualJobs", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]model.AccrualJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LeaseAccrualJobs indicates an expected call of LeaseAccrualJobs.
+func (mr *MockIRepositoryMockRecorder) LeaseAccrualJobs(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LeaseAccrualJobs", reflect.TypeOf((*MockIRepository)(nil).LeaseAccrualJobs), arg0, arg1, arg2)
+}
+
 // MakeAccrual mocks base method.
 func (m *MockIRepository) MakeAccrual(arg0 context.Context, arg1 int, arg2, arg3 string, arg4, arg5 decimal.Decimal) error {
 	m.ctrl.T.Helper()
@@ -140,19 +364,104 @@ func (mr *MockIRepositoryMockRecorder) MakeAccrual(arg0, arg1, arg2, arg3, arg4,
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeAccrual", reflect.TypeOf((*MockIRepository)(nil).MakeAccrual), arg0, arg1, arg2, arg3, arg4, arg5)
 }
 
+// Ping mocks base method.
+func (m *MockIRepository) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockIRepositoryMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockIRepository)(nil).Ping), arg0)
+}
+
 // Register mocks base method.
-func (m *MockIRepository) Register(arg0 context.Context, arg1, arg2 string) (int, error) {
+func (m *MockIRepository) Register(arg0 context.Context, arg1, arg2, arg3 string, arg4 *model.ReferralTerms) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Register", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "Register", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Register indicates an expected call of Register.
-func (mr *MockIRepositoryMockRecorder) Register(arg0, arg1, arg2 interface{}) *gomock.Call {
+func (mr *MockIRepositoryMockRecorder) Register(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockIRepository)(nil).Register), arg0, arg1, arg2, arg3, arg4)
+}
+
+// RescheduleAccrualJob mocks base method.
+func (m *MockIRepository) RescheduleAccrualJob(arg0 context.Context, arg1 int, arg2 time.Duration, arg3 int, arg4 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RescheduleAccrualJob", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RescheduleAccrualJob indicates an expected call of RescheduleAccrualJob.
+func (mr *MockIRepositoryMockRecorder) RescheduleAccrualJob(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RescheduleAccrualJob", reflect.TypeOf((*MockIRepository)(nil).RescheduleAccrualJob), arg0, arg1, arg2, arg3, arg4)
+}
+
+// ReverseWithdrawal mocks base method.
+func (m *MockIRepository) ReverseWithdrawal(arg0 context.Context, arg1 model.ReversalInput) (model.ReversalOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReverseWithdrawal", arg0, arg1)
+	ret0, _ := ret[0].(model.ReversalOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReverseWithdrawal indicates an expected call of ReverseWithdrawal.
+func (mr *MockIRepositoryMockRecorder) ReverseWithdrawal(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockIRepository)(nil).Register), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReverseWithdrawal", reflect.TypeOf((*MockIRepository)(nil).ReverseWithdrawal), arg0, arg1)
+}
+
+// RevokeSession mocks base method.
+func (m *MockIRepository) RevokeSession(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSession", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSession indicates an expected call of RevokeSession.
+func (mr *MockIRepositoryMockRecorder) RevokeSession(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSession", reflect.TypeOf((*MockIRepository)(nil).RevokeSession), arg0, arg1)
+}
+
+// RevokeUserSessions mocks base method.
+func (m *MockIRepository) RevokeUserSessions(arg0 context.Context, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserSessions", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUserSessions indicates an expected call of RevokeUserSessions.
+func (mr *MockIRepositoryMockRecorder) RevokeUserSessions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserSessions", reflect.TypeOf((*MockIRepository)(nil).RevokeUserSessions), arg0, arg1)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockIRepository) RotateRefreshToken(arg0 context.Context, arg1, arg2, arg3 string, arg4 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockIRepositoryMockRecorder) RotateRefreshToken(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockIRepository)(nil).RotateRefreshToken), arg0, arg1, arg2, arg3, arg4)
 }
 
 // SendOrder mocks base method.
@@ -169,6 +478,34 @@ func (mr *MockIRepositoryMockRecorder) SendOrder(arg0, arg1, arg2 interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendOrder", reflect.TypeOf((*MockIRepository)(nil).SendOrder), arg0, arg1, arg2)
 }
 
+// SetUserTier mocks base method.
+func (m *MockIRepository) SetUserTier(arg0 context.Context, arg1 int, arg2 model.TierChange) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUserTier", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUserTier indicates an expected call of SetUserTier.
+func (mr *MockIRepositoryMockRecorder) SetUserTier(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserTier", reflect.TypeOf((*MockIRepository)(nil).SetUserTier), arg0, arg1, arg2)
+}
+
+// Transfer mocks base method.
+func (m *MockIRepository) Transfer(arg0 context.Context, arg1 model.TransferInput, arg2 int, arg3 model.TransferLimit) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Transfer", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Transfer indicates an expected call of Transfer.
+func (mr *MockIRepositoryMockRecorder) Transfer(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transfer", reflect.TypeOf((*MockIRepository)(nil).Transfer), arg0, arg1, arg2, arg3)
+}
+
 // UpdateOrderStatus mocks base method.
 func (m *MockIRepository) UpdateOrderStatus(arg0 context.Context, arg1, arg2 string) error {
 	m.ctrl.T.Helper()
@@ -183,16 +520,30 @@ func (mr *MockIRepositoryMockRecorder) UpdateOrderStatus(arg0, arg1, arg2 interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrderStatus", reflect.TypeOf((*MockIRepository)(nil).UpdateOrderStatus), arg0, arg1, arg2)
 }
 
+// UpdatePassword mocks base method.
+func (m *MockIRepository) UpdatePassword(arg0 context.Context, arg1 int, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePassword", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePassword indicates an expected call of UpdatePassword.
+func (mr *MockIRepositoryMockRecorder) UpdatePassword(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePassword", reflect.TypeOf((*MockIRepository)(nil).UpdatePassword), arg0, arg1, arg2)
+}
+
 // Withdraw mocks base method.
-func (m *MockIRepository) Withdraw(arg0 context.Context, arg1 model.WithdrawInput, arg2 model.BalanceWithdrawn, arg3 int) error {
+func (m *MockIRepository) Withdraw(arg0 context.Context, arg1 model.WithdrawInput, arg2 int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Withdraw", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "Withdraw", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Withdraw indicates an expected call of Withdraw.
-func (mr *MockIRepositoryMockRecorder) Withdraw(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockIRepositoryMockRecorder) Withdraw(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Withdraw", reflect.TypeOf((*MockIRepository)(nil).Withdraw), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Withdraw", reflect.TypeOf((*MockIRepository)(nil).Withdraw), arg0, arg1, arg2)
 }
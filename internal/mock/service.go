@@ -51,55 +51,115 @@ func (mr *MockIServiceMockRecorder) GetBalanceByUserID(arg0, arg1 interface{}) *
 }
 
 // GetJWTToken mocks base method.
-func (m *MockIService) GetJWTToken(arg0 string) (string, error) {
+func (m *MockIService) GetJWTToken(arg0, arg1 string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetJWTToken", arg0)
+	ret := m.ctrl.Call(m, "GetJWTToken", arg0, arg1)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetJWTToken indicates an expected call of GetJWTToken.
-func (mr *MockIServiceMockRecorder) GetJWTToken(arg0 interface{}) *gomock.Call {
+func (mr *MockIServiceMockRecorder) GetJWTToken(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJWTToken", reflect.TypeOf((*MockIService)(nil).GetJWTToken), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJWTToken", reflect.TypeOf((*MockIService)(nil).GetJWTToken), arg0, arg1)
 }
 
 // GetOrders mocks base method.
-func (m *MockIService) GetOrders(arg0 context.Context, arg1 int) ([]model.OrderOutput, error) {
+func (m *MockIService) GetOrders(arg0 context.Context, arg1 int, arg2 model.PageQuery) (model.OrdersPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrders", arg0, arg1)
-	ret0, _ := ret[0].([]model.OrderOutput)
+	ret := m.ctrl.Call(m, "GetOrders", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.OrdersPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetOrders indicates an expected call of GetOrders.
-func (mr *MockIServiceMockRecorder) GetOrders(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockIServiceMockRecorder) GetOrders(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockIService)(nil).GetOrders), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockIService)(nil).GetOrders), arg0, arg1, arg2)
+}
+
+// GetReferrals mocks base method.
+func (m *MockIService) GetReferrals(arg0 context.Context, arg1 int, arg2 model.PageQuery) (model.ReferralsPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReferrals", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.ReferralsPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetReferrals indicates an expected call of GetReferrals.
+func (mr *MockIServiceMockRecorder) GetReferrals(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferrals", reflect.TypeOf((*MockIService)(nil).GetReferrals), arg0, arg1, arg2)
+}
+
+// GetTier mocks base method.
+func (m *MockIService) GetTier(arg0 context.Context, arg1 int) (model.TierOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTier", arg0, arg1)
+	ret0, _ := ret[0].(model.TierOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTier indicates an expected call of GetTier.
+func (mr *MockIServiceMockRecorder) GetTier(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTier", reflect.TypeOf((*MockIService)(nil).GetTier), arg0, arg1)
+}
+
+// GetTransferHistory mocks base method.
+func (m *MockIService) GetTransferHistory(arg0 context.Context, arg1 int, arg2 model.PageQuery) (model.TransfersPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTransferHistory", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.TransfersPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTransferHistory indicates an expected call of GetTransferHistory.
+func (mr *MockIServiceMockRecorder) GetTransferHistory(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransferHistory", reflect.TypeOf((*MockIService)(nil).GetTransferHistory), arg0, arg1, arg2)
 }
 
 // GetWithdrawHistory mocks base method.
-func (m *MockIService) GetWithdrawHistory(arg0 context.Context, arg1 int) ([]model.WithdrawOutput, error) {
+func (m *MockIService) GetWithdrawHistory(arg0 context.Context, arg1 int, arg2 model.PageQuery) (model.WithdrawalsPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetWithdrawHistory", arg0, arg1)
-	ret0, _ := ret[0].([]model.WithdrawOutput)
+	ret := m.ctrl.Call(m, "GetWithdrawHistory", arg0, arg1, arg2)
+	ret0, _ := ret[0].(model.WithdrawalsPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetWithdrawHistory indicates an expected call of GetWithdrawHistory.
-func (mr *MockIServiceMockRecorder) GetWithdrawHistory(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockIServiceMockRecorder) GetWithdrawHistory(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawHistory", reflect.TypeOf((*MockIService)(nil).GetWithdrawHistory), arg0, arg1, arg2)
+}
+
+// IsSessionActive mocks base method.
+func (m *MockIService) IsSessionActive(arg0 context.Context, arg1 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsSessionActive", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsSessionActive indicates an expected call of IsSessionActive.
+func (mr *MockIServiceMockRecorder) IsSessionActive(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawHistory", reflect.TypeOf((*MockIService)(nil).GetWithdrawHistory), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsSessionActive", reflect.TypeOf((*MockIService)(nil).IsSessionActive), arg0, arg1)
 }
 
 // Login mocks base method.
-func (m *MockIService) Login(arg0 context.Context, arg1, arg2 string) (string, error) {
+func (m *MockIService) Login(arg0 context.Context, arg1, arg2 string) (model.Tokens, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Login", arg0, arg1, arg2)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(model.Tokens)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -110,19 +170,91 @@ func (mr *MockIServiceMockRecorder) Login(arg0, arg1, arg2 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockIService)(nil).Login), arg0, arg1, arg2)
 }
 
+// Logout mocks base method.
+func (m *MockIService) Logout(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Logout", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Logout indicates an expected call of Logout.
+func (mr *MockIServiceMockRecorder) Logout(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Logout", reflect.TypeOf((*MockIService)(nil).Logout), arg0, arg1)
+}
+
+// LogoutAll mocks base method.
+func (m *MockIService) LogoutAll(arg0 context.Context, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LogoutAll", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogoutAll indicates an expected call of LogoutAll.
+func (mr *MockIServiceMockRecorder) LogoutAll(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogoutAll", reflect.TypeOf((*MockIService)(nil).LogoutAll), arg0, arg1)
+}
+
+// LogoutByRefreshToken mocks base method.
+func (m *MockIService) LogoutByRefreshToken(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LogoutByRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogoutByRefreshToken indicates an expected call of LogoutByRefreshToken.
+func (mr *MockIServiceMockRecorder) LogoutByRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogoutByRefreshToken", reflect.TypeOf((*MockIService)(nil).LogoutByRefreshToken), arg0, arg1)
+}
+
+// RefreshTokens mocks base method.
+func (m *MockIService) RefreshTokens(arg0 context.Context, arg1 string) (model.Tokens, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshTokens", arg0, arg1)
+	ret0, _ := ret[0].(model.Tokens)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshTokens indicates an expected call of RefreshTokens.
+func (mr *MockIServiceMockRecorder) RefreshTokens(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshTokens", reflect.TypeOf((*MockIService)(nil).RefreshTokens), arg0, arg1)
+}
+
 // Register mocks base method.
-func (m *MockIService) Register(arg0 context.Context, arg1, arg2 string) (string, error) {
+func (m *MockIService) Register(arg0 context.Context, arg1, arg2, arg3 string) (model.Tokens, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Register", arg0, arg1, arg2)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Register", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(model.Tokens)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Register indicates an expected call of Register.
-func (mr *MockIServiceMockRecorder) Register(arg0, arg1, arg2 interface{}) *gomock.Call {
+func (mr *MockIServiceMockRecorder) Register(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockIService)(nil).Register), arg0, arg1, arg2, arg3)
+}
+
+// ReverseWithdrawal mocks base method.
+func (m *MockIService) ReverseWithdrawal(arg0 context.Context, arg1 model.ReversalInput) (model.ReversalOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReverseWithdrawal", arg0, arg1)
+	ret0, _ := ret[0].(model.ReversalOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReverseWithdrawal indicates an expected call of ReverseWithdrawal.
+func (mr *MockIServiceMockRecorder) ReverseWithdrawal(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockIService)(nil).Register), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReverseWithdrawal", reflect.TypeOf((*MockIService)(nil).ReverseWithdrawal), arg0, arg1)
 }
 
 // SendOrder mocks base method.
@@ -139,6 +271,20 @@ func (mr *MockIServiceMockRecorder) SendOrder(arg0, arg1, arg2 interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendOrder", reflect.TypeOf((*MockIService)(nil).SendOrder), arg0, arg1, arg2)
 }
 
+// Transfer mocks base method.
+func (m *MockIService) Transfer(arg0 context.Context, arg1 model.TransferInput, arg2 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Transfer", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Transfer indicates an expected call of Transfer.
+func (mr *MockIServiceMockRecorder) Transfer(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transfer", reflect.TypeOf((*MockIService)(nil).Transfer), arg0, arg1, arg2)
+}
+
 // Withdraw mocks base method.
 func (m *MockIService) Withdraw(arg0 context.Context, arg1 model.WithdrawInput, arg2 int) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+type AccrualJob struct {
+	ID          int
+	OrderNumber string
+	UserID      int
+	Attempts    int
+	// Failures is the number of failed attempts in a row, the backoff grows with it
+	Failures  int
+	CreatedAt time.Time
+	// TraceContext is the W3C traceparent of the order upload, empty for jobs created before tracing
+	TraceContext string
+	// RequestID is the id of the upload request, accrual logs of the order carry it
+	RequestID string
+}
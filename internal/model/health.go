@@ -0,0 +1,18 @@
+package model
+
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+type Health struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+type HealthCheck struct {
+	Status string `json:"status"`
+	// Detail is a short human readable result of the check, e.g. the applied migration version
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,26 @@
+package model
+
+// Kinds of ledger transactions.
+const (
+	LedgerKindAccrual    = "ACCRUAL"
+	LedgerKindWithdrawal = "WITHDRAWAL"
+	LedgerKindAdjustment = "ADJUSTMENT"
+	LedgerKindExpiry     = "EXPIRY"
+	LedgerKindReferral   = "REFERRAL"
+	LedgerKindTransfer   = "TRANSFER"
+	LedgerKindReversal   = "REVERSAL"
+	LedgerKindTierBonus  = "TIER_BONUS"
+)
+
+// Ledger accounts. Points of users are kept on LedgerAccountUser,
+// the others are system accounts where points come from and go to.
+const (
+	LedgerAccountUser        = "USER"
+	LedgerAccountAccruals    = "ACCRUALS"
+	LedgerAccountWithdrawals = "WITHDRAWALS"
+	LedgerAccountAdjustments = "ADJUSTMENTS"
+	LedgerAccountExpirations = "EXPIRATIONS"
+	LedgerAccountReferrals   = "REFERRALS"
+	LedgerAccountTransfers   = "TRANSFERS"
+	LedgerAccountTierBonuses = "TIER_BONUSES"
+)
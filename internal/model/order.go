@@ -24,8 +24,17 @@ type Order struct {
 }
 
 type OrderOutput struct {
-	Number     string          `json:"number"`
-	Status     string          `json:"status"`
-	Accrual    decimal.Decimal `json:"accrual"`
-	UploadedAt time.Time       `json:"uploadedAt"`
+	ID     int    `json:"-"`
+	Number string `json:"number"`
+	Status string `json:"status"`
+	// Accrual is present only for PROCESSED orders
+	Accrual    *decimal.Decimal `json:"accrual,omitempty"`
+	UploadedAt RFC3339Time      `json:"uploaded_at"`
+}
+
+// PendingOrderStats describes orders of one status which wait for the final accrual status.
+type PendingOrderStats struct {
+	Status           string
+	Count            int
+	OldestUploadedAt time.Time
 }
@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// PageQuery selects a page of history sorted from the oldest item to the newest.
+type PageQuery struct {
+	Limit int
+	// After is the position of the last item of the previous page, nil for the first page
+	After *Cursor
+	// Statuses filters orders by status, empty means any status
+	Statuses []string
+	// From and To bound the time range as [From, To), zero values mean unbounded
+	From time.Time
+	To   time.Time
+}
+
+// Cursor is the position of an item in history: its time and id, the id breaks ties of equal times.
+type Cursor struct {
+	Time time.Time
+	ID   int
+}
+
+type OrdersPage struct {
+	Orders []OrderOutput
+	// Next is nil on the last page
+	Next *Cursor
+}
+
+type WithdrawalsPage struct {
+	Withdrawals []WithdrawOutput
+	// Next is nil on the last page
+	Next *Cursor
+}
+
+type ReferralsPage struct {
+	Code      string
+	Referrals []ReferralOutput
+	// Next is nil on the last page
+	Next *Cursor
+}
+
+type TransfersPage struct {
+	Transfers []TransferOutput
+	// Next is nil on the last page
+	Next *Cursor
+}
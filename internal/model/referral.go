@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Statuses of referrals.
+const (
+	ReferralStatusPending  = "PENDING"
+	ReferralStatusRewarded = "REWARDED"
+	ReferralStatusCapped   = "CAPPED"
+)
+
+// ReferralTerms are the bonuses of a referral which are fixed at the registration of the referee.
+type ReferralTerms struct {
+	// Code is the referral code of the referrer
+	Code  string
+	Bonus decimal.Decimal
+	// Cap is the number of bonuses the referrer gets for referrals registered since CapSince, 0 means no cap
+	Cap      int
+	CapSince time.Time
+}
+
+type ReferralOutput struct {
+	ID           int             `json:"-"`
+	Login        string          `json:"login"`
+	RegisteredAt RFC3339Time     `json:"registered_at"`
+	Status       string          `json:"status"`
+	Bonus        decimal.Decimal `json:"bonus"`
+}
+
+type ReferralsOutput struct {
+	Code      string           `json:"code"`
+	Referrals []ReferralOutput `json:"referrals"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+type ReversalInput struct {
+	OrderNumber string `json:"-"`
+	// Sum is nil for the reversal of the whole part of the withdrawal which isn't reversed yet
+	Sum    *decimal.Decimal `json:"sum,omitempty"`
+	Reason string           `json:"reason"`
+}
+
+type ReversalOutput struct {
+	ID          int    `json:"id"`
+	OrderNumber string `json:"order"`
+	UserID      int    `json:"-"`
+	// Sum is returned to the user by this reversal
+	Sum decimal.Decimal `json:"sum"`
+	// Withdrawn is the sum of the original withdrawal and Reversed is its part reversed so far
+	Withdrawn decimal.Decimal `json:"withdrawn"`
+	Reversed  decimal.Decimal `json:"reversed"`
+	Reason    string          `json:"reason,omitempty"`
+	CreatedAt RFC3339Time     `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+type Session struct {
+	ID        string
+	UserID    int
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
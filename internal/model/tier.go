@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	TierBronze = "BRONZE"
+	TierSilver = "SILVER"
+	TierGold   = "GOLD"
+)
+
+// TierState is the stored tier of the user and the points accrued in the rolling window.
+type TierState struct {
+	Tier string
+	// ReviewAt is when the tier has to be evaluated again, nil if there are no accruals in the window
+	ReviewAt *time.Time
+	Points   decimal.Decimal
+	// OldestAccrualAt is the time of the oldest accrual in the window
+	OldestAccrualAt *time.Time
+}
+
+// TierChange is a new evaluation of the tier, an event is recorded if the tier differs.
+type TierChange struct {
+	From     string
+	To       string
+	Points   decimal.Decimal
+	ReviewAt *time.Time
+}
+
+type TierOutput struct {
+	Tier       string          `json:"tier"`
+	Multiplier decimal.Decimal `json:"multiplier"`
+	// Points are accrued in the rolling window
+	Points decimal.Decimal `json:"points"`
+	// next tier fields are omitted for the top tier
+	NextTier         string           `json:"next_tier,omitempty"`
+	NextTierPoints   *decimal.Decimal `json:"next_tier_points,omitempty"`
+	PointsToNextTier *decimal.Decimal `json:"points_to_next_tier,omitempty"`
+	ReevaluatedAt    *RFC3339Time     `json:"reevaluated_at,omitempty"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// RFC3339Time is marshalled to JSON in RFC3339 format without fractional seconds, as the specification requires.
+type RFC3339Time struct {
+	time.Time
+}
+
+func (t RFC3339Time) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(t.Format(time.RFC3339))), nil
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Directions of transfers in the history of a user.
+const (
+	TransferDirectionIn  = "IN"
+	TransferDirectionOut = "OUT"
+)
+
+type TransferInput struct {
+	// Login is the recipient of the points
+	Login string          `json:"login"`
+	Sum   decimal.Decimal `json:"sum"`
+}
+
+// TransferLimit bounds the points the user sends since Since, zero Amount means no limit.
+type TransferLimit struct {
+	Amount decimal.Decimal
+	Since  time.Time
+}
+
+type TransferOutput struct {
+	ID        int    `json:"-"`
+	Direction string `json:"direction"`
+	// Login is the other side of the transfer
+	Login       string          `json:"login"`
+	Sum         decimal.Decimal `json:"sum"`
+	ProcessedAt RFC3339Time     `json:"processed_at"`
+}
@@ -12,9 +12,13 @@ type User struct {
 type LoginInput struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	// ReferralCode is the code of the user who invited the new one, it is read only on registration
+	ReferralCode string `json:"referral_code,omitempty"`
 }
 
 type BalanceWithdrawn struct {
 	Balance   decimal.Decimal `json:"current"`
 	Withdrawn decimal.Decimal `json:"withdrawn"`
+	// ExpiringSoon is the part of the balance which expires in the next 30 days
+	ExpiringSoon decimal.Decimal `json:"expiring_soon"`
 }
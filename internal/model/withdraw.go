@@ -20,7 +20,8 @@ type WithdrawInput struct {
 }
 
 type WithdrawOutput struct {
+	ID          int             `json:"-"`
 	OrderNumber string          `json:"order"`
 	Sum         decimal.Decimal `json:"sum"`
-	ProcessedAt time.Time       `json:"processedAt"`
+	ProcessedAt RFC3339Time     `json:"processed_at"`
 }
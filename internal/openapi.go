@@ -0,0 +1,17 @@
+package internal
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OpenAPISpec describes all routes of RegisterRoutes, tests validate requests and responses of handlers against it.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+func (h *Handlers) OpenAPI(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(OpenAPISpec)
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+const headerNextCursor = "X-Next-Cursor"
+
+var orderStatuses = map[string]bool{
+	model.OrderStatusNew:        true,
+	model.OrderStatusRegistered: true,
+	model.OrderStatusProcessing: true,
+	model.OrderStatusInvalid:    true,
+	model.OrderStatusProcessed:  true,
+}
+
+// parsePageQuery reads limit, cursor, from and to query parameters, status is read only if withStatus is set.
+func parsePageQuery(c *fiber.Ctx, withStatus bool) (model.PageQuery, error) {
+	var q model.PageQuery
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxPageLimit {
+			return q, fmt.Errorf("%w: limit must be from 1 to %d", ErrInvalidQuery, MaxPageLimit)
+		}
+		q.Limit = n
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return q, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+		}
+		q.After = &after
+	}
+
+	if status := c.Query("status"); withStatus && status != "" {
+		for _, s := range strings.Split(status, ",") {
+			s = strings.ToUpper(strings.TrimSpace(s))
+			if !orderStatuses[s] {
+				return q, fmt.Errorf("%w: unknown status %s", ErrInvalidQuery, s)
+			}
+			q.Statuses = append(q.Statuses, s)
+		}
+	}
+
+	var err error
+	if q.From, err = parseQueryTime(c, "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseQueryTime(c, "to"); err != nil {
+		return q, err
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("%w: from must be before to", ErrInvalidQuery)
+	}
+
+	return q, nil
+}
+
+func parseQueryTime(c *fiber.Ctx, key string) (time.Time, error) {
+	v := c.Query(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be RFC3339 time", ErrInvalidQuery, key)
+	}
+	return t, nil
+}
+
+// setNextPage points the client to the next page with X-Next-Cursor and Link headers,
+// the link keeps the filters of the current request.
+func setNextPage(c *fiber.Ctx, next *model.Cursor) {
+	if next == nil {
+		return
+	}
+
+	cursor := encodeCursor(*next)
+
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	c.Context().QueryArgs().CopyTo(args)
+	args.Set("cursor", cursor)
+
+	c.Set(headerNextCursor, cursor)
+	c.Append(fiber.HeaderLink, "<"+c.Path()+"?"+args.String()+`>; rel="next"`)
+}
+
+// encodeCursor hides the position behind an opaque token, clients must not build cursors themselves.
+func encodeCursor(cursor model.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", cursor.Time.UnixNano(), cursor.ID)))
+}
+
+func decodeCursor(s string) (model.Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return model.Cursor{}, err
+	}
+
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return model.Cursor{}, fmt.Errorf("cursor %s has no id", b)
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return model.Cursor{}, err
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return model.Cursor{}, err
+	}
+
+	return model.Cursor{Time: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
@@ -3,12 +3,17 @@ package internal
 import (
 	"context"
 	"database/sql"
-	"embed"
 	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/pressly/goose/v3"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
@@ -18,26 +23,65 @@ import (
 
 //go:generate mockgen -source repository.go -destination ./mock/repository.go
 
+// uniqueViolation is the SQLSTATE of a violated unique constraint
+const uniqueViolation = "23505"
+
+// timestampLayout is the text of TIMESTAMP columns, they keep the wall clock of the server without an offset
+const timestampLayout = "2006-01-02 15:04:05.999999999"
+
+// parseTimestamp reads the text of a TIMESTAMP column in the local time of the server which wrote it.
+func parseTimestamp(s string) (time.Time, error) {
+	return time.ParseInLocation(timestampLayout, s, time.Local)
+}
+
 type IRepository interface {
-	Register(context.Context, string, string) (int, error)
+	Register(context.Context, string, string, string, *model.ReferralTerms) (int, error)
 	IsUserExist(context.Context, string) (bool, error)
-	CheckCredentials(context.Context, string, string) (int, error)
+	GetUserByLogin(context.Context, string) (model.User, error)
+	UpdatePassword(context.Context, int, string) error
 	GetOrderByNumber(context.Context, string) (model.Order, error)
 	SendOrder(context.Context, string, int) error
-	GetOrders(context.Context, int) ([]model.OrderOutput, error)
+	GetOrders(context.Context, int, model.PageQuery) (model.OrdersPage, error)
 	GetBalanceByUserID(context.Context, int) (model.BalanceWithdrawn, error)
-	Withdraw(context.Context, model.WithdrawInput, model.BalanceWithdrawn, int) error
-	GetWithdrawHistory(context.Context, int) ([]model.WithdrawOutput, error)
+	Withdraw(context.Context, model.WithdrawInput, int) error
+	GetWithdrawHistory(context.Context, int, model.PageQuery) (model.WithdrawalsPage, error)
 	UpdateOrderStatus(context.Context, string, string) error
 	MakeAccrual(context.Context, int, string, string, decimal.Decimal, decimal.Decimal) error
+	LeaseAccrualJobs(context.Context, int, time.Duration) ([]model.AccrualJob, error)
+	RescheduleAccrualJob(context.Context, int, time.Duration, int, string) error
+	CompleteAccrualJob(context.Context, int) error
+	CreateSession(context.Context, model.Session, string) error
+	GetSessionByRefreshToken(context.Context, string) (model.Session, error)
+	RotateRefreshToken(context.Context, string, string, string, time.Time) error
+	IsSessionActive(context.Context, string) (bool, error)
+	RevokeSession(context.Context, string) error
+	RevokeUserSessions(context.Context, int) error
+	Ping(context.Context) error
+	GetMigrationVersion(context.Context) (int64, error)
+	CountAccrualJobs(context.Context) (int, error)
+	GetPendingOrderStats(context.Context) ([]model.PendingOrderStats, error)
+	GetExpiringPoints(context.Context, int, time.Time) (decimal.Decimal, error)
+	GetUsersWithExpiredLots(context.Context, time.Time, int) ([]int, error)
+	ExpireLots(context.Context, int, time.Time) (decimal.Decimal, error)
+	GetUserTier(context.Context, int, time.Time) (model.TierState, error)
+	SetUserTier(context.Context, int, model.TierChange) error
+	GetUsersForTierReview(context.Context, time.Time, int, int) ([]int, error)
+	GetReferrals(context.Context, int, model.PageQuery) (model.ReferralsPage, error)
+	Transfer(context.Context, model.TransferInput, int, model.TransferLimit) error
+	GetTransferHistory(context.Context, int, model.PageQuery) (model.TransfersPage, error)
+	ReverseWithdrawal(context.Context, model.ReversalInput) (model.ReversalOutput, error)
 }
 
+// Repository keeps every movement of points in ledger_entries. users.balance and users.withdrawn
+// are materialized from the ledger and change only in the same transaction with a ledger posting.
 type Repository struct {
 	Conn   *sql.DB
 	Logger *zap.SugaredLogger
+	// MigrationVersion is the version of the latest migration embedded into the binary
+	MigrationVersion int64
 }
 
-func NewRepository(connString string, embedMigrations embed.FS, logger *zap.SugaredLogger) (*Repository, error) {
+func NewRepository(connString string, embedMigrations fs.FS, logger *zap.SugaredLogger) (*Repository, error) {
 	db, err := sql.Open("pgx", connString)
 	if err != nil {
 		return nil, err
@@ -50,14 +94,93 @@ func NewRepository(connString string, embedMigrations embed.FS, logger *zap.Suga
 		return nil, err
 	}
 
-	return &Repository{Conn: db, Logger: logger}, nil
+	migrations, err := goose.CollectMigrations("migrations", 0, goose.MaxVersion)
+	if err != nil {
+		return nil, err
+	}
+	last, err := migrations.Last()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{Conn: db, Logger: logger, MigrationVersion: last.Version}, nil
+}
+
+// Close closes the connection pool, it waits for running queries to finish.
+func (r Repository) Close() error {
+	return r.Conn.Close()
+}
+
+// WithTx runs fn within a transaction bound to ctx. The transaction is committed if fn succeeds
+// and rolled back if fn returns an error or panics.
+func (r Repository) WithTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := r.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			loggerFromContext(ctx, r.Logger).Errorf("Rollback error: %s", rbErr.Error())
+		}
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (r Repository) Register(ctx context.Context, login, password string) (int, error) {
+// Register creates the user with its own referral code. If the user came by the referral of another user,
+// the referral is recorded with the bonuses of terms, the referrer gets no bonus above the cap.
+func (r Repository) Register(ctx context.Context, login, password, referralCode string, terms *model.ReferralTerms) (int, error) {
 	var id int
-	row := r.Conn.QueryRowContext(ctx, "INSERT INTO users (login, password) VALUES ($1,$2) RETURNING id", login, password)
+	err := r.WithTx(ctx, func(tx *sql.Tx) error {
+		var referrerID int
+		if terms != nil {
+			// the lock serializes registrations by the same code, so the cap can't be exceeded concurrently
+			err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE referral_code = $1 FOR UPDATE", terms.Code).Scan(&referrerID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidReferralCode
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tx.QueryRowContext(ctx, "INSERT INTO users (login, password, referral_code) VALUES ($1,$2,$3) RETURNING id", login, password, referralCode).Scan(&id)
+		if err != nil {
+			return err
+		}
 
-	err := row.Scan(&id)
+		if terms == nil {
+			return nil
+		}
+
+		capped := false
+		if terms.Cap > 0 {
+			var n int
+			err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM referrals WHERE referrer_id = $1 AND created_at >= $2 AND NOT capped", referrerID, terms.CapSince).Scan(&n)
+			if err != nil {
+				return err
+			}
+			capped = n >= terms.Cap
+		}
+
+		referrerBonus := terms.Bonus
+		if capped {
+			referrerBonus = decimal.Zero
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO referrals (referrer_id, referee_id, referrer_bonus, referee_bonus, capped, created_at) VALUES ($1, $2, $3, $4, $5, $6)", referrerID, id, referrerBonus, terms.Bonus, capped, time.Now().Format(time.RFC3339))
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -76,19 +199,28 @@ func (r Repository) IsUserExist(ctx context.Context, login string) (bool, error)
 	return exist, nil
 }
 
-func (r Repository) CheckCredentials(ctx context.Context, login string, password string) (int, error) {
-	var id int
-	row := r.Conn.QueryRowContext(ctx, "SELECT id FROM users WHERE login = $1 AND password = $2", login, password)
+func (r Repository) GetUserByLogin(ctx context.Context, login string) (model.User, error) {
+	var u model.User
+	row := r.Conn.QueryRowContext(ctx, "SELECT id, login, password FROM users WHERE login = $1", login)
 
-	err := row.Scan(&id)
+	err := row.Scan(&u.ID, &u.Login, &u.Password)
 	if errors.Is(err, sql.ErrNoRows) {
-		return 0, ErrInvalidCredentials
+		return model.User{}, ErrNoRecords
 	}
 	if err != nil {
-		return 0, err
+		return model.User{}, err
 	}
 
-	return id, nil
+	return u, nil
+}
+
+func (r Repository) UpdatePassword(ctx context.Context, uid int, password string) error {
+	_, err := r.Conn.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", password, uid)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (r Repository) GetOrderByNumber(ctx context.Context, orderNumber string) (model.Order, error) {
@@ -105,36 +237,56 @@ func (r Repository) GetOrderByNumber(ctx context.Context, orderNumber string) (m
 	return o, nil
 }
 
-func (r Repository) SendOrder(ctx context.Context, orderNumber string, userID int) error {
-	_, err := r.Conn.ExecContext(ctx, "INSERT INTO orders (number, user_id, status, uploaded_at) VALUES ($1, $2, $3, $4)", orderNumber, userID, model.OrderStatusNew, time.Now().Format(time.RFC3339))
+// SendOrder saves the order and puts it into the accrual queue with a single statement,
+// so an accepted order can't be lost between the two inserts.
+// The job keeps the trace context of the upload.
+func (r Repository) SendOrder(ctx context.Context, orderNumber string, userID int) (err error) {
+	ctx, span := startSpan(ctx, "Repository.SendOrder", orderAttributes(orderNumber, userID)...)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.Conn.ExecContext(ctx, "WITH o AS (INSERT INTO orders (number, user_id, status, uploaded_at) VALUES ($1, $2, $3, $4) RETURNING number, user_id) INSERT INTO accrual_jobs (order_number, user_id, trace_context, request_id) SELECT number, user_id, NULLIF($5, ''), NULLIF($6, '') FROM o", orderNumber, userID, model.OrderStatusNew, time.Now().Format(time.RFC3339), injectTraceContext(ctx), requestIDFromContext(ctx))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r Repository) GetOrders(ctx context.Context, uid int) ([]model.OrderOutput, error) {
-	rows, err := r.Conn.QueryContext(ctx, "SELECT number, accrual, status, uploaded_at FROM orders WHERE user_id = $1 ORDER BY uploaded_at DESC", uid)
-	if err != nil {
-		return nil, err
-	}
+// GetOrders reads a page of the user's orders, one row more than the limit tells whether the next page exists.
+func (r Repository) GetOrders(ctx context.Context, uid int, q model.PageQuery) (model.OrdersPage, error) {
+	query, args := pageQuery("SELECT id, number, accrual, status, uploaded_at FROM orders WHERE user_id = $1", []interface{}{uid}, "uploaded_at", q)
 
-	if rows.Err() != nil {
-		return nil, err
+	rows, err := r.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return model.OrdersPage{}, err
 	}
+	defer rows.Close()
 
-	var orders []model.OrderOutput
+	var page model.OrdersPage
 	for rows.Next() {
 		var o model.OrderOutput
-		err = rows.Scan(&o.Number, &o.Accrual, &o.Status, &o.UploadedAt)
+		var accrual decimal.Decimal
+		err = rows.Scan(&o.ID, &o.Number, &accrual, &o.Status, &o.UploadedAt.Time)
 		if err != nil {
-			return nil, err
+			return model.OrdersPage{}, err
+		}
+
+		if o.Status == model.OrderStatusProcessed {
+			o.Accrual = &accrual
 		}
 
-		orders = append(orders, o)
+		page.Orders = append(page.Orders, o)
+	}
+	if err = rows.Err(); err != nil {
+		return model.OrdersPage{}, err
+	}
+
+	if len(page.Orders) > q.Limit {
+		page.Orders = page.Orders[:q.Limit]
+		last := page.Orders[q.Limit-1]
+		page.Next = &model.Cursor{Time: last.UploadedAt.Time, ID: last.ID}
 	}
 
-	return orders, nil
+	return page, nil
 }
 
 func (r Repository) GetBalanceByUserID(ctx context.Context, uid int) (model.BalanceWithdrawn, error) {
@@ -148,52 +300,792 @@ func (r Repository) GetBalanceByUserID(ctx context.Context, uid int) (model.Bala
 	return bw, nil
 }
 
-func (r Repository) Withdraw(ctx context.Context, i model.WithdrawInput, bw model.BalanceWithdrawn, uid int) error {
-	tx, err := r.Conn.Begin()
-	defer tx.Commit()
+// Withdraw debits the user only if the balance is enough at the moment of the update,
+// the conditional update locks the user's row, so parallel withdrawals can't overdraw the account.
+// An order can be paid with points once, the unique index of order numbers rejects the second withdrawal.
+func (r Repository) Withdraw(ctx context.Context, i model.WithdrawInput, uid int) (err error) {
+	ctx, span := startSpan(ctx, "Repository.Withdraw", orderAttributes(i.OrderNumber, uid)...)
+	defer func() { endSpan(span, err) }()
+
+	err = r.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE users SET balance = balance - $1, withdrawn = withdrawn + $1 WHERE id = $2 AND balance >= $1", i.Sum, uid)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrInsufficientFunds
+		}
+
+		var withdrawalID int
+		err = tx.QueryRowContext(ctx, "INSERT INTO withdraw_history (order_number, user_id, amount, processed_at) VALUES ($1, $2, $3, $4) RETURNING id", i.OrderNumber, uid, i.Sum, time.Now().Format(time.RFC3339)).
+			Scan(&withdrawalID)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return ErrOrderIsAlreadyWithdrawn
+		}
+		if err != nil {
+			return err
+		}
+
+		parts, err := consumeLots(ctx, tx, uid, i.Sum)
+		if err != nil {
+			return err
+		}
+		// the consumed parts are kept with the withdrawal, so a reversal returns them with their credit time
+		for _, p := range parts {
+			_, err = tx.ExecContext(ctx, "INSERT INTO withdrawal_lots (withdrawal_id, amount, remaining, credited_at) VALUES ($1, $2, $2, $3)", withdrawalID, p.amount, p.creditedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		return postLedger(ctx, tx, uid, model.LedgerKindWithdrawal, model.LedgerAccountWithdrawals, i.OrderNumber, i.Sum.Neg())
+	})
+	if err != nil {
+		return err
+	}
+
+	pointsWithdrawn.Add(i.Sum.InexactFloat64())
+	return nil
+}
+
+// Transfer moves points from the user to the user with the login. Lots of the sender are consumed
+// oldest first and passed to the recipient with their credit time, so transferred points expire as before.
+func (r Repository) Transfer(ctx context.Context, i model.TransferInput, uid int, limit model.TransferLimit) (err error) {
+	ctx, span := startSpan(ctx, "Repository.Transfer", attribute.Int("user.id", uid))
+	defer func() { endSpan(span, err) }()
+
+	err = r.WithTx(ctx, func(tx *sql.Tx) error {
+		var recipientID int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE login = $1", i.Login).Scan(&recipientID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecipientNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if recipientID == uid {
+			return ErrSelfTransfer
+		}
+
+		// both rows are locked in the order of ids, so opposite transfers don't deadlock
+		_, err = tx.ExecContext(ctx, "SELECT id FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE", uid, recipientID)
+		if err != nil {
+			return err
+		}
+
+		if limit.Amount.IsPositive() {
+			// processed_at holds the local time like other timestamps, so the start of the window is compared in local time too
+			var sent decimal.Decimal
+			err = tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM transfer_history WHERE user_id = $1 AND direction = $2 AND processed_at >= $3", uid, model.TransferDirectionOut, limit.Since.Local().Format(time.RFC3339)).Scan(&sent)
+			if err != nil {
+				return err
+			}
+			if sent.Add(i.Sum).GreaterThan(limit.Amount) {
+				return ErrTransferLimitExceeded
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE users SET balance = balance - $1 WHERE id = $2 AND balance >= $1", i.Sum, uid)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrInsufficientFunds
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET balance = balance + $1 WHERE id = $2", i.Sum, recipientID)
+		if err != nil {
+			return err
+		}
+
+		parts, err := consumeLots(ctx, tx, uid, i.Sum)
+		if err != nil {
+			return err
+		}
+		for _, p := range parts {
+			_, err = tx.ExecContext(ctx, "INSERT INTO accrual_lots (user_id, amount, remaining, credited_at) VALUES ($1, $2, $2, $3)", recipientID, p.amount, p.creditedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO transfer_history (user_id, counterparty_id, direction, amount, processed_at) VALUES ($1, $2, $3, $4, $5), ($2, $1, $6, $4, $5)",
+			uid, recipientID, model.TransferDirectionOut, i.Sum, time.Now().Format(time.RFC3339), model.TransferDirectionIn)
+		if err != nil {
+			return err
+		}
+
+		err = postLedger(ctx, tx, uid, model.LedgerKindTransfer, model.LedgerAccountTransfers, "", i.Sum.Neg())
+		if err != nil {
+			return err
+		}
+
+		return postLedger(ctx, tx, recipientID, model.LedgerKindTransfer, model.LedgerAccountTransfers, "", i.Sum)
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, "INSERT INTO withdraw_history (order_number, user_id, amount, processed_at) VALUES ($1, $2, $3, $4)", i.OrderNumber, uid, i.Sum, time.Now().Format(time.RFC3339))
+	pointsTransferred.Add(i.Sum.InexactFloat64())
+	return nil
+}
+
+// GetTransferHistory reads a page of transfers sent and received by the user, one row more than the limit
+// tells whether the next page exists.
+func (r Repository) GetTransferHistory(ctx context.Context, uid int, q model.PageQuery) (model.TransfersPage, error) {
+	q.Statuses = nil
+	query, args := pageQuery(`SELECT id, direction, login, amount, processed_at FROM (
+		SELECT t.id, t.user_id, t.direction, u.login, t.amount, t.processed_at
+		FROM transfer_history t JOIN users u ON u.id = t.counterparty_id
+	) transfers WHERE user_id = $1`, []interface{}{uid}, "processed_at", q)
+
+	rows, err := r.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return model.TransfersPage{}, err
+	}
+	defer rows.Close()
+
+	var page model.TransfersPage
+	for rows.Next() {
+		var t model.TransferOutput
+		err = rows.Scan(&t.ID, &t.Direction, &t.Login, &t.Sum, &t.ProcessedAt.Time)
+		if err != nil {
+			return model.TransfersPage{}, err
+		}
+
+		page.Transfers = append(page.Transfers, t)
+	}
+	if err = rows.Err(); err != nil {
+		return model.TransfersPage{}, err
+	}
+
+	if len(page.Transfers) > q.Limit {
+		page.Transfers = page.Transfers[:q.Limit]
+		last := page.Transfers[q.Limit-1]
+		page.Next = &model.Cursor{Time: last.ProcessedAt.Time, ID: last.ID}
+	}
+
+	return page, nil
+}
+
+// ReverseWithdrawal returns a part of the withdrawal of the order to the user, nil i.Sum returns
+// the whole part which isn't reversed yet. The points come back as lots with the credit time of the lots
+// the withdrawal consumed, so the reversal doesn't prolong their expiry.
+func (r Repository) ReverseWithdrawal(ctx context.Context, i model.ReversalInput) (out model.ReversalOutput, err error) {
+	ctx, span := startSpan(ctx, "Repository.ReverseWithdrawal", attribute.String("order.number", i.OrderNumber))
+	defer func() { endSpan(span, err) }()
+
+	err = r.WithTx(ctx, func(tx *sql.Tx) error {
+		var withdrawalID int
+		// the lock of the withdrawal serializes its reversals, an order has at most one withdrawal
+		err := tx.QueryRowContext(ctx, "SELECT id, user_id, amount FROM withdraw_history WHERE order_number = $1 FOR UPDATE", i.OrderNumber).
+			Scan(&withdrawalID, &out.UserID, &out.Withdrawn)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWithdrawalNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var reversed decimal.Decimal
+		err = tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM withdrawal_reversals WHERE withdrawal_id = $1", withdrawalID).Scan(&reversed)
+		if err != nil {
+			return err
+		}
+
+		left := out.Withdrawn.Sub(reversed)
+		out.Sum = left
+		if i.Sum != nil {
+			out.Sum = *i.Sum
+		}
+		if !out.Sum.IsPositive() || out.Sum.GreaterThan(left) {
+			return ErrReversalExceedsWithdrawal
+		}
+
+		now := time.Now().Truncate(time.Second)
+		err = tx.QueryRowContext(ctx, "INSERT INTO withdrawal_reversals (withdrawal_id, amount, reason, created_at) VALUES ($1, $2, $3, $4) RETURNING id", withdrawalID, out.Sum, i.Reason, now.Format(time.RFC3339)).Scan(&out.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET balance = balance + $1, withdrawn = withdrawn - $1 WHERE id = $2", out.Sum, out.UserID)
+		if err != nil {
+			return err
+		}
+
+		parts, err := restoreLots(ctx, tx, withdrawalID, out.Sum)
+		if err != nil {
+			return err
+		}
+		// withdrawals made before their lots were kept have no parts to restore, these points are credited now
+		rest := out.Sum
+		for _, p := range parts {
+			rest = rest.Sub(p.amount)
+		}
+		if rest.IsPositive() {
+			parts = append(parts, lotPart{creditedAt: now, amount: rest})
+		}
+
+		for _, p := range parts {
+			_, err = tx.ExecContext(ctx, "INSERT INTO accrual_lots (user_id, order_number, amount, remaining, credited_at) VALUES ($1, $2, $3, $3, $4)", out.UserID, i.OrderNumber, p.amount, p.creditedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		out.OrderNumber = i.OrderNumber
+		out.Reversed = reversed.Add(out.Sum)
+		out.Reason = i.Reason
+		out.CreatedAt.Time = now
+		return postLedger(ctx, tx, out.UserID, model.LedgerKindReversal, model.LedgerAccountWithdrawals, i.OrderNumber, out.Sum)
+	})
+	if err != nil {
+		return model.ReversalOutput{}, err
+	}
+
+	pointsReversed.Add(out.Sum.InexactFloat64())
+	return out, nil
+}
+
+// GetWithdrawHistory reads a page of the user's withdrawals, one row more than the limit tells whether the next page exists.
+func (r Repository) GetWithdrawHistory(ctx context.Context, uid int, q model.PageQuery) (model.WithdrawalsPage, error) {
+	q.Statuses = nil
+	query, args := pageQuery("SELECT id, order_number, amount, processed_at FROM withdraw_history WHERE user_id = $1", []interface{}{uid}, "processed_at", q)
+
+	rows, err := r.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return model.WithdrawalsPage{}, err
+	}
+	defer rows.Close()
+
+	var page model.WithdrawalsPage
+	for rows.Next() {
+		var w model.WithdrawOutput
+		err = rows.Scan(&w.ID, &w.OrderNumber, &w.Sum, &w.ProcessedAt.Time)
+		if err != nil {
+			return model.WithdrawalsPage{}, err
+		}
+
+		page.Withdrawals = append(page.Withdrawals, w)
+	}
+	if err = rows.Err(); err != nil {
+		return model.WithdrawalsPage{}, err
+	}
+
+	if len(page.Withdrawals) > q.Limit {
+		page.Withdrawals = page.Withdrawals[:q.Limit]
+		last := page.Withdrawals[q.Limit-1]
+		page.Next = &model.Cursor{Time: last.ProcessedAt.Time, ID: last.ID}
+	}
+
+	return page, nil
+}
+
+// pageQuery appends the filters and the position of q to the query and sorts rows by the time column and id,
+// the sort order matches the (user_id, time, id) indexes of history tables.
+func pageQuery(query string, args []interface{}, column string, q model.PageQuery) (string, []interface{}) {
+	if len(q.Statuses) > 0 {
+		placeholders := make([]string, len(q.Statuses))
+		for i, status := range q.Statuses {
+			args = append(args, status)
+			placeholders[i] = "$" + strconv.Itoa(len(args))
+		}
+		query += " AND status IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	// filters come in the offset of the client, columns hold the local time like other timestamps
+	if !q.From.IsZero() {
+		args = append(args, q.From.Local().Format(time.RFC3339Nano))
+		query += fmt.Sprintf(" AND %s >= $%d", column, len(args))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To.Local().Format(time.RFC3339Nano))
+		query += fmt.Sprintf(" AND %s < $%d", column, len(args))
+	}
+	if q.After != nil {
+		args = append(args, q.After.Time, q.After.ID)
+		query += fmt.Sprintf(" AND (%s, id) > ($%d, $%d)", column, len(args)-1, len(args))
+	}
+
+	args = append(args, q.Limit+1)
+	query += fmt.Sprintf(" ORDER BY %s, id LIMIT $%d", column, len(args))
+	return query, args
+}
+
+func (r Repository) UpdateOrderStatus(ctx context.Context, orderNumber string, status string) error {
+	_, err := r.Conn.ExecContext(ctx, "UPDATE orders SET status = $1 WHERE number = $2 AND status NOT IN ($3, $4)", status, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, "UPDATE users SET balance = $1, withdrawn = $2 WHERE id = $3", bw.Balance, bw.Withdrawn, uid)
+	return nil
+}
+
+// MakeAccrual sets the final status of the order and credits the user in the same transaction.
+// Orders which already have a final status are left untouched, so repeated calls credit the user only once.
+// The tier bonus is posted apart from the accrual, so it doesn't count toward the points of the tier.
+func (r Repository) MakeAccrual(ctx context.Context, uid int, status string, orderNumber string, accrual, tierBonus decimal.Decimal) (err error) {
+	ctx, span := startSpan(ctx, "Repository.MakeAccrual", append(orderAttributes(orderNumber, uid), attribute.String("order.status", status))...)
+	defer func() { endSpan(span, err) }()
+
+	credited, rewarded := false, false
+	err = r.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE orders SET status = $1, accrual = $2 WHERE number = $3 AND status NOT IN ($4, $5)", status, accrual.Add(tierBonus), orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			loggerFromContext(ctx, r.Logger).Infof("order %s is already in final status, accrual is skipped", orderNumber)
+			return nil
+		}
+
+		// the referral goes before the accrual, it locks the referee and the referrer in the order of ids
+		// like Transfer does, so the accrual updates the row which is already locked
+		if status == model.OrderStatusProcessed {
+			rewarded, err = rewardReferral(ctx, tx, uid, orderNumber)
+			if err != nil {
+				return err
+			}
+		}
+
+		if accrual.IsPositive() {
+			err = creditPoints(ctx, tx, uid, model.LedgerKindAccrual, model.LedgerAccountAccruals, orderNumber, accrual)
+			if err != nil {
+				return err
+			}
+			credited = true
+		}
+
+		if tierBonus.IsPositive() {
+			err = creditPoints(ctx, tx, uid, model.LedgerKindTierBonus, model.LedgerAccountTierBonuses, orderNumber, tierBonus)
+			if err != nil {
+				return err
+			}
+			credited = true
+		}
+
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
+	if rewarded {
+		referralsRewarded.Inc()
+	}
+	if credited {
+		pointsAccrued.Add(accrual.Add(tierBonus).InexactFloat64())
+	}
 	return nil
 }
 
-func (r Repository) GetWithdrawHistory(ctx context.Context, uid int) ([]model.WithdrawOutput, error) {
-	rows, err := r.Conn.QueryContext(ctx, "SELECT order_number, amount, processed_at FROM withdraw_history WHERE user_id = $1 ORDER BY processed_at DESC", uid)
+// creditPoints posts amount points to the user's balance from the system account as a new lot.
+func creditPoints(ctx context.Context, tx *sql.Tx, uid int, kind, account, orderNumber string, amount decimal.Decimal) error {
+	err := postLedger(ctx, tx, uid, kind, account, orderNumber, amount)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET balance = balance + $1 WHERE id = $2", amount, uid)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO accrual_lots (user_id, order_number, amount, remaining, credited_at) VALUES ($1, NULLIF($2, ''), $3, $3, $4)", uid, orderNumber, amount, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// rewardReferral credits the bonuses of the referral by which the user registered, it is done once
+// with the first processed order of the user. Both users are locked in the order of ids before the credits,
+// so a transfer between them at the same time doesn't deadlock with the reward.
+func rewardReferral(ctx context.Context, tx *sql.Tx, refereeID int, orderNumber string) (bool, error) {
+	var referrerID int
+	var referrerBonus, refereeBonus decimal.Decimal
+	err := tx.QueryRowContext(ctx, "UPDATE referrals SET rewarded_at = $1 WHERE referee_id = $2 AND rewarded_at IS NULL RETURNING referrer_id, referrer_bonus, referee_bonus", time.Now().Format(time.RFC3339), refereeID).
+		Scan(&referrerID, &referrerBonus, &refereeBonus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, "SELECT id FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE", refereeID, referrerID)
+	if err != nil {
+		return false, err
+	}
+
+	if refereeBonus.IsPositive() {
+		err = creditPoints(ctx, tx, refereeID, model.LedgerKindReferral, model.LedgerAccountReferrals, orderNumber, refereeBonus)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if referrerBonus.IsPositive() {
+		err = creditPoints(ctx, tx, referrerID, model.LedgerKindReferral, model.LedgerAccountReferrals, orderNumber, referrerBonus)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// postLedger records the movement of amount points to the user's account from the system account,
+// negative amount moves points from the user to the system account.
+func postLedger(ctx context.Context, tx *sql.Tx, uid int, kind, account, orderNumber string, amount decimal.Decimal) error {
+	var id int
+	err := tx.QueryRowContext(ctx, "INSERT INTO ledger_transactions (kind, order_number, created_at) VALUES ($1, NULLIF($2, ''), $3) RETURNING id", kind, orderNumber, time.Now().Format(time.RFC3339)).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO ledger_entries (transaction_id, account, user_id, amount) VALUES ($1, $2, $3, $4), ($1, $5, NULL, $6)", id, model.LedgerAccountUser, uid, amount, account, amount.Neg())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// lotPart is the amount taken from a lot credited at creditedAt.
+type lotPart struct {
+	creditedAt time.Time
+	amount     decimal.Decimal
+}
+
+// restoreLots takes amount points back from the lots consumed by the withdrawal, the last consumed first,
+// and returns the taken parts. The caller has already locked the withdrawal, so its reversals don't run concurrently.
+func restoreLots(ctx context.Context, tx *sql.Tx, withdrawalID int, amount decimal.Decimal) ([]lotPart, error) {
+	return takeLots(ctx, tx, "SELECT id, remaining, credited_at FROM withdrawal_lots WHERE withdrawal_id = $1 AND remaining > 0 ORDER BY credited_at DESC, id DESC",
+		"UPDATE withdrawal_lots SET remaining = remaining - $1 WHERE id = $2", withdrawalID, amount)
+}
+
+// consumeLots takes amount points from the user's lots oldest first and returns the taken parts. The caller
+// has already locked the user's row by the balance update, so lots of the user aren't changed concurrently.
+func consumeLots(ctx context.Context, tx *sql.Tx, uid int, amount decimal.Decimal) ([]lotPart, error) {
+	return takeLots(ctx, tx, "SELECT id, remaining, credited_at FROM accrual_lots WHERE user_id = $1 AND remaining > 0 ORDER BY credited_at, id FOR UPDATE",
+		"UPDATE accrual_lots SET remaining = remaining - $1 WHERE id = $2", uid, amount)
+}
+
+// takeLots takes amount points from the lots selected by selectQuery in their order and returns the taken parts,
+// updateQuery decreases the remaining points of a lot.
+func takeLots(ctx context.Context, tx *sql.Tx, selectQuery, updateQuery string, arg interface{}, amount decimal.Decimal) ([]lotPart, error) {
+	rows, err := tx.QueryContext(ctx, selectQuery, arg)
 	if err != nil {
 		return nil, err
 	}
 
-	if rows.Err() != nil {
+	type lot struct {
+		id         int
+		remaining  decimal.Decimal
+		creditedAt time.Time
+	}
+
+	var lots []lot
+	for rows.Next() {
+		var l lot
+		err = rows.Scan(&l.id, &l.remaining, &l.creditedAt)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		lots = append(lots, l)
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	var wh []model.WithdrawOutput
+	var parts []lotPart
+	for _, l := range lots {
+		if !amount.IsPositive() {
+			break
+		}
+
+		take := decimal.Min(l.remaining, amount)
+		_, err = tx.ExecContext(ctx, updateQuery, take, l.id)
+		if err != nil {
+			return nil, err
+		}
+		amount = amount.Sub(take)
+		parts = append(parts, lotPart{creditedAt: l.creditedAt, amount: take})
+	}
+
+	return parts, nil
+}
+
+func (r Repository) GetExpiringPoints(ctx context.Context, uid int, creditedBefore time.Time) (decimal.Decimal, error) {
+	var points decimal.Decimal
+
+	err := r.Conn.QueryRowContext(ctx, "SELECT COALESCE(SUM(remaining), 0) FROM accrual_lots WHERE user_id = $1 AND remaining > 0 AND credited_at < $2", uid, creditedBefore.Format(time.RFC3339)).Scan(&points)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return points, nil
+}
+
+// GetUsersWithExpiredLots returns up to limit users who have points credited before the time.
+func (r Repository) GetUsersWithExpiredLots(ctx context.Context, creditedBefore time.Time, limit int) ([]int, error) {
+	rows, err := r.Conn.QueryContext(ctx, "SELECT DISTINCT user_id FROM accrual_lots WHERE remaining > 0 AND credited_at < $1 ORDER BY user_id LIMIT $2", creditedBefore.Format(time.RFC3339), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uids []int
 	for rows.Next() {
-		var w model.WithdrawOutput
-		err = rows.Scan(&w.OrderNumber, &w.Sum, &w.ProcessedAt)
+		var uid int
+		err = rows.Scan(&uid)
 		if err != nil {
 			return nil, err
 		}
+		uids = append(uids, uid)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uids, nil
+}
+
+// ExpireLots takes away the points of the user's lots credited before the time and records an expiry
+// for every lot. It returns the number of expired points.
+func (r Repository) ExpireLots(ctx context.Context, uid int, creditedBefore time.Time) (decimal.Decimal, error) {
+	expired := decimal.Zero
+
+	err := r.WithTx(ctx, func(tx *sql.Tx) error {
+		// the user's row is locked before lots like in Withdraw, so expiry and withdrawals don't deadlock
+		_, err := tx.ExecContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", uid)
+		if err != nil {
+			return err
+		}
 
-		wh = append(wh, w)
+		rows, err := tx.QueryContext(ctx, "UPDATE accrual_lots l SET remaining = 0 FROM (SELECT id, remaining FROM accrual_lots WHERE user_id = $1 AND remaining > 0 AND credited_at < $2 FOR UPDATE) old WHERE l.id = old.id RETURNING COALESCE(l.order_number, ''), old.remaining", uid, creditedBefore.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+
+		type lot struct {
+			orderNumber string
+			remaining   decimal.Decimal
+		}
+
+		var lots []lot
+		for rows.Next() {
+			var l lot
+			err = rows.Scan(&l.orderNumber, &l.remaining)
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+			lots = append(lots, l)
+		}
+		if err = rows.Close(); err != nil {
+			return err
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, l := range lots {
+			err = postLedger(ctx, tx, uid, model.LedgerKindExpiry, model.LedgerAccountExpirations, l.orderNumber, l.remaining.Neg())
+			if err != nil {
+				return err
+			}
+			expired = expired.Add(l.remaining)
+		}
+
+		if !expired.IsPositive() {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET balance = balance - $1 WHERE id = $2", expired, uid)
+		return err
+	})
+	if err != nil {
+		return decimal.Zero, err
 	}
 
-	return wh, nil
+	return expired, nil
 }
 
-func (r Repository) UpdateOrderStatus(ctx context.Context, orderNumber string, status string) error {
-	_, err := r.Conn.ExecContext(ctx, "UPDATE orders SET status = $1 WHERE number = $2", status, orderNumber)
+// GetUserTier reads the stored tier of the user and sums the points accrued since the time.
+func (r Repository) GetUserTier(ctx context.Context, uid int, since time.Time) (model.TierState, error) {
+	var state model.TierState
+	var reviewAt, oldest sql.NullTime
+
+	err := r.Conn.QueryRowContext(ctx, "SELECT u.tier, u.tier_review_at, COALESCE(a.points, 0), a.oldest FROM users u LEFT JOIN LATERAL (SELECT SUM(e.amount) AS points, MIN(t.created_at) AS oldest FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id WHERE e.user_id = u.id AND e.account = $2 AND t.kind = $3 AND t.created_at >= $4) a ON true WHERE u.id = $1",
+		uid, model.LedgerAccountUser, model.LedgerKindAccrual, since.Format(time.RFC3339)).Scan(&state.Tier, &reviewAt, &state.Points, &oldest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.TierState{}, ErrNoRecords
+	}
+	if err != nil {
+		return model.TierState{}, err
+	}
+
+	if reviewAt.Valid {
+		state.ReviewAt = &reviewAt.Time
+	}
+	if oldest.Valid {
+		state.OldestAccrualAt = &oldest.Time
+	}
+
+	return state, nil
+}
+
+// GetReferrals reads the referral code of the user and a page of users who registered by it,
+// one row more than the limit tells whether the next page exists.
+func (r Repository) GetReferrals(ctx context.Context, uid int, q model.PageQuery) (model.ReferralsPage, error) {
+	var page model.ReferralsPage
+	err := r.Conn.QueryRowContext(ctx, "SELECT referral_code FROM users WHERE id = $1", uid).Scan(&page.Code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.ReferralsPage{}, ErrNoRecords
+	}
+	if err != nil {
+		return model.ReferralsPage{}, err
+	}
+
+	q.Statuses = nil
+	query, args := pageQuery(`SELECT id, login, created_at, status, bonus FROM (
+		SELECT r.id, r.referrer_id, u.login, r.created_at, r.referrer_bonus AS bonus,
+			CASE WHEN r.capped THEN $2 WHEN r.rewarded_at IS NULL THEN $3 ELSE $4 END AS status
+		FROM referrals r JOIN users u ON u.id = r.referee_id
+	) referrals WHERE referrer_id = $1`,
+		[]interface{}{uid, model.ReferralStatusCapped, model.ReferralStatusPending, model.ReferralStatusRewarded}, "created_at", q)
+
+	rows, err := r.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return model.ReferralsPage{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o model.ReferralOutput
+		err = rows.Scan(&o.ID, &o.Login, &o.RegisteredAt.Time, &o.Status, &o.Bonus)
+		if err != nil {
+			return model.ReferralsPage{}, err
+		}
+
+		page.Referrals = append(page.Referrals, o)
+	}
+	if err = rows.Err(); err != nil {
+		return model.ReferralsPage{}, err
+	}
+
+	if len(page.Referrals) > q.Limit {
+		page.Referrals = page.Referrals[:q.Limit]
+		last := page.Referrals[q.Limit-1]
+		page.Next = &model.Cursor{Time: last.RegisteredAt.Time, ID: last.ID}
+	}
+
+	return page, nil
+}
+
+// SetUserTier stores the evaluated tier and records the event if the tier has changed. The update is skipped
+// if the tier isn't c.From anymore, a concurrent evaluation has already stored the newer one.
+func (r Repository) SetUserTier(ctx context.Context, uid int, c model.TierChange) error {
+	var reviewAt interface{}
+	if c.ReviewAt != nil {
+		reviewAt = c.ReviewAt.Format(time.RFC3339)
+	}
+
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE users SET tier = $1, tier_review_at = $2 WHERE id = $3 AND tier = $4", c.To, reviewAt, uid, c.From)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 || c.From == c.To {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO tier_events (user_id, from_tier, to_tier, points, created_at) VALUES ($1, $2, $3, $4, $5)", uid, c.From, c.To, c.Points, time.Now().Format(time.RFC3339))
+		return err
+	})
+}
+
+// GetUsersForTierReview returns up to limit users after the id whose tier review is due before the time.
+func (r Repository) GetUsersForTierReview(ctx context.Context, before time.Time, afterID, limit int) ([]int, error) {
+	rows, err := r.Conn.QueryContext(ctx, "SELECT id FROM users WHERE tier_review_at < $1 AND id > $2 ORDER BY id LIMIT $3", before.Format(time.RFC3339), afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uids []int
+	for rows.Next() {
+		var uid int
+		err = rows.Scan(&uid)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uids, nil
+}
+
+// LeaseAccrualJobs takes up to limit jobs which are due and hides them from other pollers for the lease duration.
+// If the job isn't rescheduled or completed until the lease expires, it becomes available again.
+func (r Repository) LeaseAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]model.AccrualJob, error) {
+	rows, err := r.Conn.QueryContext(ctx, "UPDATE accrual_jobs SET attempts = attempts + 1, next_attempt_at = now() + $1 * INTERVAL '1 millisecond' WHERE id IN (SELECT id FROM accrual_jobs WHERE next_attempt_at <= now() ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED) RETURNING id, order_number, user_id, attempts, failures, created_at::text, COALESCE(trace_context, ''), COALESCE(request_id, '')", lease.Milliseconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []model.AccrualJob
+	for rows.Next() {
+		var j model.AccrualJob
+		var createdAt string
+		err = rows.Scan(&j.ID, &j.OrderNumber, &j.UserID, &j.Attempts, &j.Failures, &createdAt, &j.TraceContext, &j.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		j.CreatedAt, err = parseTimestamp(createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+func (r Repository) RescheduleAccrualJob(ctx context.Context, id int, delay time.Duration, failures int, lastError string) error {
+	_, err := r.Conn.ExecContext(ctx, "UPDATE accrual_jobs SET next_attempt_at = now() + $1 * INTERVAL '1 millisecond', failures = $2, last_error = $3 WHERE id = $4", delay.Milliseconds(), failures, lastError, id)
 	if err != nil {
 		return err
 	}
@@ -201,21 +1093,161 @@ func (r Repository) UpdateOrderStatus(ctx context.Context, orderNumber string, s
 	return nil
 }
 
-func (r Repository) MakeAccrual(ctx context.Context, uid int, status string, orderNumber string, accrual decimal.Decimal, balance decimal.Decimal) error {
-	tx, err := r.Conn.Begin()
-	defer tx.Commit()
+func (r Repository) CompleteAccrualJob(ctx context.Context, id int) error {
+	_, err := r.Conn.ExecContext(ctx, "DELETE FROM accrual_jobs WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateSession saves the session, only the hash of the refresh token is stored.
+func (r Repository) CreateSession(ctx context.Context, s model.Session, refreshTokenHash string) error {
+	_, err := r.Conn.ExecContext(ctx, "INSERT INTO sessions (id, user_id, refresh_token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)", s.ID, s.UserID, refreshTokenHash, time.Now().Format(time.RFC3339), s.ExpiresAt.Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r Repository) GetSessionByRefreshToken(ctx context.Context, refreshTokenHash string) (model.Session, error) {
+	var s model.Session
+	row := r.Conn.QueryRowContext(ctx, "SELECT id, user_id, expires_at, revoked_at IS NOT NULL FROM sessions WHERE refresh_token_hash = $1", refreshTokenHash)
+
+	err := row.Scan(&s.ID, &s.UserID, &s.ExpiresAt, &s.Revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Session{}, ErrNoRecords
+	}
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	return s, nil
+}
+
+// RotateRefreshToken replaces the refresh token of the active session. It fails with ErrInvalidToken
+// if the old token was already rotated, so the same refresh token can't be used twice.
+func (r Repository) RotateRefreshToken(ctx context.Context, sid, oldHash, newHash string, expiresAt time.Time) error {
+	res, err := r.Conn.ExecContext(ctx, "UPDATE sessions SET refresh_token_hash = $1, expires_at = $2 WHERE id = $3 AND refresh_token_hash = $4 AND revoked_at IS NULL AND expires_at > now()", newHash, expiresAt.Format(time.RFC3339), sid, oldHash)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func (r Repository) IsSessionActive(ctx context.Context, sid string) (bool, error) {
+	active := false
 
-	_, err = tx.ExecContext(ctx, "UPDATE orders SET status = $1, accrual = $2 WHERE number = $3", status, accrual, orderNumber)
+	row := r.Conn.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > now())", sid)
+	err := row.Scan(&active)
+	if err != nil {
+		return false, err
+	}
+
+	return active, nil
+}
+
+func (r Repository) RevokeSession(ctx context.Context, sid string) error {
+	_, err := r.Conn.ExecContext(ctx, "UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", time.Now().Format(time.RFC3339), sid)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, "UPDATE users SET balance = $1 WHERE id = $2", balance, uid)
+	return nil
+}
+
+func (r Repository) RevokeUserSessions(ctx context.Context, uid int) error {
+	_, err := r.Conn.ExecContext(ctx, "UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", time.Now().Format(time.RFC3339), uid)
 	if err != nil {
 		return err
 	}
+
 	return nil
 }
+
+func (r Repository) Ping(ctx context.Context) error {
+	return r.Conn.PingContext(ctx)
+}
+
+// GetMigrationVersion returns the version of the latest migration applied to the database.
+// Like goose, it takes the newest record of every version, so rolled back migrations don't count.
+func (r Repository) GetMigrationVersion(ctx context.Context) (int64, error) {
+	rows, err := r.Conn.QueryContext(ctx, "SELECT version_id, is_applied FROM "+goose.TableName()+" ORDER BY id DESC")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	skip := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		var applied bool
+		err = rows.Scan(&version, &applied)
+		if err != nil {
+			return 0, err
+		}
+
+		if skip[version] {
+			continue
+		}
+		if applied {
+			return version, nil
+		}
+		skip[version] = true
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+func (r Repository) CountAccrualJobs(ctx context.Context) (int, error) {
+	var n int
+	err := r.Conn.QueryRowContext(ctx, "SELECT count(*) FROM accrual_jobs").Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (r Repository) GetPendingOrderStats(ctx context.Context) ([]model.PendingOrderStats, error) {
+	rows, err := r.Conn.QueryContext(ctx, "SELECT status, count(*), min(uploaded_at)::text FROM orders WHERE status IN ($1, $2, $3) GROUP BY status", model.OrderStatusNew, model.OrderStatusRegistered, model.OrderStatusProcessing)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []model.PendingOrderStats
+	for rows.Next() {
+		var s model.PendingOrderStats
+		var oldest string
+		err = rows.Scan(&s.Status, &s.Count, &oldest)
+		if err != nil {
+			return nil, err
+		}
+		s.OldestUploadedAt, err = parseTimestamp(oldest)
+		if err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	apiPrefix   = "/api"
+	apiV1Prefix = "/api/v1"
+)
+
+// RegisterRoutes mounts the API at /api and /api/v1. Both serve the contract of the specification,
+// /api additionally keeps deprecated routes of older clients.
+func RegisterRoutes(app *fiber.App, h *Handlers) {
+	app.Get(apiPrefix+"/openapi.json", h.OpenAPI)
+	app.Get(apiV1Prefix+"/openapi.json", h.OpenAPI)
+
+	legacy := app.Group(apiPrefix)
+	registerUserRoutes(legacy, h)
+	registerOperatorRoutes(legacy, h)
+	legacy.Get("/user/balance/withdraw", deprecated(apiV1Prefix+"/user/withdrawals"), h.Authorize, h.WithdrawHistory)
+
+	v1 := app.Group(apiV1Prefix)
+	registerUserRoutes(v1, h)
+	registerOperatorRoutes(v1, h)
+
+	//unknown routes are answered by fiber without the error handler
+	app.Use(func(c *fiber.Ctx) error {
+		return fiber.ErrNotFound
+	})
+}
+
+func registerUserRoutes(api fiber.Router, h *Handlers) {
+	usr := api.Group("/user")
+	usr.Post("/login", h.Login)
+	usr.Post("/register", h.Register)
+	usr.Post("/token/refresh", h.RefreshToken)
+	// the refresh token outlives the access token, Logout checks the tokens by itself
+	usr.Post("/logout", h.Logout)
+	usr.Post("/logout/all", h.Authorize, h.LogoutAll)
+
+	usr.Get("/orders", h.Authorize, h.GetOrders)
+	usr.Post("/orders", h.Authorize, h.CreateOrder)
+
+	usr.Get("/balance", h.Authorize, h.GetBalance)
+	usr.Post("/balance/withdraw", h.Authorize, h.Withdraw)
+	usr.Post("/balance/transfer", h.Authorize, h.Transfer)
+	usr.Get("/balance/transfers", h.Authorize, h.TransferHistory)
+
+	// the specification names both paths
+	usr.Get("/withdrawals", h.Authorize, h.WithdrawHistory)
+	usr.Get("/balance/withdrawals", h.Authorize, h.WithdrawHistory)
+
+	usr.Get("/tier", h.Authorize, h.GetTier)
+	usr.Get("/referrals", h.Authorize, h.GetReferrals)
+}
+
+func registerOperatorRoutes(api fiber.Router, h *Handlers) {
+	op := api.Group("/operator")
+	op.Post("/withdrawals/:number/reversals", h.AuthorizeOperator, h.ReverseWithdrawal)
+}
+
+// deprecated marks the response of a deprecated route and points to its successor.
+func deprecated(successor string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Deprecation", "true")
+		c.Set(fiber.HeaderLink, "<"+successor+`>; rel="successor-version"`)
+		return c.Next()
+	}
+}
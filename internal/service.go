@@ -2,46 +2,93 @@ package internal
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/shopspring/decimal"
 	"github.com/theplant/luhn"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/DrGermanius/Gophermart/internal/model"
 )
 
 //go:generate mockgen -source service.go -destination ./mock/service.go
 
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	// DefaultPageLimit is the size of a history page when the client doesn't ask for another one
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+
+	// ReferralCapWindow is the period in which the referrer gets at most ReferralCap bonuses
+	ReferralCapWindow = 30 * 24 * time.Hour
+)
+
 type IService interface {
-	Register(context.Context, string, string) (string, error)
-	Login(context.Context, string, string) (string, error)
-	GetJWTToken(string) (string, error)
+	Register(context.Context, string, string, string) (model.Tokens, error)
+	Login(context.Context, string, string) (model.Tokens, error)
+	RefreshTokens(context.Context, string) (model.Tokens, error)
+	Logout(context.Context, string) error
+	LogoutByRefreshToken(context.Context, string) error
+	LogoutAll(context.Context, int) error
+	IsSessionActive(context.Context, string) (bool, error)
+	GetJWTToken(string, string) (string, error)
 	SendOrder(context.Context, string, int) error
-	GetOrders(context.Context, int) ([]model.OrderOutput, error)
+	GetOrders(context.Context, int, model.PageQuery) (model.OrdersPage, error)
 	GetBalanceByUserID(context.Context, int) (model.BalanceWithdrawn, error)
 	Withdraw(context.Context, model.WithdrawInput, int) error
-	GetWithdrawHistory(context.Context, int) ([]model.WithdrawOutput, error)
+	GetWithdrawHistory(context.Context, int, model.PageQuery) (model.WithdrawalsPage, error)
+	GetTier(context.Context, int) (model.TierOutput, error)
+	GetReferrals(context.Context, int, model.PageQuery) (model.ReferralsPage, error)
+	Transfer(context.Context, model.TransferInput, int) error
+	GetTransferHistory(context.Context, int, model.PageQuery) (model.TransfersPage, error)
+	ReverseWithdrawal(context.Context, model.ReversalInput) (model.ReversalOutput, error)
+}
+
+// LoyaltyRules are the business settings of the loyalty program.
+type LoyaltyRules struct {
+	// PointsTTL is the number of months accrued points live, 0 means they don't expire
+	PointsTTL int
+	// ReferralBonus is credited to both the referrer and the referee after the first processed order of the referee
+	ReferralBonus decimal.Decimal
+	// ReferralCap is the number of referral bonuses of a referrer in ReferralCapWindow, 0 means no cap
+	ReferralCap int
+	// TransferDailyLimit is the number of points a user may send to others in a UTC day, 0 means no limit
+	TransferDailyLimit decimal.Decimal
 }
 
-func NewService(Repository IRepository, AccrualService IAccrual, secret string, logger *zap.SugaredLogger) *Service {
-	return &Service{Repository: Repository, AccrualService: AccrualService, secret: secret, logger: logger}
+func NewService(Repository IRepository, AccrualService IAccrual, rules LoyaltyRules, secret string, logger *zap.SugaredLogger) *Service {
+	return &Service{Repository: Repository, AccrualService: AccrualService, rules: rules, secret: secret, logger: logger}
 }
 
 type Service struct {
 	Repository     IRepository
 	AccrualService IAccrual
+	rules          LoyaltyRules
 	secret         string
 	logger         *zap.SugaredLogger
 }
 
-func (s Service) SendOrder(ctx context.Context, orderNumber string, uid int) error {
+func (s Service) SendOrder(ctx context.Context, orderNumber string, uid int) (err error) {
+	ctx, span := startSpan(ctx, "Service.SendOrder", orderAttributes(orderNumber, uid)...)
+	defer func() { endSpan(span, err) }()
+
 	o, err := strconv.Atoi(orderNumber)
 	if err != nil {
-		return err
+		return ErrOrderNumberIsNotNumeric
 	}
 
 	if !luhn.Valid(o) {
@@ -66,51 +113,198 @@ func (s Service) SendOrder(ctx context.Context, orderNumber string, uid int) err
 		return err
 	}
 
-	go s.AccrualService.SendToQueue(ctx, uid, orderNumber)
+	s.AccrualService.Notify()
 	return nil
 }
 
-func (s Service) Register(ctx context.Context, login, password string) (string, error) {
+// Register creates the user, referralCode is the optional code of the user who invited the new one.
+func (s Service) Register(ctx context.Context, login, password, referralCode string) (model.Tokens, error) {
 	exist, err := s.Repository.IsUserExist(ctx, login)
 	if err != nil {
-		return "", err
+		return model.Tokens{}, err
 	}
 
 	if exist {
-		return "", ErrLoginIsAlreadyTaken
+		return model.Tokens{}, ErrLoginIsAlreadyTaken
 	}
 
-	h := GetHash(password)
-	id, err := s.Repository.Register(ctx, login, h)
+	h, err := HashPassword(password)
 	if err != nil {
-		return "", err
+		return model.Tokens{}, err
 	}
 
-	token, err := s.GetJWTToken(strconv.Itoa(id))
+	code, err := newReferralCode()
 	if err != nil {
-		return "", err
+		return model.Tokens{}, err
+	}
+
+	var terms *model.ReferralTerms
+	if referralCode != "" {
+		terms = &model.ReferralTerms{
+			Code:     strings.ToUpper(strings.TrimSpace(referralCode)),
+			Bonus:    s.rules.ReferralBonus,
+			Cap:      s.rules.ReferralCap,
+			CapSince: time.Now().Add(-ReferralCapWindow),
+		}
+	}
+
+	id, err := s.Repository.Register(ctx, login, h, code, terms)
+	if err != nil {
+		return model.Tokens{}, err
 	}
-	return token, nil
+
+	return s.startSession(ctx, id)
 }
 
-func (s Service) Login(ctx context.Context, login, password string) (string, error) {
-	h := GetHash(password)
-	id, err := s.Repository.CheckCredentials(ctx, login, h)
+func (s Service) Login(ctx context.Context, login, password string) (model.Tokens, error) {
+	u, err := s.Repository.GetUserByLogin(ctx, login)
+	if errors.Is(err, ErrNoRecords) {
+		// the password is checked anyway, so the response time doesn't tell which logins exist
+		checkPassword(dummyPasswordHash, password)
+		return model.Tokens{}, ErrInvalidCredentials
+	}
 	if err != nil {
-		return "", err
+		return model.Tokens{}, err
+	}
+
+	ok, rehash := checkPassword(u.Password, password)
+	if !ok {
+		return model.Tokens{}, ErrInvalidCredentials
+	}
+
+	if rehash {
+		s.rehashPassword(ctx, u.ID, password)
+	}
+
+	return s.startSession(ctx, u.ID)
+}
+
+// rehashPassword replaces an outdated hash after successful login, failure doesn't prevent the login.
+func (s Service) rehashPassword(ctx context.Context, uid int, password string) {
+	h, err := HashPassword(password)
+	if err != nil {
+		loggerFromContext(ctx, s.logger).Errorf("rehash password error: %s", err.Error())
+		return
 	}
 
-	token, err := s.GetJWTToken(strconv.Itoa(id))
+	err = s.Repository.UpdatePassword(ctx, uid, h)
 	if err != nil {
-		return "", err
+		loggerFromContext(ctx, s.logger).Errorf("rehash password error: %s", err.Error())
+	}
+}
+
+// startSession creates a server-side session, access tokens carry its id,
+// so they stop working as soon as the session is revoked.
+func (s Service) startSession(ctx context.Context, uid int) (model.Tokens, error) {
+	sid, err := randomToken(16)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	session := model.Session{
+		ID:        sid,
+		UserID:    uid,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+
+	err = s.Repository.CreateSession(ctx, session, hashToken(refreshToken))
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	accessToken, err := s.GetJWTToken(strconv.Itoa(uid), sid)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	return model.Tokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// RefreshTokens issues a new access token and replaces the refresh token, the old one can't be used anymore.
+func (s Service) RefreshTokens(ctx context.Context, refreshToken string) (model.Tokens, error) {
+	if refreshToken == "" {
+		return model.Tokens{}, ErrInvalidToken
+	}
+
+	h := hashToken(refreshToken)
+	session, err := s.Repository.GetSessionByRefreshToken(ctx, h)
+	if errors.Is(err, ErrNoRecords) {
+		return model.Tokens{}, ErrInvalidToken
+	}
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	if session.Revoked || session.ExpiresAt.Before(time.Now()) {
+		return model.Tokens{}, ErrInvalidToken
+	}
+
+	newRefreshToken, err := randomToken(32)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	err = s.Repository.RotateRefreshToken(ctx, session.ID, h, hashToken(newRefreshToken), time.Now().Add(RefreshTokenTTL))
+	if err != nil {
+		return model.Tokens{}, err
 	}
-	return token, nil
+
+	accessToken, err := s.GetJWTToken(strconv.Itoa(session.UserID), session.ID)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	return model.Tokens{AccessToken: accessToken, RefreshToken: newRefreshToken}, nil
+}
+
+func (s Service) Logout(ctx context.Context, sid string) error {
+	return s.Repository.RevokeSession(ctx, sid)
+}
+
+// LogoutByRefreshToken revokes the session of the refresh token. Expired sessions are revoked as well,
+// the refresh token only has to belong to a session.
+func (s Service) LogoutByRefreshToken(ctx context.Context, refreshToken string) error {
+	if refreshToken == "" {
+		return ErrInvalidToken
+	}
+
+	session, err := s.Repository.GetSessionByRefreshToken(ctx, hashToken(refreshToken))
+	if errors.Is(err, ErrNoRecords) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.Repository.RevokeSession(ctx, session.ID)
 }
 
-func (s Service) GetJWTToken(uid string) (string, error) {
-	claims := jwt.MapClaims{
-		"id":  uid,
-		"exp": time.Now().Add(time.Hour * 72).Unix(),
+// LogoutAll revokes all sessions of the user, e.g. when the token was stolen.
+func (s Service) LogoutAll(ctx context.Context, uid int) error {
+	return s.Repository.RevokeUserSessions(ctx, uid)
+}
+
+func (s Service) IsSessionActive(ctx context.Context, sid string) (bool, error) {
+	return s.Repository.IsSessionActive(ctx, sid)
+}
+
+func (s Service) GetJWTToken(uid string, sid string) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Subject:   uid,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		SessionID: sid,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -123,74 +317,258 @@ func (s Service) GetJWTToken(uid string) (string, error) {
 	return t, nil
 }
 
-func (s Service) GetOrders(ctx context.Context, uid int) ([]model.OrderOutput, error) {
-	orders, err := s.Repository.GetOrders(ctx, uid)
+func (s Service) GetOrders(ctx context.Context, uid int, q model.PageQuery) (model.OrdersPage, error) {
+	page, err := s.Repository.GetOrders(ctx, uid, pageLimit(q))
 	if err != nil {
-		return nil, err
+		return model.OrdersPage{}, err
 	}
 
-	if len(orders) == 0 {
-		return nil, ErrNoRecords
+	if len(page.Orders) == 0 {
+		return model.OrdersPage{}, ErrNoRecords
 	}
-	return orders, nil
+	return page, nil
 }
 
+// GetBalanceByUserID returns the balance with the points which expire in ExpiringSoonWindow.
 func (s Service) GetBalanceByUserID(ctx context.Context, uid int) (model.BalanceWithdrawn, error) {
 	bw, err := s.Repository.GetBalanceByUserID(ctx, uid)
 	if err != nil {
 		return bw, err
 	}
 
+	if s.rules.PointsTTL <= 0 {
+		return bw, nil
+	}
+
+	bw.ExpiringSoon, err = s.Repository.GetExpiringPoints(ctx, uid, expiryCutoff(time.Now().Add(ExpiringSoonWindow), s.rules.PointsTTL))
+	if err != nil {
+		return model.BalanceWithdrawn{}, err
+	}
+
 	return bw, nil
 }
 
-func (s Service) Withdraw(ctx context.Context, i model.WithdrawInput, uid int) error {
+func (s Service) Withdraw(ctx context.Context, i model.WithdrawInput, uid int) (err error) {
+	ctx, span := startSpan(ctx, "Service.Withdraw", orderAttributes(i.OrderNumber, uid)...)
+	defer func() { endSpan(span, err) }()
 
 	o, err := strconv.Atoi(i.OrderNumber)
 	if err != nil {
-		return err
+		return ErrOrderNumberIsNotNumeric
 	}
 
 	if !luhn.Valid(o) {
 		return ErrLuhnInvalid
 	}
 
-	bw, err := s.Repository.GetBalanceByUserID(ctx, uid)
+	err = s.Repository.Withdraw(ctx, i, uid)
 	if err != nil {
 		return err
 	}
 
-	if bw.Balance.LessThan(i.Sum) {
-		return ErrInsufficientFunds
+	return nil
+}
+
+func (s Service) GetWithdrawHistory(ctx context.Context, uid int, q model.PageQuery) (model.WithdrawalsPage, error) {
+	page, err := s.Repository.GetWithdrawHistory(ctx, uid, pageLimit(q))
+	if err != nil {
+		return model.WithdrawalsPage{}, err
+	}
+
+	if len(page.Withdrawals) == 0 {
+		return model.WithdrawalsPage{}, ErrNoRecords
 	}
+	return page, nil
+}
 
-	newBw := model.BalanceWithdrawn{
-		Balance:   bw.Balance.Sub(i.Sum),
-		Withdrawn: bw.Withdrawn.Add(i.Sum),
+// Transfer moves points to another user. It fails with ErrInsufficientFunds as Withdraw does
+// if the balance is less than the sum.
+func (s Service) Transfer(ctx context.Context, i model.TransferInput, uid int) (err error) {
+	ctx, span := startSpan(ctx, "Service.Transfer", attribute.Int("user.id", uid))
+	defer func() { endSpan(span, err) }()
+
+	limit := model.TransferLimit{
+		Amount: s.rules.TransferDailyLimit,
+		Since:  time.Now().UTC().Truncate(24 * time.Hour),
 	}
 
-	err = s.Repository.Withdraw(ctx, i, newBw, uid)
+	return s.Repository.Transfer(ctx, i, uid, limit)
+}
+
+func (s Service) GetTransferHistory(ctx context.Context, uid int, q model.PageQuery) (model.TransfersPage, error) {
+	page, err := s.Repository.GetTransferHistory(ctx, uid, pageLimit(q))
 	if err != nil {
-		return err
+		return model.TransfersPage{}, err
 	}
 
-	return nil
+	if len(page.Transfers) == 0 {
+		return model.TransfersPage{}, ErrNoRecords
+	}
+	return page, nil
+}
+
+// ReverseWithdrawal returns the points of a cancelled store order to the user, it is called by operators.
+func (s Service) ReverseWithdrawal(ctx context.Context, i model.ReversalInput) (out model.ReversalOutput, err error) {
+	ctx, span := startSpan(ctx, "Service.ReverseWithdrawal", attribute.String("order.number", i.OrderNumber))
+	defer func() { endSpan(span, err) }()
+
+	out, err = s.Repository.ReverseWithdrawal(ctx, i)
+	if err != nil {
+		return model.ReversalOutput{}, err
+	}
+
+	loggerFromContext(ctx, s.logger).Infow("withdrawal is reversed",
+		"order_number", out.OrderNumber,
+		"user_id", out.UserID,
+		"sum", out.Sum,
+		"reversal_id", out.ID,
+	)
+	return out, nil
+}
+
+// pageLimit keeps the page size within [1, MaxPageLimit], a missing limit means DefaultPageLimit.
+func pageLimit(q model.PageQuery) model.PageQuery {
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageLimit
+	}
+	if q.Limit > MaxPageLimit {
+		q.Limit = MaxPageLimit
+	}
+	return q
+}
+
+const (
+	passwordHashCost = bcrypt.DefaultCost
+	// maxBcryptPassword is the number of bytes bcrypt accepts
+	maxBcryptPassword = 72
+)
+
+// dummyPasswordHash is checked on login of unknown users to spend the same time as for known ones.
+var dummyPasswordHash, _ = HashPassword("dummy password")
+
+// HashPassword hashes the password with bcrypt, the salt is generated for every hash and stored inside it.
+func HashPassword(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword(bcryptInput(password), passwordHashCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(h), nil
 }
 
-func (s Service) GetWithdrawHistory(ctx context.Context, uid int) ([]model.WithdrawOutput, error) {
-	wh, err := s.Repository.GetWithdrawHistory(ctx, uid)
+// checkPassword verifies the password against the stored hash. needsRehash reports that the hash
+// was made by the legacy scheme or with a lower cost and has to be replaced.
+func checkPassword(hash, password string) (ok bool, needsRehash bool) {
+	cost, err := bcrypt.Cost([]byte(hash))
 	if err != nil {
-		return nil, err
+		legacy := legacyHash(password)
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(legacy)) == 1, true
 	}
 
-	if len(wh) == 0 {
-		return nil, ErrNoRecords
+	if bcrypt.CompareHashAndPassword([]byte(hash), bcryptInput(password)) != nil {
+		return false, false
 	}
-	return wh, nil
+
+	return true, cost < passwordHashCost
 }
 
-func GetHash(s string) string {
+// bcryptInput returns the password as is if bcrypt accepts it. Longer passwords are replaced by their
+// base64 encoded SHA-256, so they don't fail to hash and all their bytes count.
+func bcryptInput(password string) []byte {
+	if len(password) <= maxBcryptPassword {
+		return []byte(password)
+	}
+
+	h := sha256.Sum256([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(h[:]))
+}
+
+// legacyHash is the scheme used before bcrypt, it's kept only to migrate users on their next login.
+func legacyHash(s string) string {
 	h := sha256.New()
 	ph := h.Sum([]byte(s))
 	return base64.StdEncoding.EncodeToString(ph)
 }
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newReferralCode returns 8 random characters of the base32 alphabet.
+func newReferralCode() (string, error) {
+	b := make([]byte, 5)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes refresh tokens before storing, they are random enough to not need a salt.
+func hashToken(t string) string {
+	h := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(h[:])
+}
+
+// GetTier computes the tier of the user and describes the progress to the next one. The tier isn't stored,
+// accruals and TierReview do that.
+func (s Service) GetTier(ctx context.Context, uid int) (model.TierOutput, error) {
+	state, err := s.Repository.GetUserTier(ctx, uid, time.Now().AddDate(0, -TierWindow, 0))
+	if errors.Is(err, ErrNoRecords) {
+		return model.TierOutput{}, ErrUserNotFound
+	}
+	if err != nil {
+		return model.TierOutput{}, err
+	}
+
+	tier, reviewAt := computeTier(state)
+
+	out := model.TierOutput{
+		Tier:       tier,
+		Multiplier: tierRule(tier).Multiplier,
+		Points:     state.Points,
+	}
+
+	if next, ok := nextTier(tier); ok {
+		left := decimal.Max(next.Threshold.Sub(state.Points), decimal.Zero)
+		out.NextTier = next.Tier
+		out.NextTierPoints = &next.Threshold
+		out.PointsToNextTier = &left
+	}
+
+	if reviewAt != nil {
+		out.ReevaluatedAt = &model.RFC3339Time{Time: *reviewAt}
+	}
+
+	return out, nil
+}
+
+// GetReferrals returns the referral code of the user and a page of users who registered by it,
+// their logins are masked.
+func (s Service) GetReferrals(ctx context.Context, uid int, q model.PageQuery) (model.ReferralsPage, error) {
+	page, err := s.Repository.GetReferrals(ctx, uid, pageLimit(q))
+	if err != nil {
+		return model.ReferralsPage{}, err
+	}
+
+	for i := range page.Referrals {
+		page.Referrals[i].Login = maskLogin(page.Referrals[i].Login)
+	}
+	return page, nil
+}
+
+// maskLogin keeps the first and the last characters of the login.
+func maskLogin(login string) string {
+	r := []rune(login)
+	if len(r) <= 2 {
+		return string(r[:1]) + "***"
+	}
+	return string(r[0]) + "***" + string(r[len(r)-1])
+}
@@ -0,0 +1,360 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+var _ = Describe("Accrual", func() {
+	var (
+		acc     *internal.AccrualService
+		rep     *mock_internal.MockIRepository
+		handler http.HandlerFunc
+		server  *httptest.Server
+		logger  *zap.SugaredLogger
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		z, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+		logger = z.Sugar()
+
+		rep = mock_internal.NewMockIRepository(ctrl)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+
+		acc = internal.NewAccrualService(rep, server.URL, 1, 0, context.Background(), logger)
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	Context("Accrual tests", func() {
+		It("ProcessAccrual processed", func() {
+			ctx := context.Background()
+			uid := 1
+			orderNumber := "79927398713"
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).Should(Equal("/api/orders/" + orderNumber))
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"order":"79927398713","status":"PROCESSED","accrual":500}`))
+			}
+
+			rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{Tier: model.TierBronze}, nil).Times(2)
+			rep.EXPECT().MakeAccrual(ctx, uid, model.OrderStatusProcessed, orderNumber, decimal.NewFromInt(500), gomock.Any()).DoAndReturn(func(_ context.Context, _ int, _, _ string, _, tierBonus decimal.Decimal) error {
+				Expect(tierBonus.IsZero()).Should(BeTrue(), tierBonus.String())
+				return nil
+			})
+
+			err := acc.ProcessAccrual(ctx, uid, orderNumber)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("ProcessAccrual succeeds when the tier can't be evaluated after the credit", func() {
+			ctx := context.Background()
+			uid := 1
+			orderNumber := "79927398713"
+
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"order":"79927398713","status":"PROCESSED","accrual":500}`))
+			}
+
+			gomock.InOrder(
+				rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{Tier: model.TierBronze}, nil),
+				rep.EXPECT().MakeAccrual(ctx, uid, model.OrderStatusProcessed, orderNumber, decimal.NewFromInt(500), gomock.Any()).Return(nil),
+				rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{}, errors.New("some error")),
+			)
+
+			err := acc.ProcessAccrual(ctx, uid, orderNumber)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("ProcessAccrual credits the tier bonus apart and lifts the tier by the accrual only", func() {
+			ctx := context.Background()
+			uid := 1
+			orderNumber := "79927398713"
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"order":"79927398713","status":"PROCESSED","accrual":4000}`))
+			}
+
+			oldest := time.Now().AddDate(0, -1, 0)
+			reviewAt := oldest.AddDate(0, internal.TierWindow, 0)
+
+			gomock.InOrder(
+				rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{
+					Tier: model.TierSilver, ReviewAt: &reviewAt, Points: decimal.NewFromInt(1500), OldestAccrualAt: &oldest,
+				}, nil),
+				// silver multiplier is 1.1
+				rep.EXPECT().MakeAccrual(ctx, uid, model.OrderStatusProcessed, orderNumber, gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ int, _, _ string, accrual, tierBonus decimal.Decimal) error {
+					Expect(accrual.Equal(decimal.NewFromInt(4000))).Should(BeTrue(), accrual.String())
+					Expect(tierBonus.Equal(decimal.NewFromInt(400))).Should(BeTrue(), tierBonus.String())
+					return nil
+				}),
+				// the points of the tier grow by the accrual, not by the bonus
+				rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{
+					Tier: model.TierSilver, ReviewAt: &reviewAt, Points: decimal.NewFromInt(5500), OldestAccrualAt: &oldest,
+				}, nil),
+				rep.EXPECT().SetUserTier(ctx, uid, model.TierChange{
+					From: model.TierSilver, To: model.TierGold, Points: decimal.NewFromInt(5500), ReviewAt: &reviewAt,
+				}).Return(nil),
+			)
+
+			err := acc.ProcessAccrual(ctx, uid, orderNumber)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("ProcessAccrual processing", func() {
+			ctx := context.Background()
+			uid := 1
+			orderNumber := "79927398713"
+
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"order":"79927398713","status":"PROCESSING"}`))
+			}
+
+			rep.EXPECT().UpdateOrderStatus(ctx, orderNumber, model.OrderStatusProcessing).Return(nil)
+
+			err := acc.ProcessAccrual(ctx, uid, orderNumber)
+			Expect(errors.Is(err, internal.ErrAccrualIsNotFinal)).Should(BeTrue())
+		})
+		It("ProcessAccrual unexpected status", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"order":"79927398713","status":"UNKNOWN","accrual":500}`))
+			}
+
+			err := acc.ProcessAccrual(context.Background(), 1, "79927398713")
+			Expect(err).Should(HaveOccurred())
+		})
+		It("ProcessAccrual too many requests", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte("No more than 120 requests per minute allowed"))
+			}
+
+			err := acc.ProcessAccrual(context.Background(), 1, "79927398713")
+			Expect(errors.Is(err, internal.ErrTooManyRequests)).Should(BeTrue())
+
+			var rle *internal.RateLimitError
+			Expect(errors.As(err, &rle)).Should(BeTrue())
+			Expect(rle.RetryAfter).Should(Equal(60 * time.Second))
+			Expect(rle.Limit).Should(Equal(120))
+		})
+		It("ProcessAccrual too many requests with http date", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", time.Now().Add(30*time.Second).UTC().Format(http.TimeFormat))
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+
+			err := acc.ProcessAccrual(context.Background(), 1, "79927398713")
+
+			var rle *internal.RateLimitError
+			Expect(errors.As(err, &rle)).Should(BeTrue())
+			Expect(rle.RetryAfter).Should(BeNumerically("~", 30*time.Second, 2*time.Second))
+			Expect(rle.Limit).Should(Equal(0))
+		})
+		It("ProcessAccrual not registered", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}
+
+			err := acc.ProcessAccrual(context.Background(), 1, "79927398713")
+			Expect(errors.Is(err, internal.ErrOrderIsNotRegistered)).Should(BeTrue())
+			Expect(errors.Is(err, internal.ErrTooManyRequests)).Should(BeFalse())
+		})
+		It("ProcessAccrual internal error", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+
+			err := acc.ProcessAccrual(context.Background(), 1, "79927398713")
+			Expect(errors.Is(err, internal.ErrAccrualIsUnavailable)).Should(BeTrue())
+		})
+		It("Ping succeeds on any answer of accrual system", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).Should(Equal(http.MethodHead))
+				w.WriteHeader(http.StatusNotFound)
+			}
+
+			Expect(acc.Ping(context.Background())).Should(Succeed())
+		})
+		It("Ping fails when accrual system is unreachable", func() {
+			server.Close()
+
+			Expect(acc.Ping(context.Background())).ShouldNot(Succeed())
+		})
+		It("Backoff delays grow exponentially up to max", func() {
+			b := internal.Backoff{Base: time.Second, Max: 10 * time.Second}
+
+			Expect(b.Delay(1)).Should(BeNumerically(">=", 500*time.Millisecond))
+			Expect(b.Delay(1)).Should(BeNumerically("<=", time.Second))
+			Expect(b.Delay(3)).Should(BeNumerically(">=", 2*time.Second))
+			Expect(b.Delay(3)).Should(BeNumerically("<=", 4*time.Second))
+			Expect(b.Delay(100)).Should(BeNumerically(">=", 5*time.Second))
+			Expect(b.Delay(100)).Should(BeNumerically("<=", 10*time.Second))
+		})
+		It("Run doesn't process the same order twice at a time", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			acc = internal.NewAccrualService(rep, server.URL, 2, 0, ctx, logger)
+
+			var requests, polls int32
+			unblock := make(chan struct{})
+			completed := make(chan struct{})
+
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				<-unblock
+				_, _ = w.Write([]byte(`{"order":"79927398713","status":"INVALID"}`))
+			}
+
+			// the lease of the first job has expired and the same order is leased again
+			jobs := []model.AccrualJob{
+				{ID: 1, OrderNumber: "79927398713", UserID: 1, Attempts: 1},
+				{ID: 1, OrderNumber: "79927398713", UserID: 1, Attempts: 2},
+			}
+
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 2, gomock.Any()).Return(jobs, nil)
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 2, gomock.Any()).DoAndReturn(func(context.Context, int, time.Duration) ([]model.AccrualJob, error) {
+				atomic.AddInt32(&polls, 1)
+				return nil, nil
+			}).AnyTimes()
+			rep.EXPECT().MakeAccrual(gomock.Any(), 1, model.OrderStatusInvalid, "79927398713", gomock.Any(), gomock.Any()).Return(nil)
+			rep.EXPECT().CompleteAccrualJob(gomock.Any(), 1).Do(func(context.Context, int) {
+				close(completed)
+			}).Return(nil)
+
+			done := make(chan struct{})
+			go func() {
+				acc.Run()
+				close(done)
+			}()
+
+			Eventually(func() int32 { return atomic.LoadInt32(&polls) }).Should(BeNumerically(">=", 1))
+			close(unblock)
+			Eventually(completed).Should(BeClosed())
+
+			cancel()
+			Eventually(done).Should(BeClosed())
+			Expect(atomic.LoadInt32(&requests)).Should(Equal(int32(1)))
+		})
+		It("Run retries an order which isn't registered yet after a fixed delay", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}
+
+			// attempts grow with every lease, the job has failed before
+			job := model.AccrualJob{ID: 1, OrderNumber: "79927398713", UserID: 1, Attempts: 9, Failures: 3}
+			rescheduled := make(chan struct{})
+
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return([]model.AccrualJob{job}, nil)
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return(nil, nil).AnyTimes()
+			rep.EXPECT().RescheduleAccrualJob(gomock.Any(), 1, 10*time.Second, 0, internal.ErrOrderIsNotRegistered.Error()).Do(func(context.Context, int, time.Duration, int, string) {
+				close(rescheduled)
+			}).Return(nil)
+
+			go acc.Run()
+			Eventually(rescheduled).Should(BeClosed())
+			Expect(acc.Stop(context.Background())).Should(Succeed())
+		})
+		It("Run backs off by failures in a row, not by attempts", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+
+			job := model.AccrualJob{ID: 1, OrderNumber: "79927398713", UserID: 1, Attempts: 50}
+			rescheduled := make(chan time.Duration, 1)
+
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return([]model.AccrualJob{job}, nil)
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return(nil, nil).AnyTimes()
+			rep.EXPECT().RescheduleAccrualJob(gomock.Any(), 1, gomock.Any(), 1, gomock.Any()).Do(func(_ context.Context, _ int, delay time.Duration, _ int, _ string) {
+				rescheduled <- delay
+			}).Return(nil)
+
+			go acc.Run()
+			// the first failure waits the base delay of the backoff
+			Eventually(rescheduled).Should(Receive(BeNumerically("<=", time.Second)))
+			Expect(acc.Stop(context.Background())).Should(Succeed())
+		})
+		It("Stop waits for the current order and leases no more jobs", func() {
+			acc = internal.NewAccrualService(rep, server.URL, 1, 0, context.Background(), logger)
+
+			started := make(chan struct{})
+			unblock := make(chan struct{})
+
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-unblock
+				_, _ = w.Write([]byte(`{"order":"79927398713","status":"INVALID"}`))
+			}
+
+			job := model.AccrualJob{ID: 1, OrderNumber: "79927398713", UserID: 1, Attempts: 1}
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return([]model.AccrualJob{job}, nil)
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return(nil, nil).AnyTimes()
+			rep.EXPECT().MakeAccrual(gomock.Any(), 1, model.OrderStatusInvalid, "79927398713", gomock.Any(), gomock.Any()).Return(nil)
+			rep.EXPECT().CompleteAccrualJob(gomock.Any(), 1).Return(nil)
+
+			go acc.Run()
+			Eventually(started).Should(BeClosed())
+
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- acc.Stop(context.Background())
+			}()
+			Consistently(stopped, 100*time.Millisecond).ShouldNot(Receive())
+
+			close(unblock)
+			Eventually(stopped).Should(Receive(BeNil()))
+		})
+		It("Stop gives up when its context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			acc = internal.NewAccrualService(rep, server.URL, 1, 0, ctx, logger)
+
+			started := make(chan struct{})
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-r.Context().Done()
+			}
+
+			job := model.AccrualJob{ID: 1, OrderNumber: "79927398713", UserID: 1, Attempts: 1}
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return([]model.AccrualJob{job}, nil)
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return(nil, nil).AnyTimes()
+
+			done := make(chan struct{})
+			go func() {
+				acc.Run()
+				close(done)
+			}()
+			Eventually(started).Should(BeClosed())
+
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer stopCancel()
+			Expect(acc.Stop(stopCtx)).Should(MatchError(context.DeadlineExceeded))
+
+			// in-flight request is aborted by the context of the service
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+})
@@ -0,0 +1,172 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+)
+
+var _ = Describe("Auth", func() {
+	const secret = "secret"
+
+	var (
+		srv   *mock_internal.MockIService
+		app   *fiber.App
+		token string
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		logger, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		srv = mock_internal.NewMockIService(ctrl)
+		h := internal.NewHandlers(srv, secret, "operator", logger.Sugar())
+
+		app = fiber.New(fiber.Config{ErrorHandler: internal.NewErrorHandler(logger.Sugar())})
+		app.Get("/", h.Authorize, func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusOK)
+		})
+
+		token, err = internal.NewService(nil, nil, internal.LoyaltyRules{}, secret, logger.Sugar()).GetJWTToken("1", "sid")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	request := func(header, cookie string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: cookie})
+		}
+
+		res, err := app.Test(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res.StatusCode
+	}
+
+	sign := func(method jwt.SigningMethod, claims internal.Claims) string {
+		t, err := jwt.NewWithClaims(method, claims).SignedString([]byte(secret))
+		Expect(err).ShouldNot(HaveOccurred())
+		return t
+	}
+
+	validClaims := func() internal.Claims {
+		claims, err := internal.ParseAccessToken(token, secret)
+		Expect(err).ShouldNot(HaveOccurred())
+		return claims
+	}
+
+	Context("Auth middleware tests", func() {
+		It("Authorize with bearer token", func() {
+			srv.EXPECT().IsSessionActive(gomock.Any(), "sid").Return(true, nil)
+
+			Expect(request("Bearer "+token, "")).Should(Equal(fiber.StatusOK))
+		})
+		It("Authorize with cookie", func() {
+			srv.EXPECT().IsSessionActive(gomock.Any(), "sid").Return(true, nil)
+
+			Expect(request("", token)).Should(Equal(fiber.StatusOK))
+		})
+		It("Authorize with error no token", func() {
+			Expect(request("", "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error revoked session", func() {
+			srv.EXPECT().IsSessionActive(gomock.Any(), "sid").Return(false, nil)
+
+			Expect(request("Bearer "+token, "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error session check failed", func() {
+			srv.EXPECT().IsSessionActive(gomock.Any(), "sid").Return(false, errors.New("some error"))
+
+			Expect(request("Bearer "+token, "")).Should(Equal(fiber.StatusInternalServerError))
+		})
+		It("Authorize with error wrong signing method", func() {
+			Expect(request("Bearer "+sign(jwt.SigningMethodHS384, validClaims()), "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error unsigned token", func() {
+			t, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(request("Bearer "+t, "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error expired token", func() {
+			claims := validClaims()
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+			Expect(request("Bearer "+sign(jwt.SigningMethodHS256, claims), "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error token without expiration", func() {
+			claims := validClaims()
+			claims.ExpiresAt = nil
+
+			Expect(request("Bearer "+sign(jwt.SigningMethodHS256, claims), "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error token not valid yet", func() {
+			claims := validClaims()
+			claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute))
+
+			Expect(request("Bearer "+sign(jwt.SigningMethodHS256, claims), "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error wrong issuer", func() {
+			claims := validClaims()
+			claims.Issuer = "other"
+
+			Expect(request("Bearer "+sign(jwt.SigningMethodHS256, claims), "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error wrong audience", func() {
+			claims := validClaims()
+			claims.Audience = jwt.ClaimStrings{"other"}
+
+			Expect(request("Bearer "+sign(jwt.SigningMethodHS256, claims), "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error token without session", func() {
+			claims := validClaims()
+			claims.SessionID = ""
+
+			Expect(request("Bearer "+sign(jwt.SigningMethodHS256, claims), "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("Authorize with error wrong secret", func() {
+			t, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("other"))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(request("Bearer "+t, "")).Should(Equal(fiber.StatusUnauthorized))
+		})
+		It("AuthorizeOperator accepts only the operator token", func() {
+			app.Get("/operator", internal.NewHandlers(srv, secret, "operator", zap.NewNop().Sugar()).AuthorizeOperator, func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+			app.Get("/disabled", internal.NewHandlers(srv, secret, "", zap.NewNop().Sugar()).AuthorizeOperator, func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			operator := func(path, header string) int {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.Header.Set("Authorization", header)
+
+				res, err := app.Test(req)
+				Expect(err).ShouldNot(HaveOccurred())
+				return res.StatusCode
+			}
+
+			Expect(operator("/operator", "Bearer operator")).Should(Equal(fiber.StatusOK))
+			Expect(operator("/operator", "Bearer other")).Should(Equal(fiber.StatusUnauthorized))
+			Expect(operator("/operator", "Bearer "+token)).Should(Equal(fiber.StatusUnauthorized))
+			Expect(operator("/disabled", "Bearer ")).Should(Equal(fiber.StatusUnauthorized))
+		})
+	})
+})
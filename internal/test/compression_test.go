@@ -0,0 +1,190 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+func encode(encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		Fail("unknown encoding " + encoding)
+	}
+
+	_, err := w.Write(data)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(w.Close()).Should(Succeed())
+	return buf.Bytes()
+}
+
+func decode(encoding string, data []byte) []byte {
+	var r io.Reader
+	var err error
+
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		Fail("unknown encoding " + encoding)
+	}
+	Expect(err).ShouldNot(HaveOccurred())
+
+	res, err := io.ReadAll(r)
+	Expect(err).ShouldNot(HaveOccurred())
+	return res
+}
+
+var _ = Describe("Compression", func() {
+	const maxSize = 64 * 1024
+
+	var (
+		srv   *mock_internal.MockIService
+		app   *fiber.App
+		token string
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		logger, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		srv = mock_internal.NewMockIService(ctrl)
+		srv.EXPECT().IsSessionActive(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+
+		app = fiber.New(fiber.Config{ErrorHandler: internal.NewErrorHandler(logger.Sugar())})
+		app.Use(internal.Compress(internal.DefaultCompressMinSize))
+		app.Use(internal.Decompress(maxSize))
+		app.Get("/small", func(c *fiber.Ctx) error {
+			return c.SendString("small")
+		})
+		app.Get("/large", func(c *fiber.Ctx) error {
+			return c.SendString(strings.Repeat("large", internal.DefaultCompressMinSize))
+		})
+		internal.RegisterRoutes(app, internal.NewHandlers(srv, "secret", "", logger.Sugar()))
+
+		token, err = internal.NewService(nil, nil, internal.LoyaltyRules{}, "secret", logger.Sugar()).GetJWTToken("1", "sid")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	send := func(path, contentType, encoding string, body []byte) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Encoding", encoding)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		res, err := app.Test(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		encoding := encoding
+
+		Context(encoding, func() {
+			It("Response is compressed", func() {
+				req := httptest.NewRequest(http.MethodGet, "/large", nil)
+				req.Header.Set("Accept-Encoding", encoding)
+
+				res, err := app.Test(req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res.Header.Get("Content-Encoding")).Should(Equal(encoding))
+
+				b, err := io.ReadAll(res.Body)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(string(decode(encoding, b))).Should(Equal(strings.Repeat("large", internal.DefaultCompressMinSize)))
+			})
+			It("Small response is not compressed", func() {
+				req := httptest.NewRequest(http.MethodGet, "/small", nil)
+				req.Header.Set("Accept-Encoding", encoding)
+
+				res, err := app.Test(req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res.Header.Get("Content-Encoding")).Should(BeEmpty())
+
+				b, err := io.ReadAll(res.Body)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(string(b)).Should(Equal("small"))
+			})
+			It("Login request is decompressed", func() {
+				srv.EXPECT().Login(gomock.Any(), "login", "pass").Return(model.Tokens{}, nil)
+
+				res := send("/api/user/login", "application/json", encoding, encode(encoding, []byte(`{"login":"login","password":"pass"}`)))
+				Expect(res.StatusCode).Should(Equal(fiber.StatusOK))
+			})
+			It("Register request is decompressed", func() {
+				srv.EXPECT().Register(gomock.Any(), "login", "pass", "").Return(model.Tokens{}, nil)
+
+				res := send("/api/user/register", "application/json", encoding, encode(encoding, []byte(`{"login":"login","password":"pass"}`)))
+				Expect(res.StatusCode).Should(Equal(fiber.StatusOK))
+			})
+			It("CreateOrder request is decompressed", func() {
+				srv.EXPECT().SendOrder(gomock.Any(), "12345678903", 1).Return(nil)
+
+				res := send("/api/user/orders", "text/plain", encoding, encode(encoding, []byte("12345678903")))
+				Expect(res.StatusCode).Should(Equal(fiber.StatusAccepted))
+			})
+			It("Withdraw request is decompressed", func() {
+				i := model.WithdrawInput{OrderNumber: "2377225624", Sum: decimal.NewFromInt(751)}
+				srv.EXPECT().Withdraw(gomock.Any(), i, 1).Return(nil)
+
+				res := send("/api/user/balance/withdraw", "application/json", encoding, encode(encoding, []byte(`{"order":"2377225624","sum":751}`)))
+				Expect(res.StatusCode).Should(Equal(fiber.StatusOK))
+			})
+			It("Request larger than limit after decompression is rejected", func() {
+				body := encode(encoding, bytes.Repeat([]byte(" "), maxSize+1))
+				Expect(len(body)).Should(BeNumerically("<", maxSize))
+
+				res := send("/api/user/login", "application/json", encoding, body)
+				Expect(res.StatusCode).Should(Equal(fiber.StatusRequestEntityTooLarge))
+			})
+		})
+	}
+
+	Context("Errors", func() {
+		It("Response is not compressed without Accept-Encoding", func() {
+			res, err := app.Test(httptest.NewRequest(http.MethodGet, "/large", nil))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Header.Get("Content-Encoding")).Should(BeEmpty())
+		})
+		It("Unknown encoding is rejected", func() {
+			res := send("/api/user/login", "application/json", "compress", []byte("data"))
+			Expect(res.StatusCode).Should(Equal(fiber.StatusUnsupportedMediaType))
+		})
+		It("Corrupted body is rejected", func() {
+			res := send("/api/user/login", "application/json", "gzip", []byte("not gzip"))
+			Expect(res.StatusCode).Should(Equal(fiber.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,457 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/golang/mock/gomock"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+// Contract checks every endpoint of SPECIFICATION.md on both API prefixes,
+// every exchange is also validated against the OpenAPI document.
+var _ = Describe("Contract", func() {
+	var (
+		srv    *mock_internal.MockIService
+		app    *fiber.App
+		token  string
+		router routers.Router
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		//decimals at json as numbers, as in main
+		decimal.MarshalJSONWithoutQuotes = true
+
+		logger, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		srv = mock_internal.NewMockIService(ctrl)
+		srv.EXPECT().IsSessionActive(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+
+		app = fiber.New(fiber.Config{ErrorHandler: internal.NewErrorHandler(logger.Sugar())})
+		app.Use(requestid.New())
+		internal.RegisterRoutes(app, internal.NewHandlers(srv, "secret", "operator", logger.Sugar()))
+
+		token, err = internal.NewService(nil, nil, internal.LoyaltyRules{}, "secret", logger.Sugar()).GetJWTToken("1", "sid")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, router = loadOpenAPI()
+	})
+
+	type response struct {
+		*http.Response
+		body []byte
+	}
+
+	send := func(req *http.Request, body string) response {
+		res, err := app.Test(req)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		b, err := io.ReadAll(res.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		if res.StatusCode != http.StatusNotFound {
+			Expect(validateOpenAPI(router, req, body, res, b)).Should(Succeed())
+		}
+		return response{Response: res, body: b}
+	}
+
+	do := func(method, path, contentType, body string, auth bool) response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if auth {
+			req.AddCookie(&http.Cookie{Name: "token", Value: token})
+		}
+		return send(req, body)
+	}
+
+	operator := func(path, body, operatorToken string) response {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+operatorToken)
+		return send(req, body)
+	}
+
+	tokens := model.Tokens{AccessToken: "access", RefreshToken: "refresh"}
+	uploadedAt := time.Date(2020, 12, 10, 15, 15, 45, 123, time.FixedZone("", 3*60*60))
+	accrual := decimal.NewFromInt(500)
+
+	for _, prefix := range []string{"/api", "/api/v1"} {
+		prefix := prefix
+
+		Context(prefix, func() {
+			It("POST /user/register", func() {
+				srv.EXPECT().Register(gomock.Any(), "login", "pass", "").Return(tokens, nil)
+				res := do(http.MethodPost, prefix+"/user/register", "application/json", `{"login":"login","password":"pass"}`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.Header.Values("Set-Cookie")).Should(ContainElement(HavePrefix("token=access")))
+
+				srv.EXPECT().Register(gomock.Any(), "login", "pass", "").Return(model.Tokens{}, internal.ErrLoginIsAlreadyTaken)
+				res = do(http.MethodPost, prefix+"/user/register", "application/json", `{"login":"login","password":"pass"}`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+				srv.EXPECT().Register(gomock.Any(), "login", "pass", "UNKNOWN").Return(model.Tokens{}, internal.ErrInvalidReferralCode)
+				res = do(http.MethodPost, prefix+"/user/register", "application/json", `{"login":"login","password":"pass","referral_code":"UNKNOWN"}`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+				var e model.ErrorResponse
+				Expect(json.Unmarshal(res.body, &e)).Should(Succeed())
+				Expect(e.Error.Code).Should(Equal("INVALID_REFERRAL_CODE"))
+
+				res = do(http.MethodPost, prefix+"/user/register", "application/json", `{"login":`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+			It("POST /user/login", func() {
+				srv.EXPECT().Login(gomock.Any(), "login", "pass").Return(tokens, nil)
+				res := do(http.MethodPost, prefix+"/user/login", "application/json", `{"login":"login","password":"pass"}`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.Header.Values("Set-Cookie")).Should(ContainElement(HavePrefix("token=access")))
+
+				srv.EXPECT().Login(gomock.Any(), "login", "pass").Return(model.Tokens{}, internal.ErrInvalidCredentials)
+				res = do(http.MethodPost, prefix+"/user/login", "application/json", `{"login":"login","password":"pass"}`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusUnauthorized))
+
+				res = do(http.MethodPost, prefix+"/user/login", "application/json", `{"login":`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+			})
+			It("POST /user/logout", func() {
+				path := prefix + "/user/logout"
+
+				srv.EXPECT().Logout(gomock.Any(), "sid").Return(nil)
+				res := do(http.MethodPost, path, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.Header.Values("Set-Cookie")).Should(ContainElement(HavePrefix("token=;")))
+
+				srv.EXPECT().LogoutByRefreshToken(gomock.Any(), "refresh").Return(nil)
+				res = do(http.MethodPost, path, "application/json", `{"refresh_token":"refresh"}`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+				// the access token has expired, the session is revoked by the refresh token from the cookie
+				req := httptest.NewRequest(http.MethodPost, path, nil)
+				req.AddCookie(&http.Cookie{Name: "token", Value: "expired"})
+				req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh"})
+				srv.EXPECT().LogoutByRefreshToken(gomock.Any(), "refresh").Return(nil)
+				Expect(send(req, "").StatusCode).Should(Equal(http.StatusOK))
+
+				srv.EXPECT().LogoutByRefreshToken(gomock.Any(), "unknown").Return(internal.ErrInvalidToken)
+				res = do(http.MethodPost, path, "application/json", `{"refresh_token":"unknown"}`, false)
+				Expect(res.StatusCode).Should(Equal(http.StatusUnauthorized))
+
+				Expect(do(http.MethodPost, path, "", "", false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("POST /user/orders", func() {
+				number := "12345678903"
+				path := prefix + "/user/orders"
+
+				srv.EXPECT().SendOrder(gomock.Any(), number, 1).Return(nil)
+				Expect(do(http.MethodPost, path, "text/plain", number, true).StatusCode).Should(Equal(http.StatusAccepted))
+
+				srv.EXPECT().SendOrder(gomock.Any(), number, 1).Return(internal.ErrOrderIsAlreadySent)
+				Expect(do(http.MethodPost, path, "text/plain", number, true).StatusCode).Should(Equal(http.StatusOK))
+
+				srv.EXPECT().SendOrder(gomock.Any(), number, 1).Return(internal.ErrOrderIsAlreadySentByOtherUser)
+				Expect(do(http.MethodPost, path, "text/plain", number, true).StatusCode).Should(Equal(http.StatusConflict))
+
+				srv.EXPECT().SendOrder(gomock.Any(), "1", 1).Return(internal.ErrLuhnInvalid)
+				Expect(do(http.MethodPost, path, "text/plain", "1", true).StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+				Expect(do(http.MethodPost, path, "application/json", number, true).StatusCode).Should(Equal(http.StatusBadRequest))
+				Expect(do(http.MethodPost, path, "text/plain", number, false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("GET /user/orders", func() {
+				path := prefix + "/user/orders"
+				orders := []model.OrderOutput{
+					{Number: "9278923470", Status: model.OrderStatusProcessed, Accrual: &accrual, UploadedAt: model.RFC3339Time{Time: uploadedAt}},
+					{Number: "12345678903", Status: model.OrderStatusProcessing, UploadedAt: model.RFC3339Time{Time: uploadedAt}},
+				}
+
+				srv.EXPECT().GetOrders(gomock.Any(), 1, model.PageQuery{}).Return(model.OrdersPage{Orders: orders}, nil)
+				res := do(http.MethodGet, path, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.Header.Get("Content-Type")).Should(HavePrefix("application/json"))
+				Expect(res.body).Should(MatchJSON(`[
+					{"number":"9278923470","status":"PROCESSED","accrual":500,"uploaded_at":"2020-12-10T15:15:45+03:00"},
+					{"number":"12345678903","status":"PROCESSING","uploaded_at":"2020-12-10T15:15:45+03:00"}
+				]`))
+
+				srv.EXPECT().GetOrders(gomock.Any(), 1, model.PageQuery{}).Return(model.OrdersPage{}, internal.ErrNoRecords)
+				Expect(do(http.MethodGet, path, "", "", true).StatusCode).Should(Equal(http.StatusNoContent))
+
+				Expect(do(http.MethodGet, path, "", "", false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("GET /user/tier", func() {
+				path := prefix + "/user/tier"
+				next := decimal.NewFromInt(5000)
+				left := decimal.NewFromInt(3800)
+
+				srv.EXPECT().GetTier(gomock.Any(), 1).Return(model.TierOutput{
+					Tier:             model.TierSilver,
+					Multiplier:       decimal.RequireFromString("1.1"),
+					Points:           decimal.NewFromInt(1200),
+					NextTier:         model.TierGold,
+					NextTierPoints:   &next,
+					PointsToNextTier: &left,
+					ReevaluatedAt:    &model.RFC3339Time{Time: uploadedAt},
+				}, nil)
+				res := do(http.MethodGet, path, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.body).Should(MatchJSON(`{"tier":"SILVER","multiplier":1.1,"points":1200,"next_tier":"GOLD","next_tier_points":5000,"points_to_next_tier":3800,"reevaluated_at":"2020-12-10T15:15:45+03:00"}`))
+
+				srv.EXPECT().GetTier(gomock.Any(), 1).Return(model.TierOutput{
+					Tier:       model.TierBronze,
+					Multiplier: decimal.NewFromInt(1),
+					Points:     decimal.Zero,
+				}, nil)
+				res = do(http.MethodGet, path, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.body).Should(MatchJSON(`{"tier":"BRONZE","multiplier":1,"points":0}`))
+
+				srv.EXPECT().GetTier(gomock.Any(), 1).Return(model.TierOutput{}, internal.ErrUserNotFound)
+				Expect(do(http.MethodGet, path, "", "", true).StatusCode).Should(Equal(http.StatusNotFound))
+
+				Expect(do(http.MethodGet, path, "", "", false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("GET /user/referrals", func() {
+				path := prefix + "/user/referrals"
+
+				srv.EXPECT().GetReferrals(gomock.Any(), 1, gomock.Any()).Return(model.ReferralsPage{
+					Code: "ABCDEFGH",
+					Referrals: []model.ReferralOutput{
+						{ID: 1, Login: "f***d", RegisteredAt: model.RFC3339Time{Time: uploadedAt}, Status: model.ReferralStatusRewarded, Bonus: decimal.NewFromInt(100)},
+					},
+				}, nil)
+				res := do(http.MethodGet, path, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.body).Should(MatchJSON(`{"code":"ABCDEFGH","referrals":[{"login":"f***d","registered_at":"2020-12-10T15:15:45+03:00","status":"REWARDED","bonus":100}]}`))
+
+				srv.EXPECT().GetReferrals(gomock.Any(), 1, gomock.Any()).Return(model.ReferralsPage{Code: "ABCDEFGH"}, nil)
+				res = do(http.MethodGet, path, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.body).Should(MatchJSON(`{"code":"ABCDEFGH","referrals":[]}`))
+
+				Expect(do(http.MethodGet, path, "", "", false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("GET /user/balance", func() {
+				path := prefix + "/user/balance"
+
+				srv.EXPECT().GetBalanceByUserID(gomock.Any(), 1).Return(model.BalanceWithdrawn{
+					Balance:      decimal.NewFromFloat(500.5),
+					Withdrawn:    decimal.NewFromInt(42),
+					ExpiringSoon: decimal.NewFromInt(20),
+				}, nil)
+				res := do(http.MethodGet, path, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.body).Should(MatchJSON(`{"current":500.5,"withdrawn":42,"expiring_soon":20}`))
+
+				Expect(do(http.MethodGet, path, "", "", false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("POST /user/balance/withdraw", func() {
+				path := prefix + "/user/balance/withdraw"
+				body := `{"order":"2377225624","sum":751}`
+				i := model.WithdrawInput{OrderNumber: "2377225624", Sum: decimal.NewFromInt(751)}
+
+				srv.EXPECT().Withdraw(gomock.Any(), i, 1).Return(nil)
+				Expect(do(http.MethodPost, path, "application/json", body, true).StatusCode).Should(Equal(http.StatusOK))
+
+				srv.EXPECT().Withdraw(gomock.Any(), i, 1).Return(internal.ErrInsufficientFunds)
+				Expect(do(http.MethodPost, path, "application/json", body, true).StatusCode).Should(Equal(http.StatusPaymentRequired))
+
+				srv.EXPECT().Withdraw(gomock.Any(), i, 1).Return(internal.ErrOrderIsAlreadyWithdrawn)
+				Expect(do(http.MethodPost, path, "application/json", body, true).StatusCode).Should(Equal(http.StatusConflict))
+
+				srv.EXPECT().Withdraw(gomock.Any(), i, 1).Return(internal.ErrLuhnInvalid)
+				Expect(do(http.MethodPost, path, "application/json", body, true).StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+				Expect(do(http.MethodPost, path, "application/json", body, false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("POST /user/balance/transfer", func() {
+				path := prefix + "/user/balance/transfer"
+				body := `{"login":"family","sum":10}`
+				i := model.TransferInput{Login: "family", Sum: decimal.NewFromInt(10)}
+
+				srv.EXPECT().Transfer(gomock.Any(), i, 1).Return(nil)
+				Expect(do(http.MethodPost, path, "application/json", body, true).StatusCode).Should(Equal(http.StatusOK))
+
+				srv.EXPECT().Transfer(gomock.Any(), i, 1).Return(internal.ErrInsufficientFunds)
+				Expect(do(http.MethodPost, path, "application/json", body, true).StatusCode).Should(Equal(http.StatusPaymentRequired))
+
+				srv.EXPECT().Transfer(gomock.Any(), i, 1).Return(internal.ErrRecipientNotFound)
+				Expect(do(http.MethodPost, path, "application/json", body, true).StatusCode).Should(Equal(http.StatusNotFound))
+
+				srv.EXPECT().Transfer(gomock.Any(), i, 1).Return(internal.ErrTransferLimitExceeded)
+				Expect(do(http.MethodPost, path, "application/json", body, true).StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+				Expect(do(http.MethodPost, path, "application/json", `{"login":"family","sum":0}`, true).StatusCode).Should(Equal(http.StatusBadRequest))
+				Expect(do(http.MethodPost, path, "application/json", body, false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("GET /user/balance/transfers", func() {
+				path := prefix + "/user/balance/transfers"
+				transfers := []model.TransferOutput{
+					{Direction: model.TransferDirectionOut, Login: "family", Sum: decimal.NewFromInt(10), ProcessedAt: model.RFC3339Time{Time: uploadedAt}},
+				}
+
+				srv.EXPECT().GetTransferHistory(gomock.Any(), 1, model.PageQuery{}).Return(model.TransfersPage{Transfers: transfers}, nil)
+				res := do(http.MethodGet, path, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+				Expect(res.body).Should(MatchJSON(`[{"direction":"OUT","login":"family","sum":10,"processed_at":"2020-12-10T15:15:45+03:00"}]`))
+
+				srv.EXPECT().GetTransferHistory(gomock.Any(), 1, model.PageQuery{}).Return(model.TransfersPage{}, internal.ErrNoRecords)
+				Expect(do(http.MethodGet, path, "", "", true).StatusCode).Should(Equal(http.StatusNoContent))
+
+				Expect(do(http.MethodGet, path, "", "", false).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			It("POST /operator/withdrawals/{number}/reversals", func() {
+				path := prefix + "/operator/withdrawals/2377225624/reversals"
+				sum := decimal.NewFromInt(100)
+				out := model.ReversalOutput{
+					ID:          1,
+					OrderNumber: "2377225624",
+					UserID:      1,
+					Sum:         sum,
+					Withdrawn:   decimal.NewFromInt(751),
+					Reversed:    sum,
+					Reason:      "cancelled",
+					CreatedAt:   model.RFC3339Time{Time: uploadedAt},
+				}
+
+				srv.EXPECT().ReverseWithdrawal(gomock.Any(), model.ReversalInput{OrderNumber: "2377225624", Sum: &sum, Reason: "cancelled"}).Return(out, nil)
+				res := operator(path, `{"sum":100,"reason":"cancelled"}`, "operator")
+				Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+				Expect(res.body).Should(MatchJSON(`{"id":1,"order":"2377225624","sum":100,"withdrawn":751,"reversed":100,"reason":"cancelled","created_at":"2020-12-10T15:15:45+03:00"}`))
+
+				// without sum the rest of the withdrawal is reversed
+				srv.EXPECT().ReverseWithdrawal(gomock.Any(), model.ReversalInput{OrderNumber: "2377225624"}).Return(out, nil)
+				Expect(operator(path, "", "operator").StatusCode).Should(Equal(http.StatusCreated))
+
+				srv.EXPECT().ReverseWithdrawal(gomock.Any(), gomock.Any()).Return(model.ReversalOutput{}, internal.ErrReversalExceedsWithdrawal)
+				Expect(operator(path, `{"sum":1000}`, "operator").StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+				srv.EXPECT().ReverseWithdrawal(gomock.Any(), gomock.Any()).Return(model.ReversalOutput{}, internal.ErrWithdrawalNotFound)
+				Expect(operator(path, "", "operator").StatusCode).Should(Equal(http.StatusNotFound))
+
+				Expect(operator(path, `{"sum":0}`, "operator").StatusCode).Should(Equal(http.StatusBadRequest))
+				Expect(operator(path, "", "wrong").StatusCode).Should(Equal(http.StatusUnauthorized))
+				Expect(operator(path, "", token).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+			for _, path := range []string{"/user/withdrawals", "/user/balance/withdrawals"} {
+				path := prefix + path
+
+				It("GET "+path, func() {
+					wh := []model.WithdrawOutput{
+						{OrderNumber: "2377225624", Sum: decimal.NewFromInt(500), ProcessedAt: model.RFC3339Time{Time: uploadedAt}},
+					}
+
+					srv.EXPECT().GetWithdrawHistory(gomock.Any(), 1, model.PageQuery{}).Return(model.WithdrawalsPage{Withdrawals: wh}, nil)
+					res := do(http.MethodGet, path, "", "", true)
+					Expect(res.StatusCode).Should(Equal(http.StatusOK))
+					Expect(res.Header.Get("Deprecation")).Should(BeEmpty())
+					Expect(res.body).Should(MatchJSON(`[{"order":"2377225624","sum":500,"processed_at":"2020-12-10T15:15:45+03:00"}]`))
+
+					srv.EXPECT().GetWithdrawHistory(gomock.Any(), 1, model.PageQuery{}).Return(model.WithdrawalsPage{}, internal.ErrNoRecords)
+					Expect(do(http.MethodGet, path, "", "", true).StatusCode).Should(Equal(http.StatusNoContent))
+
+					Expect(do(http.MethodGet, path, "", "", false).StatusCode).Should(Equal(http.StatusUnauthorized))
+				})
+			}
+		})
+	}
+
+	Context("Pagination", func() {
+		next := &model.Cursor{Time: uploadedAt, ID: 42}
+		orders := []model.OrderOutput{
+			{Number: "12345678903", Status: model.OrderStatusNew, UploadedAt: model.RFC3339Time{Time: uploadedAt}},
+		}
+
+		It("GET /api/v1/user/orders passes filters and links the next page", func() {
+			q := model.PageQuery{
+				Limit:    1,
+				Statuses: []string{model.OrderStatusNew, model.OrderStatusProcessed},
+				From:     time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC),
+			}
+			srv.EXPECT().GetOrders(gomock.Any(), 1, q).Return(model.OrdersPage{Orders: orders, Next: next}, nil)
+
+			res := do(http.MethodGet, "/api/v1/user/orders?limit=1&status=NEW,PROCESSED&from=2020-12-01T00:00:00Z", "", "", true)
+			Expect(res.StatusCode).Should(Equal(http.StatusOK))
+			cursor := res.Header.Get("X-Next-Cursor")
+			Expect(cursor).ShouldNot(BeEmpty())
+			Expect(res.Header.Get("Link")).Should(Equal(`</api/v1/user/orders?limit=1&status=NEW%2CPROCESSED&from=2020-12-01T00%3A00%3A00Z&cursor=` + cursor + `>; rel="next"`))
+
+			q.After = &model.Cursor{Time: uploadedAt.UTC(), ID: 42}
+			srv.EXPECT().GetOrders(gomock.Any(), 1, q).Return(model.OrdersPage{Orders: orders}, nil)
+
+			res = do(http.MethodGet, "/api/v1/user/orders?limit=1&status=NEW,PROCESSED&from=2020-12-01T00:00:00Z&cursor="+cursor, "", "", true)
+			Expect(res.StatusCode).Should(Equal(http.StatusOK))
+			Expect(res.Header.Get("X-Next-Cursor")).Should(BeEmpty())
+			Expect(res.Header.Get("Link")).Should(BeEmpty())
+		})
+		It("GET /api/v1/user/withdrawals links the next page", func() {
+			q := model.PageQuery{Limit: 1, To: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+			wh := []model.WithdrawOutput{
+				{OrderNumber: "2377225624", Sum: decimal.NewFromInt(500), ProcessedAt: model.RFC3339Time{Time: uploadedAt}},
+			}
+			srv.EXPECT().GetWithdrawHistory(gomock.Any(), 1, q).Return(model.WithdrawalsPage{Withdrawals: wh, Next: next}, nil)
+
+			res := do(http.MethodGet, "/api/v1/user/withdrawals?limit=1&to=2021-01-01T00:00:00Z", "", "", true)
+			Expect(res.StatusCode).Should(Equal(http.StatusOK))
+			Expect(res.Header.Get("X-Next-Cursor")).ShouldNot(BeEmpty())
+			Expect(res.Header.Get("Link")).Should(HavePrefix("</api/v1/user/withdrawals?limit=1&to="))
+		})
+		for _, query := range []string{
+			"limit=0",
+			"limit=1001",
+			"limit=ten",
+			"cursor=not-a-cursor",
+			"status=UNKNOWN",
+			"from=yesterday",
+			"from=2021-01-01T00:00:00Z&to=2020-01-01T00:00:00Z",
+		} {
+			query := query
+
+			It("Invalid query "+query+" is rejected", func() {
+				res := do(http.MethodGet, "/api/v1/user/orders?"+query, "", "", true)
+				Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+				var e model.ErrorResponse
+				Expect(json.Unmarshal(res.body, &e)).Should(Succeed())
+				Expect(e.Error.Code).Should(Equal("INVALID_QUERY"))
+			})
+		}
+	})
+
+	Context("Deprecated routes", func() {
+		It("GET /api/user/balance/withdraw is a deprecated alias", func() {
+			srv.EXPECT().GetWithdrawHistory(gomock.Any(), 1, model.PageQuery{}).Return(model.WithdrawalsPage{}, internal.ErrNoRecords)
+
+			res := do(http.MethodGet, "/api/user/balance/withdraw", "", "", true)
+			Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+			Expect(res.Header.Get("Deprecation")).Should(Equal("true"))
+			Expect(res.Header.Get("Link")).Should(ContainSubstring("/api/v1/user/withdrawals"))
+		})
+		It("GET /api/v1/user/balance/withdraw doesn't exist", func() {
+			res := do(http.MethodGet, "/api/v1/user/balance/withdraw", "", "", true)
+			Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+			var e model.ErrorResponse
+			Expect(json.Unmarshal(res.body, &e)).Should(Succeed())
+			Expect(e.Error.Code).Should(Equal("NOT_FOUND"))
+		})
+	})
+})
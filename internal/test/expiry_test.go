@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+)
+
+var _ = Describe("Expiry", func() {
+	var (
+		rep    *mock_internal.MockIRepository
+		logger *zap.SugaredLogger
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		logger = zap.NewNop().Sugar()
+		rep = mock_internal.NewMockIRepository(ctrl)
+	})
+
+	Context("Expiry tests", func() {
+		It("Expire takes away lots older than ttl", func() {
+			now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+			cutoff := time.Date(2025, 10, 17, 12, 0, 0, 0, time.UTC)
+			e := internal.NewPointsExpiry(rep, 12, time.Hour, context.Background(), logger)
+
+			rep.EXPECT().GetUsersWithExpiredLots(gomock.Any(), cutoff, gomock.Any()).Return([]int{1, 2}, nil)
+			rep.EXPECT().ExpireLots(gomock.Any(), 1, cutoff).Return(decimal.NewFromInt(5), nil)
+			rep.EXPECT().ExpireLots(gomock.Any(), 2, cutoff).Return(decimal.NewFromInt(1), nil)
+
+			users, err := e.Expire(context.Background(), now)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(users).Should(Equal(2))
+		})
+		It("Expire goes on while batches are full", func() {
+			e := internal.NewPointsExpiry(rep, 1, time.Hour, context.Background(), logger)
+
+			full := make([]int, 100)
+			for i := range full {
+				full[i] = i + 1
+			}
+
+			gomock.InOrder(
+				rep.EXPECT().GetUsersWithExpiredLots(gomock.Any(), gomock.Any(), 100).Return(full, nil),
+				rep.EXPECT().GetUsersWithExpiredLots(gomock.Any(), gomock.Any(), 100).Return([]int{101}, nil),
+			)
+			rep.EXPECT().ExpireLots(gomock.Any(), gomock.Any(), gomock.Any()).Return(decimal.NewFromInt(1), nil).Times(101)
+
+			users, err := e.Expire(context.Background(), time.Now())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(users).Should(Equal(101))
+		})
+		It("Expire stops on error", func() {
+			e := internal.NewPointsExpiry(rep, 12, time.Hour, context.Background(), logger)
+
+			rep.EXPECT().GetUsersWithExpiredLots(gomock.Any(), gomock.Any(), gomock.Any()).Return([]int{1, 2}, nil)
+			rep.EXPECT().ExpireLots(gomock.Any(), 1, gomock.Any()).Return(decimal.Zero, errors.New("some error"))
+
+			users, err := e.Expire(context.Background(), time.Now())
+			Expect(err).Should(HaveOccurred())
+			Expect(users).Should(BeZero())
+		})
+		It("Run expires lots until Stop is called", func() {
+			e := internal.NewPointsExpiry(rep, 12, time.Hour, context.Background(), logger)
+
+			checked := make(chan struct{})
+			rep.EXPECT().GetUsersWithExpiredLots(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(context.Context, time.Time, int) {
+				close(checked)
+			}).Return(nil, nil)
+
+			go e.Run()
+			Eventually(checked).Should(BeClosed())
+			Expect(e.Stop(context.Background())).Should(Succeed())
+		})
+		It("Run is disabled when points don't expire", func() {
+			e := internal.NewPointsExpiry(rep, 0, time.Hour, context.Background(), logger)
+
+			go e.Run()
+			Expect(e.Stop(context.Background())).Should(Succeed())
+		})
+	})
+})
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+var _ = Describe("Handlers", func() {
+	var (
+		srv   *mock_internal.MockIService
+		app   *fiber.App
+		token string
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		logger, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		srv = mock_internal.NewMockIService(ctrl)
+		srv.EXPECT().IsSessionActive(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+		h := internal.NewHandlers(srv, "secret", "", logger.Sugar())
+
+		app = fiber.New(fiber.Config{ErrorHandler: internal.NewErrorHandler(logger.Sugar())})
+		app.Use(requestid.New())
+		internal.RegisterRoutes(app, h)
+
+		token, err = internal.NewService(nil, nil, internal.LoyaltyRules{}, "secret", logger.Sugar()).GetJWTToken("1", "sid")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	request := func(path, contentType, body string) (*http.Response, model.ErrorResponse) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		res, err := app.Test(req)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var e model.ErrorResponse
+		b, err := io.ReadAll(res.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+		if res.StatusCode >= fiber.StatusBadRequest {
+			Expect(json.Unmarshal(b, &e)).Should(Succeed())
+		}
+		return res, e
+	}
+
+	Context("Error response tests", func() {
+		It("CreateOrder with error luhn", func() {
+			srv.EXPECT().SendOrder(gomock.Any(), "1", 1).Return(internal.ErrLuhnInvalid)
+
+			res, e := request("/api/user/orders", "text/plain", "1")
+			Expect(res.StatusCode).Should(Equal(fiber.StatusUnprocessableEntity))
+			Expect(e.Error.Code).Should(Equal("ORDER_LUHN_INVALID"))
+			Expect(e.Error.Message).Should(Equal(internal.ErrLuhnInvalid.Error()))
+			Expect(e.Error.RequestID).Should(Equal(res.Header.Get(fiber.HeaderXRequestID)))
+			Expect(e.Error.RequestID).ShouldNot(BeEmpty())
+		})
+		It("CreateOrder with error not numeric", func() {
+			srv.EXPECT().SendOrder(gomock.Any(), "12a", 1).Return(internal.ErrOrderNumberIsNotNumeric)
+
+			res, e := request("/api/user/orders", "text/plain", "12a")
+			Expect(res.StatusCode).Should(Equal(fiber.StatusUnprocessableEntity))
+			Expect(e.Error.Code).Should(Equal("ORDER_NUMBER_NOT_NUMERIC"))
+		})
+		It("CreateOrder with error content type", func() {
+			res, e := request("/api/user/orders", "application/json", "1")
+			Expect(res.StatusCode).Should(Equal(fiber.StatusBadRequest))
+			Expect(e.Error.Code).Should(Equal("UNSUPPORTED_CONTENT_TYPE"))
+		})
+		It("CreateOrder already sent", func() {
+			srv.EXPECT().SendOrder(gomock.Any(), "79927398713", 1).Return(internal.ErrOrderIsAlreadySent)
+
+			res, _ := request("/api/user/orders", "text/plain", "79927398713")
+			Expect(res.StatusCode).Should(Equal(fiber.StatusOK))
+		})
+		It("Withdraw with error malformed body", func() {
+			res, e := request("/api/user/balance/withdraw", "application/json", "{")
+			Expect(res.StatusCode).Should(Equal(fiber.StatusBadRequest))
+			Expect(e.Error.Code).Should(Equal("MALFORMED_BODY"))
+		})
+		It("Withdraw with error insufficient funds", func() {
+			srv.EXPECT().Withdraw(gomock.Any(), gomock.Any(), 1).Return(internal.ErrInsufficientFunds)
+
+			res, e := request("/api/user/balance/withdraw", "application/json", `{"order":"2377225624","sum":751}`)
+			Expect(res.StatusCode).Should(Equal(fiber.StatusPaymentRequired))
+			Expect(e.Error.Code).Should(Equal("INSUFFICIENT_FUNDS"))
+		})
+		It("Withdraw with error negative sum", func() {
+			res, e := request("/api/user/balance/withdraw", "application/json", `{"order":"2377225624","sum":-1}`)
+			Expect(res.StatusCode).Should(Equal(fiber.StatusBadRequest))
+			Expect(e.Error.Code).Should(Equal("WITHDRAW_SUM_INVALID"))
+		})
+		It("Login with error invalid credentials", func() {
+			srv.EXPECT().Login(gomock.Any(), "login", "pass").Return(model.Tokens{}, internal.ErrInvalidCredentials)
+
+			res, e := request("/api/user/login", "application/json", `{"login":"login","password":"pass"}`)
+			Expect(res.StatusCode).Should(Equal(fiber.StatusUnauthorized))
+			Expect(e.Error.Code).Should(Equal("INVALID_CREDENTIALS"))
+		})
+		It("Login with unexpected error hides details", func() {
+			srv.EXPECT().Login(gomock.Any(), "login", "pass").Return(model.Tokens{}, errors.New("connection refused"))
+
+			res, e := request("/api/user/login", "application/json", `{"login":"login","password":"pass"}`)
+			Expect(res.StatusCode).Should(Equal(fiber.StatusInternalServerError))
+			Expect(e.Error.Code).Should(Equal("INTERNAL_ERROR"))
+			Expect(e.Error.Message).ShouldNot(ContainSubstring("connection refused"))
+		})
+		It("Unknown route", func() {
+			res, e := request("/api/user/unknown", "application/json", "")
+			Expect(res.StatusCode).Should(Equal(fiber.StatusNotFound))
+			Expect(e.Error.Code).Should(Equal("NOT_FOUND"))
+		})
+	})
+})
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+var _ = Describe("Health", func() {
+	const (
+		migrationVersion = 20261017130000
+		backlogLimit     = 100
+	)
+
+	var (
+		rep    *mock_internal.MockIRepository
+		acc    *mock_internal.MockIAccrual
+		health *internal.Health
+		app    *fiber.App
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		logger, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		rep = mock_internal.NewMockIRepository(ctrl)
+		acc = mock_internal.NewMockIAccrual(ctrl)
+		health = internal.NewHealth(rep, acc, migrationVersion, backlogLimit, logger.Sugar())
+
+		app = fiber.New(fiber.Config{ErrorHandler: internal.NewErrorHandler(logger.Sugar())})
+		internal.RegisterHealthRoutes(app, health)
+		internal.RegisterRoutes(app, internal.NewHandlers(nil, "secret", "", logger.Sugar()))
+	})
+
+	get := func(path string) (int, model.Health) {
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		b, err := io.ReadAll(res.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var h model.Health
+		Expect(json.Unmarshal(b, &h)).Should(Succeed())
+		return res.StatusCode, h
+	}
+
+	healthy := func() {
+		rep.EXPECT().Ping(gomock.Any()).Return(nil)
+		rep.EXPECT().GetMigrationVersion(gomock.Any()).Return(int64(migrationVersion), nil)
+		rep.EXPECT().CountAccrualJobs(gomock.Any()).Return(5, nil)
+		acc.EXPECT().Ping(gomock.Any()).Return(nil)
+	}
+
+	Context("Health tests", func() {
+		It("Liveness doesn't check dependencies", func() {
+			status, h := get("/healthz")
+			Expect(status).Should(Equal(http.StatusOK))
+			Expect(h.Status).Should(Equal(model.HealthStatusOK))
+		})
+		It("Ready when all checks pass", func() {
+			healthy()
+
+			status, h := get("/readyz")
+			Expect(status).Should(Equal(http.StatusOK))
+			Expect(h.Status).Should(Equal(model.HealthStatusOK))
+			Expect(h.Checks).Should(HaveLen(4))
+			Expect(h.Checks["migrations"].Detail).Should(Equal("version 20261017130000"))
+			Expect(h.Checks["accrual_backlog"].Detail).Should(Equal("5 of 100 jobs"))
+		})
+		It("Not ready when database is down", func() {
+			rep.EXPECT().Ping(gomock.Any()).Return(errors.New("connection refused"))
+			rep.EXPECT().GetMigrationVersion(gomock.Any()).Return(int64(0), errors.New("connection refused"))
+			rep.EXPECT().CountAccrualJobs(gomock.Any()).Return(0, errors.New("connection refused"))
+			acc.EXPECT().Ping(gomock.Any()).Return(nil)
+
+			status, h := get("/readyz")
+			Expect(status).Should(Equal(http.StatusServiceUnavailable))
+			Expect(h.Status).Should(Equal(model.HealthStatusFail))
+			Expect(h.Checks["database"]).Should(Equal(model.HealthCheck{Status: model.HealthStatusFail, Error: "connection refused"}))
+			Expect(h.Checks["accrual"].Status).Should(Equal(model.HealthStatusOK))
+		})
+		It("Not ready when migrations are behind", func() {
+			rep.EXPECT().Ping(gomock.Any()).Return(nil)
+			rep.EXPECT().GetMigrationVersion(gomock.Any()).Return(int64(20220213180920), nil)
+			rep.EXPECT().CountAccrualJobs(gomock.Any()).Return(0, nil)
+			acc.EXPECT().Ping(gomock.Any()).Return(nil)
+
+			status, h := get("/readyz")
+			Expect(status).Should(Equal(http.StatusServiceUnavailable))
+			Expect(h.Checks["migrations"].Status).Should(Equal(model.HealthStatusFail))
+		})
+		It("Not ready when accrual system is unreachable", func() {
+			rep.EXPECT().Ping(gomock.Any()).Return(nil)
+			rep.EXPECT().GetMigrationVersion(gomock.Any()).Return(int64(migrationVersion), nil)
+			rep.EXPECT().CountAccrualJobs(gomock.Any()).Return(0, nil)
+			acc.EXPECT().Ping(gomock.Any()).Return(errors.New("no such host"))
+
+			status, h := get("/readyz")
+			Expect(status).Should(Equal(http.StatusServiceUnavailable))
+			Expect(h.Checks["accrual"].Error).Should(Equal("no such host"))
+		})
+		It("Not ready when accrual backlog is too long", func() {
+			rep.EXPECT().Ping(gomock.Any()).Return(nil)
+			rep.EXPECT().GetMigrationVersion(gomock.Any()).Return(int64(migrationVersion), nil)
+			rep.EXPECT().CountAccrualJobs(gomock.Any()).Return(backlogLimit+1, nil)
+			acc.EXPECT().Ping(gomock.Any()).Return(nil)
+
+			status, h := get("/readyz")
+			Expect(status).Should(Equal(http.StatusServiceUnavailable))
+			Expect(h.Checks["accrual_backlog"].Status).Should(Equal(model.HealthStatusFail))
+		})
+		It("Not ready during shutdown", func() {
+			healthy()
+			status, _ := get("/readyz")
+			Expect(status).Should(Equal(http.StatusOK))
+
+			health.Shutdown()
+
+			status, h := get("/readyz")
+			Expect(status).Should(Equal(http.StatusServiceUnavailable))
+			Expect(h.Checks).Should(HaveKey("shutdown"))
+
+			status, _ = get("/healthz")
+			Expect(status).Should(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,199 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+// These specs need a real PostgreSQL, they are skipped unless TEST_DATABASE_URI is set.
+var _ = Describe("Integration", func() {
+	var repo *internal.Repository
+
+	BeforeEach(func() {
+		uri, ok := os.LookupEnv("TEST_DATABASE_URI")
+		if !ok {
+			Skip("TEST_DATABASE_URI is not set")
+		}
+
+		logger, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		repo, err = internal.NewRepository(uri, os.DirFS("../../cmd/gophermart"), logger.Sugar())
+		Expect(err).ShouldNot(HaveOccurred())
+		repo.Conn.SetMaxOpenConns(20)
+	})
+	AfterEach(func() {
+		if repo != nil {
+			Expect(repo.Conn.Close()).Should(Succeed())
+		}
+	})
+	It("parallel withdrawals never overdraw the balance", func() {
+		const (
+			balance     = 100
+			withdrawals = 300
+		)
+
+		ctx := context.Background()
+		suffix := time.Now().UnixNano()
+
+		uid, err := repo.Register(ctx, fmt.Sprintf("concurrency-%d", suffix), "password", fmt.Sprintf("%x", suffix), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		orderNumber := fmt.Sprintf("%d", suffix)
+		Expect(repo.SendOrder(ctx, orderNumber, uid)).Should(Succeed())
+		Expect(repo.MakeAccrual(ctx, uid, model.OrderStatusProcessed, orderNumber, decimal.NewFromInt(balance), decimal.Zero)).Should(Succeed())
+
+		var (
+			wg        sync.WaitGroup
+			succeeded int32
+			failed    int32
+		)
+		for n := 0; n < withdrawals; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				i := model.WithdrawInput{OrderNumber: fmt.Sprintf("%d%d", suffix, n), Sum: decimal.NewFromInt(1)}
+				err := repo.Withdraw(ctx, i, uid)
+				if errors.Is(err, internal.ErrInsufficientFunds) {
+					atomic.AddInt32(&failed, 1)
+					return
+				}
+				Expect(err).ShouldNot(HaveOccurred())
+				atomic.AddInt32(&succeeded, 1)
+			}(n)
+		}
+		wg.Wait()
+
+		Expect(succeeded).Should(Equal(int32(balance)))
+		Expect(failed).Should(Equal(int32(withdrawals - balance)))
+
+		bw, err := repo.GetBalanceByUserID(ctx, uid)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(bw.Balance.IsZero()).Should(BeTrue())
+		Expect(bw.Withdrawn.Equal(decimal.NewFromInt(balance))).Should(BeTrue())
+
+		// withdrawals have consumed the accrual lot completely
+		left, err := repo.GetExpiringPoints(ctx, uid, time.Now().AddDate(1, 0, 0))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(left.IsZero()).Should(BeTrue())
+	})
+	It("opposite parallel transfers neither deadlock nor overdraw", func() {
+		const balance = 50
+
+		ctx := context.Background()
+		suffix := time.Now().UnixNano()
+
+		var uids [2]int
+		for n := range uids {
+			uid, err := repo.Register(ctx, fmt.Sprintf("transfer-%d-%d", suffix, n), "password", fmt.Sprintf("%x", suffix+int64(n)), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			orderNumber := fmt.Sprintf("%d%d", suffix, n)
+			Expect(repo.SendOrder(ctx, orderNumber, uid)).Should(Succeed())
+			Expect(repo.MakeAccrual(ctx, uid, model.OrderStatusProcessed, orderNumber, decimal.NewFromInt(balance), decimal.Zero)).Should(Succeed())
+			uids[n] = uid
+		}
+
+		var wg sync.WaitGroup
+		for n := 0; n < 200; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				from, to := uids[n%2], fmt.Sprintf("transfer-%d-%d", suffix, 1-n%2)
+				err := repo.Transfer(ctx, model.TransferInput{Login: to, Sum: decimal.NewFromInt(1)}, from, model.TransferLimit{})
+				if !errors.Is(err, internal.ErrInsufficientFunds) {
+					Expect(err).ShouldNot(HaveOccurred())
+				}
+			}(n)
+		}
+		wg.Wait()
+
+		total := decimal.Zero
+		for _, uid := range uids {
+			bw, err := repo.GetBalanceByUserID(ctx, uid)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(bw.Balance.IsNegative()).Should(BeFalse())
+			total = total.Add(bw.Balance)
+
+			lots, err := repo.GetExpiringPoints(ctx, uid, time.Now().AddDate(1, 0, 0))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(lots.Equal(bw.Balance)).Should(BeTrue())
+		}
+		Expect(total.Equal(decimal.NewFromInt(2 * balance))).Should(BeTrue())
+	})
+	It("reversals return the withdrawal at most once", func() {
+		ctx := context.Background()
+		suffix := time.Now().UnixNano()
+
+		uid, err := repo.Register(ctx, fmt.Sprintf("reversal-%d", suffix), "password", fmt.Sprintf("%x", suffix), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		orderNumber := fmt.Sprintf("%d", suffix)
+		Expect(repo.SendOrder(ctx, orderNumber, uid)).Should(Succeed())
+		Expect(repo.MakeAccrual(ctx, uid, model.OrderStatusProcessed, orderNumber, decimal.NewFromInt(100), decimal.Zero)).Should(Succeed())
+
+		storeOrder := fmt.Sprintf("%d1", suffix)
+		Expect(repo.Withdraw(ctx, model.WithdrawInput{OrderNumber: storeOrder, Sum: decimal.NewFromInt(40)}, uid)).Should(Succeed())
+
+		part := decimal.NewFromInt(10)
+		out, err := repo.ReverseWithdrawal(ctx, model.ReversalInput{OrderNumber: storeOrder, Sum: &part})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(out.Reversed.Equal(part)).Should(BeTrue())
+
+		out, err = repo.ReverseWithdrawal(ctx, model.ReversalInput{OrderNumber: storeOrder})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(out.Sum.Equal(decimal.NewFromInt(30))).Should(BeTrue())
+
+		_, err = repo.ReverseWithdrawal(ctx, model.ReversalInput{OrderNumber: storeOrder})
+		Expect(err).Should(Equal(internal.ErrReversalExceedsWithdrawal))
+
+		bw, err := repo.GetBalanceByUserID(ctx, uid)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(bw.Balance.Equal(decimal.NewFromInt(100))).Should(BeTrue())
+		Expect(bw.Withdrawn.IsZero()).Should(BeTrue())
+	})
+	It("repeated accrual credits the order only once", func() {
+		ctx := context.Background()
+		suffix := time.Now().UnixNano()
+
+		uid, err := repo.Register(ctx, fmt.Sprintf("idempotency-%d", suffix), "password", fmt.Sprintf("%x", suffix), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		orderNumber := fmt.Sprintf("%d", suffix)
+		Expect(repo.SendOrder(ctx, orderNumber, uid)).Should(Succeed())
+
+		var wg sync.WaitGroup
+		for n := 0; n < 10; n++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				Expect(repo.MakeAccrual(ctx, uid, model.OrderStatusProcessed, orderNumber, decimal.NewFromInt(100), decimal.Zero)).Should(Succeed())
+			}()
+		}
+		wg.Wait()
+
+		bw, err := repo.GetBalanceByUserID(ctx, uid)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(bw.Balance.Equal(decimal.NewFromInt(100))).Should(BeTrue())
+	})
+})
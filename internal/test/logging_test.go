@@ -0,0 +1,136 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/golang/mock/gomock"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+var _ = Describe("Logging", func() {
+	var (
+		srv    *mock_internal.MockIService
+		rep    *mock_internal.MockIRepository
+		logs   *observer.ObservedLogs
+		logger *zap.SugaredLogger
+		token  string
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		var core zapcore.Core
+		core, logs = observer.New(zap.DebugLevel)
+		logger = zap.New(core).Sugar()
+
+		srv = mock_internal.NewMockIService(ctrl)
+		srv.EXPECT().IsSessionActive(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+		rep = mock_internal.NewMockIRepository(ctrl)
+
+		var err error
+		token, err = internal.NewService(nil, nil, internal.LoyaltyRules{}, "secret", logger).GetJWTToken("1", "sid")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	newApp := func(l *zap.SugaredLogger) *fiber.App {
+		app := fiber.New(fiber.Config{ErrorHandler: internal.NewErrorHandler(l)})
+		app.Use(requestid.New())
+		app.Use(internal.RequestLogger(l))
+		internal.RegisterRoutes(app, internal.NewHandlers(srv, "secret", "", l))
+		return app
+	}
+
+	getBalance := func(app *fiber.App) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		res, err := app.Test(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	Context("Logging tests", func() {
+		It("Logs of a request carry request id, user id and route", func() {
+			srv.EXPECT().GetBalanceByUserID(gomock.Any(), 1).Return(model.BalanceWithdrawn{Balance: decimal.NewFromInt(1)}, nil)
+
+			res := getBalance(newApp(logger))
+			Expect(res.StatusCode).Should(Equal(http.StatusOK))
+			requestID := res.Header.Get(fiber.HeaderXRequestID)
+			Expect(requestID).ShouldNot(BeEmpty())
+
+			balance := logs.FilterMessage("balance").All()
+			Expect(balance).Should(HaveLen(1))
+			Expect(balance[0].Level).Should(Equal(zap.DebugLevel))
+			Expect(balance[0].ContextMap()).Should(HaveKeyWithValue("request_id", requestID))
+			Expect(balance[0].ContextMap()).Should(HaveKeyWithValue("user_id", int64(1)))
+			Expect(balance[0].ContextMap()).Should(HaveKeyWithValue("route", "/api/user/balance"))
+
+			request := logs.FilterMessage("request").All()
+			Expect(request).Should(HaveLen(1))
+			Expect(request[0].ContextMap()).Should(HaveKeyWithValue("request_id", requestID))
+			Expect(request[0].ContextMap()).Should(HaveKeyWithValue("status", int64(http.StatusOK)))
+		})
+		It("Payloads aren't logged at info level", func() {
+			core, infoLogs := observer.New(zap.InfoLevel)
+			srv.EXPECT().GetBalanceByUserID(gomock.Any(), 1).Return(model.BalanceWithdrawn{Balance: decimal.NewFromInt(1)}, nil)
+
+			getBalance(newApp(zap.New(core).Sugar()))
+
+			Expect(infoLogs.FilterMessage("balance").Len()).Should(BeZero())
+			Expect(infoLogs.FilterMessage("request").Len()).Should(Equal(1))
+		})
+		It("Error handler logs with request fields", func() {
+			srv.EXPECT().GetBalanceByUserID(gomock.Any(), 1).Return(model.BalanceWithdrawn{}, errors.New("some error"))
+
+			res := getBalance(newApp(logger))
+			Expect(res.StatusCode).Should(Equal(http.StatusInternalServerError))
+
+			errs := logs.FilterMessageSnippet("Error on GET").All()
+			Expect(errs).Should(HaveLen(1))
+			Expect(errs[0].ContextMap()).Should(HaveKeyWithValue("request_id", res.Header.Get(fiber.HeaderXRequestID)))
+			Expect(errs[0].ContextMap()).Should(HaveKeyWithValue("user_id", int64(1)))
+		})
+		It("Accrual logs carry request id of the order upload", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer server.Close()
+
+			job := model.AccrualJob{ID: 7, OrderNumber: "79927398713", UserID: 1, Attempts: 1, RequestID: "upload-request"}
+			rescheduled := make(chan struct{})
+
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return([]model.AccrualJob{job}, nil)
+			rep.EXPECT().LeaseAccrualJobs(gomock.Any(), 1, gomock.Any()).Return(nil, nil).AnyTimes()
+			rep.EXPECT().RescheduleAccrualJob(gomock.Any(), 7, gomock.Any(), 1, gomock.Any()).Do(func(context.Context, int, time.Duration, int, string) {
+				close(rescheduled)
+			}).Return(nil)
+
+			acc := internal.NewAccrualService(rep, server.URL, 1, 0, context.Background(), logger)
+			go acc.Run()
+			Eventually(rescheduled).Should(BeClosed())
+			Expect(acc.Stop(context.Background())).Should(Succeed())
+
+			errs := logs.FilterMessageSnippet("ProcessAccrual error").All()
+			Expect(errs).Should(HaveLen(1))
+			Expect(errs[0].ContextMap()).Should(HaveKeyWithValue("request_id", "upload-request"))
+			Expect(errs[0].ContextMap()).Should(HaveKeyWithValue("order_number", "79927398713"))
+			Expect(errs[0].ContextMap()).Should(HaveKeyWithValue("user_id", int64(1)))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		rep    *mock_internal.MockIRepository
+		app    *fiber.App
+		logger *zap.SugaredLogger
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		z, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+		logger = z.Sugar()
+
+		rep = mock_internal.NewMockIRepository(ctrl)
+
+		app = fiber.New(fiber.Config{ErrorHandler: internal.NewErrorHandler(logger)})
+		app.Use(internal.Metrics())
+		internal.RegisterMetricsRoutes(app, prometheus.DefaultGatherer)
+		internal.RegisterRoutes(app, internal.NewHandlers(nil, "secret", "", logger))
+	})
+
+	scrape := func() string {
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		b, err := io.ReadAll(res.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+		return string(b)
+	}
+
+	Context("Metrics tests", func() {
+		It("Requests are counted by route template and status", func() {
+			_, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/user/orders?limit=5", nil))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			metrics := scrape()
+			Expect(metrics).Should(ContainSubstring(`gophermart_http_requests_total{code="401",method="GET",route="/api/v1/user/orders"}`))
+			Expect(metrics).Should(ContainSubstring(`gophermart_http_request_duration_seconds_count{method="GET",route="/api/v1/user/orders"}`))
+		})
+		It("Unknown paths share one label", func() {
+			_, err := app.Test(httptest.NewRequest(http.MethodGet, "/some/random/path", nil))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			metrics := scrape()
+			Expect(metrics).Should(ContainSubstring(`gophermart_http_requests_total{code="404",method="GET",route="unmatched"}`))
+			Expect(metrics).ShouldNot(ContainSubstring("/some/random/path"))
+		})
+		It("Accrual and points metrics are exported", func() {
+			metrics := scrape()
+			for _, name := range []string{
+				"gophermart_accrual_lag_seconds",
+				"gophermart_points_accrued_total",
+				"gophermart_points_withdrawn_total",
+			} {
+				Expect(metrics).Should(ContainSubstring("# TYPE " + name))
+			}
+		})
+		It("Queue collector reports pending orders and accrual jobs", func() {
+			rep.EXPECT().GetPendingOrderStats(gomock.Any()).Return([]model.PendingOrderStats{
+				{Status: model.OrderStatusNew, Count: 3, OldestUploadedAt: time.Now().Add(-time.Minute)},
+				{Status: model.OrderStatusProcessing, Count: 1, OldestUploadedAt: time.Now()},
+			}, nil)
+			rep.EXPECT().CountAccrualJobs(gomock.Any()).Return(4, nil)
+
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(internal.NewQueueCollector(rep, logger))
+
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gophermart_orders_pending Orders without final accrual status by status.
+# TYPE gophermart_orders_pending gauge
+gophermart_orders_pending{status="NEW"} 3
+gophermart_orders_pending{status="PROCESSING"} 1
+# HELP gophermart_accrual_queue_depth Jobs in the accrual queue.
+# TYPE gophermart_accrual_queue_depth gauge
+gophermart_accrual_queue_depth 4
+# HELP gophermart_queue_scrape_error 1 if the queue metrics couldn't be read from the database.
+# TYPE gophermart_queue_scrape_error gauge
+gophermart_queue_scrape_error 0
+`), "gophermart_orders_pending", "gophermart_accrual_queue_depth", "gophermart_queue_scrape_error")).Should(Succeed())
+		})
+		It("Queue collector reports scrape errors", func() {
+			rep.EXPECT().GetPendingOrderStats(gomock.Any()).Return(nil, errors.New("connection refused"))
+			rep.EXPECT().CountAccrualJobs(gomock.Any()).Return(0, errors.New("connection refused"))
+
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(internal.NewQueueCollector(rep, logger))
+
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gophermart_queue_scrape_error 1 if the queue metrics couldn't be read from the database.
+# TYPE gophermart_queue_scrape_error gauge
+gophermart_queue_scrape_error 1
+`))).Should(Succeed())
+		})
+	})
+})
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+)
+
+func loadOpenAPI() (*openapi3.T, routers.Router) {
+	doc, err := openapi3.NewLoader().LoadFromData(internal.OpenAPISpec)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(doc.Validate(context.Background())).Should(Succeed())
+
+	router, err := gorillamux.NewRouter(doc)
+	Expect(err).ShouldNot(HaveOccurred())
+	return doc, router
+}
+
+// validateOpenAPI checks the response against the OpenAPI document. The request is checked only if
+// it was accepted, requests which are expected to fail are invalid on purpose.
+func validateOpenAPI(router routers.Router, req *http.Request, reqBody string, res *http.Response, resBody []byte) error {
+	req = req.Clone(context.Background())
+	req.Body = io.NopCloser(strings.NewReader(reqBody))
+
+	route, params, err := router.FindRoute(req)
+	if err != nil {
+		return err
+	}
+
+	options := &openapi3filter.Options{
+		IncludeResponseStatus: true,
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+	}
+	in := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: params,
+		Route:      route,
+		Options:    options,
+	}
+
+	if res.StatusCode < http.StatusBadRequest {
+		err = openapi3filter.ValidateRequest(context.Background(), in)
+		if err != nil {
+			return err
+		}
+	}
+
+	return openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: in,
+		Status:                 res.StatusCode,
+		Header:                 res.Header,
+		Body:                   io.NopCloser(bytes.NewReader(resBody)),
+		Options:                options,
+	})
+}
+
+// fiberParam matches parameters of fiber routes like :number, the document names them {number}.
+var fiberParam = regexp.MustCompile(`:(\w+)`)
+
+var _ = Describe("OpenAPI", func() {
+	Context("OpenAPI tests", func() {
+		It("Document is valid", func() {
+			loadOpenAPI()
+		})
+		It("Document describes every registered route", func() {
+			doc, _ := loadOpenAPI()
+
+			app := fiber.New()
+			internal.RegisterHealthRoutes(app, internal.NewHealth(nil, nil, 0, 0, zap.NewNop().Sugar()))
+			internal.RegisterMetricsRoutes(app, prometheus.NewRegistry())
+			internal.RegisterRoutes(app, internal.NewHandlers(nil, "", "", zap.NewNop().Sugar()))
+
+			for _, routes := range app.Stack() {
+				for _, r := range routes {
+					// operational routes are described at the root, the catch-all and middlewares are mounted at /
+					if r.Method != http.MethodGet && r.Method != http.MethodPost || r.Path == "/" {
+						continue
+					}
+
+					path := strings.TrimPrefix(r.Path, "/api")
+					path = strings.TrimPrefix(path, "/v1")
+					path = fiberParam.ReplaceAllString(path, "{$1}")
+
+					item := doc.Paths.Find(path)
+					Expect(item).ShouldNot(BeNil(), "%s %s is not described", r.Method, r.Path)
+					Expect(item.GetOperation(r.Method)).ShouldNot(BeNil(), "%s %s is not described", r.Method, r.Path)
+				}
+			}
+		})
+		It("Document is served", func() {
+			app := fiber.New()
+			internal.RegisterRoutes(app, internal.NewHandlers(nil, "", "", zap.NewNop().Sugar()))
+
+			for _, path := range []string{"/api/openapi.json", "/api/v1/openapi.json"} {
+				res, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res.StatusCode).Should(Equal(fiber.StatusOK))
+				Expect(res.Header.Get("Content-Type")).Should(Equal(fiber.MIMEApplicationJSON))
+
+				b, err := io.ReadAll(res.Body)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(b).Should(MatchJSON(internal.OpenAPISpec))
+			}
+		})
+		It("Validation fails when handler drifts from the document", func() {
+			_, router := loadOpenAPI()
+
+			app := fiber.New()
+			app.Get("/api/user/balance", func(c *fiber.Ctx) error {
+				return c.JSON(fiber.Map{"balance": 1})
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+			res, err := app.Test(req)
+			Expect(err).ShouldNot(HaveOccurred())
+			b, err := io.ReadAll(res.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(validateOpenAPI(router, req, "", res, b)).ShouldNot(Succeed())
+		})
+	})
+})
@@ -2,10 +2,12 @@ package test
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 
@@ -58,25 +60,54 @@ var _ = Describe("Repository", func() {
 			}
 
 			expectedRows := sqlmock.NewRows([]string{
+				"ID",
 				"Number",
 				"Accrual",
 				"Status",
 				"UploadedAt",
-			}).AddRow(expectedOrder.Number, expectedOrder.Accrual, expectedOrder.Status, expectedOrder.UploadedAt)
+			}).AddRow(expectedOrder.ID, expectedOrder.Number, expectedOrder.Accrual, expectedOrder.Status, expectedOrder.UploadedAt)
 
-			mock.ExpectQuery("SELECT (.+) FROM orders WHERE user_id = \\$1 ORDER BY uploaded_at DESC").
-				WithArgs(uid).WillReturnRows(expectedRows).RowsWillBeClosed()
+			mock.ExpectQuery("SELECT (.+) FROM orders WHERE user_id = \\$1 ORDER BY uploaded_at, id LIMIT \\$2").
+				WithArgs(uid, 11).WillReturnRows(expectedRows).RowsWillBeClosed()
 
-			_, err := repo.GetOrders(context.Background(), uid)
+			page, err := repo.GetOrders(context.Background(), uid, model.PageQuery{Limit: 10})
 			Expect(err).ShouldNot(HaveOccurred())
+			Expect(page.Orders).Should(HaveLen(1))
+			Expect(page.Next).Should(BeNil())
+		})
+		It("GetOrders with filters and cursor", func() {
+			t := time.Date(2022, 2, 13, 18, 0, 0, 0, time.UTC)
+			uid := 1
+			q := model.PageQuery{
+				Limit:    2,
+				After:    &model.Cursor{Time: t, ID: 7},
+				Statuses: []string{model.OrderStatusNew, model.OrderStatusProcessed},
+				From:     t.Add(-time.Hour),
+				To:       t.Add(time.Hour),
+			}
+
+			expectedRows := sqlmock.NewRows([]string{"ID", "Number", "Accrual", "Status", "UploadedAt"}).
+				AddRow(8, "100", decimal.Zero, model.OrderStatusNew, t).
+				AddRow(9, "200", decimal.NewFromInt(5), model.OrderStatusProcessed, t.Add(time.Minute)).
+				AddRow(10, "300", decimal.Zero, model.OrderStatusNew, t.Add(2*time.Minute))
+
+			mock.ExpectQuery("SELECT (.+) FROM orders WHERE user_id = \\$1 AND status IN \\(\\$2, \\$3\\) AND uploaded_at >= \\$4 AND uploaded_at < \\$5 AND \\(uploaded_at, id\\) > \\(\\$6, \\$7\\) ORDER BY uploaded_at, id LIMIT \\$8").
+				WithArgs(uid, model.OrderStatusNew, model.OrderStatusProcessed, q.From.Local().Format(time.RFC3339Nano), q.To.Local().Format(time.RFC3339Nano), t, 7, 3).WillReturnRows(expectedRows).RowsWillBeClosed()
+
+			page, err := repo.GetOrders(context.Background(), uid, q)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(page.Orders).Should(HaveLen(2))
+			Expect(page.Orders[0].Accrual).Should(BeNil())
+			Expect(page.Orders[1].Accrual.Equal(decimal.NewFromInt(5))).Should(BeTrue())
+			Expect(page.Next).Should(Equal(&model.Cursor{Time: t.Add(time.Minute), ID: 9}))
 		})
 		It("GetOrders with error", func() {
 			uid := 1
 
-			mock.ExpectQuery("SELECT (.+) FROM orders WHERE user_id = \\$1 ORDER BY uploaded_at DESC").
-				WithArgs(uid).WillReturnError(errors.New("some error")).WillReturnRows()
+			mock.ExpectQuery("SELECT (.+) FROM orders WHERE user_id = \\$1 ORDER BY uploaded_at, id LIMIT \\$2").
+				WithArgs(uid, 11).WillReturnError(errors.New("some error")).WillReturnRows()
 
-			_, err := repo.GetOrders(context.Background(), uid)
+			_, err := repo.GetOrders(context.Background(), uid, model.PageQuery{Limit: 10})
 			Expect(err).Should(HaveOccurred())
 		})
 		It("GetOrdersByID without error", func() {
@@ -119,30 +150,71 @@ var _ = Describe("Repository", func() {
 			uid := 1
 
 			expectedWO := model.WithdrawOutput{
+				ID:          1,
 				OrderNumber: "100",
 				Sum:         decimal.NewFromInt(1),
-				ProcessedAt: t,
+				ProcessedAt: model.RFC3339Time{Time: t},
 			}
 
 			expectedRows := sqlmock.NewRows([]string{
+				"ID",
 				"OrderNumber",
 				"Sum",
 				"ProcessedAt",
-			}).AddRow(expectedWO.OrderNumber, expectedWO.Sum, expectedWO.ProcessedAt)
+			}).AddRow(expectedWO.ID, expectedWO.OrderNumber, expectedWO.Sum, expectedWO.ProcessedAt.Time)
 
-			mock.ExpectQuery("SELECT (.+) FROM withdraw_history WHERE user_id = \\$1 ORDER BY processed_at DESC").
-				WithArgs(uid).WillReturnRows(expectedRows).RowsWillBeClosed()
+			mock.ExpectQuery("SELECT (.+) FROM withdraw_history WHERE user_id = \\$1 ORDER BY processed_at, id LIMIT \\$2").
+				WithArgs(uid, 11).WillReturnRows(expectedRows).RowsWillBeClosed()
 
-			_, err := repo.GetWithdrawHistory(context.Background(), uid)
+			page, err := repo.GetWithdrawHistory(context.Background(), uid, model.PageQuery{Limit: 10})
 			Expect(err).ShouldNot(HaveOccurred())
+			Expect(page.Withdrawals).Should(HaveLen(1))
+			Expect(page.Next).Should(BeNil())
+		})
+		It("GetWithdrawHistory with time range and next page", func() {
+			t := time.Date(2022, 2, 13, 18, 0, 0, 0, time.UTC)
+			uid := 1
+			q := model.PageQuery{Limit: 1, From: t, Statuses: []string{model.OrderStatusNew}}
+
+			expectedRows := sqlmock.NewRows([]string{"ID", "OrderNumber", "Sum", "ProcessedAt"}).
+				AddRow(3, "100", decimal.NewFromInt(1), t).
+				AddRow(4, "200", decimal.NewFromInt(2), t.Add(time.Minute))
+
+			mock.ExpectQuery("SELECT (.+) FROM withdraw_history WHERE user_id = \\$1 AND processed_at >= \\$2 ORDER BY processed_at, id LIMIT \\$3").
+				WithArgs(uid, t.Local().Format(time.RFC3339Nano), 2).WillReturnRows(expectedRows).RowsWillBeClosed()
+
+			page, err := repo.GetWithdrawHistory(context.Background(), uid, q)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(page.Withdrawals).Should(HaveLen(1))
+			Expect(page.Next).Should(Equal(&model.Cursor{Time: t, ID: 3}))
+		})
+		It("GetWithdrawHistory compares the time range in local time", func() {
+			local := time.Local
+			time.Local = time.FixedZone("UTC+5", 5*60*60)
+			defer func() { time.Local = local }()
+
+			uid := 1
+			from := time.Date(2022, 2, 13, 0, 0, 0, 0, time.UTC)
+			to := time.Date(2022, 2, 14, 12, 0, 0, 0, time.FixedZone("UTC-3", -3*60*60))
+			q := model.PageQuery{Limit: 10, From: from, To: to}
+
+			// processed_at holds the local time, so both ends are sent as local time of the server
+			mock.ExpectQuery("SELECT (.+) FROM withdraw_history WHERE user_id = \\$1 AND processed_at >= \\$2 AND processed_at < \\$3 ORDER BY processed_at, id LIMIT \\$4").
+				WithArgs(uid, "2022-02-13T05:00:00+05:00", "2022-02-14T20:00:00+05:00", 11).
+				WillReturnRows(sqlmock.NewRows([]string{"ID", "OrderNumber", "Sum", "ProcessedAt"}))
+
+			page, err := repo.GetWithdrawHistory(context.Background(), uid, q)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(page.Withdrawals).Should(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
 		})
 		It("GetWithdrawHistory with error", func() {
 			uid := 1
 
-			mock.ExpectQuery("SELECT (.+) FROM withdraw_history WHERE user_id = \\$1 ORDER BY processed_at DESC").
-				WithArgs(uid).WillReturnError(errors.New("some error"))
+			mock.ExpectQuery("SELECT (.+) FROM withdraw_history WHERE user_id = \\$1 ORDER BY processed_at, id LIMIT \\$2").
+				WithArgs(uid, 11).WillReturnError(errors.New("some error"))
 
-			_, err := repo.GetWithdrawHistory(context.Background(), uid)
+			_, err := repo.GetWithdrawHistory(context.Background(), uid, model.PageQuery{Limit: 10})
 			Expect(err).Should(HaveOccurred())
 		})
 		It("GetBalanceByUserID without error", func() {
@@ -173,170 +245,257 @@ var _ = Describe("Repository", func() {
 			_, err := repo.GetBalanceByUserID(context.Background(), uid)
 			Expect(err).Should(HaveOccurred())
 		})
-		It("SendOrder without error", func() {
-			n := "name"
-			p := 1
+		It("GetExpiringPoints without error", func() {
+			uid := 1
+			before := time.Now()
 
-			mock.ExpectExec("INSERT INTO orders (.+) VALUES (.+)").
-				WithArgs().WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(remaining\\), 0\\) FROM accrual_lots WHERE user_id = \\$1 AND remaining > 0 AND credited_at < \\$2").
+				WithArgs(uid, before.Format(time.RFC3339)).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("12.5"))
 
-			err := repo.SendOrder(context.Background(), n, p)
+			points, err := repo.GetExpiringPoints(context.Background(), uid, before)
 			Expect(err).ShouldNot(HaveOccurred())
+			Expect(points.Equal(decimal.RequireFromString("12.5"))).Should(BeTrue())
 		})
-		It("SendOrder with error", func() {
-			n := "name"
-			p := 1
+		It("GetUsersWithExpiredLots without error", func() {
+			before := time.Now()
 
-			mock.ExpectExec("INSERT INTO orders (.+) VALUES (.+)").
-				WithArgs().WillReturnError(errors.New("some error"))
+			mock.ExpectQuery("SELECT DISTINCT user_id FROM accrual_lots WHERE remaining > 0 AND credited_at < \\$1 ORDER BY user_id LIMIT \\$2").
+				WithArgs(before.Format(time.RFC3339), 10).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1).AddRow(3)).RowsWillBeClosed()
 
-			err := repo.SendOrder(context.Background(), n, p)
-			Expect(err).Should(HaveOccurred())
+			uids, err := repo.GetUsersWithExpiredLots(context.Background(), before, 10)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(uids).Should(Equal([]int{1, 3}))
 		})
-		It("SendOrder without error", func() {
+		It("ExpireLots without error", func() {
 			uid := 1
-			i := model.WithdrawInput{
-				OrderNumber: "1",
-				Sum:         decimal.NewFromInt(1),
-			}
-			bw := model.BalanceWithdrawn{
-				Balance:   decimal.NewFromInt(1),
-				Withdrawn: decimal.NewFromInt(1),
-			}
+			before := time.Now()
 
 			mock.ExpectBegin()
 
-			mock.ExpectExec("INSERT INTO withdraw_history (.+) VALUES (.+)").
-				WithArgs(i.OrderNumber, uid, i.Sum, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("SELECT id FROM users WHERE id = \\$1 FOR UPDATE").
+				WithArgs(uid).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectQuery("UPDATE accrual_lots l SET remaining = 0 FROM \\(SELECT (.+) FOR UPDATE\\) old WHERE l.id = old.id RETURNING (.+)").
+				WithArgs(uid, before.Format(time.RFC3339)).WillReturnRows(sqlmock.NewRows([]string{"order_number", "remaining"}).AddRow("100", "2").AddRow("", "0.5"))
 
-			mock.ExpectExec("UPDATE users SET balance = \\$1, withdrawn = \\$2 WHERE id = \\$3").
-				WithArgs(bw.Balance, bw.Withdrawn, uid).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindExpiry, "100", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectExec("INSERT INTO ledger_entries (.+) VALUES (.+)").
+				WithArgs(1, model.LedgerAccountUser, uid, decimal.NewFromInt(-2), model.LedgerAccountExpirations, decimal.NewFromInt(2)).WillReturnResult(sqlmock.NewResult(1, 2))
+
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindExpiry, "", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			mock.ExpectExec("INSERT INTO ledger_entries (.+) VALUES (.+)").
+				WithArgs(2, model.LedgerAccountUser, uid, decimal.RequireFromString("-0.5"), model.LedgerAccountExpirations, decimal.RequireFromString("0.5")).WillReturnResult(sqlmock.NewResult(1, 2))
+
+			mock.ExpectExec("UPDATE users SET balance = balance - \\$1 WHERE id = \\$2").
+				WithArgs(decimal.RequireFromString("2.5"), uid).WillReturnResult(sqlmock.NewResult(0, 1))
 
 			mock.ExpectCommit()
 
-			err := repo.Withdraw(context.Background(), i, bw, uid)
+			expired, err := repo.ExpireLots(context.Background(), uid, before)
 			Expect(err).ShouldNot(HaveOccurred())
+			Expect(expired.Equal(decimal.RequireFromString("2.5"))).Should(BeTrue())
 		})
-		It("Register without error", func() {
-			login := "test"
-			password := "testest"
+		It("ExpireLots without expired lots", func() {
+			uid := 1
+			before := time.Now()
 
-			mock.ExpectQuery("INSERT INTO users (.+)").
-				WithArgs(login, password).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectBegin()
+			mock.ExpectExec("SELECT id FROM users WHERE id = \\$1 FOR UPDATE").
+				WithArgs(uid).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("UPDATE accrual_lots l SET remaining = 0 (.+)").
+				WithArgs(uid, before.Format(time.RFC3339)).WillReturnRows(sqlmock.NewRows([]string{"order_number", "remaining"}))
+			mock.ExpectCommit()
 
-			_, err := repo.Register(context.Background(), login, password)
+			expired, err := repo.ExpireLots(context.Background(), uid, before)
 			Expect(err).ShouldNot(HaveOccurred())
+			Expect(expired.IsZero()).Should(BeTrue())
 		})
-		It("Register with error", func() {
-			login := "test"
-			password := "testest"
+		It("ExpireLots with error", func() {
+			uid := 1
+			before := time.Now()
 
-			mock.ExpectQuery("INSERT INTO users (.+)").
-				WithArgs(login, password).WillReturnError(errors.New("some error"))
+			mock.ExpectBegin()
+			mock.ExpectExec("SELECT id FROM users WHERE id = \\$1 FOR UPDATE").
+				WithArgs(uid).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("UPDATE accrual_lots l SET remaining = 0 (.+)").
+				WithArgs(uid, before.Format(time.RFC3339)).WillReturnError(errors.New("some error"))
+			mock.ExpectRollback()
 
-			_, err := repo.Register(context.Background(), login, password)
+			_, err := repo.ExpireLots(context.Background(), uid, before)
 			Expect(err).Should(HaveOccurred())
 		})
-		It("SendOrder with error", func() {
+		It("GetUserTier without error", func() {
 			uid := 1
-			i := model.WithdrawInput{
-				OrderNumber: "1",
-				Sum:         decimal.NewFromInt(1),
-			}
-			bw := model.BalanceWithdrawn{
-				Balance:   decimal.NewFromInt(1),
-				Withdrawn: decimal.NewFromInt(1),
-			}
-
-			mock.ExpectBegin()
+			since := time.Now().AddDate(-1, 0, 0)
+			oldest := time.Now().AddDate(0, -3, 0)
 
-			mock.ExpectExec("INSERT INTO withdraw_history (.+) VALUES (.+)").
-				WithArgs().WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("SELECT u.tier, u.tier_review_at, COALESCE\\(a.points, 0\\), a.oldest FROM users u LEFT JOIN LATERAL (.+) WHERE u.id = \\$1").
+				WithArgs(uid, model.LedgerAccountUser, model.LedgerKindAccrual, since.Format(time.RFC3339)).
+				WillReturnRows(sqlmock.NewRows([]string{"tier", "tier_review_at", "points", "oldest"}).AddRow(model.TierSilver, nil, "1500", oldest))
 
-			mock.ExpectExec("UPDATE users SET balance = \\$1, withdrawn = \\$2 WHERE id = \\$3").
-				WithArgs().WillReturnError(errors.New("some error"))
+			state, err := repo.GetUserTier(context.Background(), uid, since)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(state.Tier).Should(Equal(model.TierSilver))
+			Expect(state.ReviewAt).Should(BeNil())
+			Expect(state.Points.String()).Should(Equal("1500"))
+			Expect(*state.OldestAccrualAt).Should(BeTemporally("==", oldest))
+		})
+		It("GetUserTier with error no records", func() {
+			mock.ExpectQuery("SELECT u.tier, (.+)").WillReturnError(sql.ErrNoRows)
 
-			err := repo.Withdraw(context.Background(), i, bw, uid)
-			Expect(err).Should(HaveOccurred())
+			_, err := repo.GetUserTier(context.Background(), 1, time.Now())
+			Expect(err).Should(Equal(internal.ErrNoRecords))
 		})
-		It("SendOrder with other error", func() {
+		It("SetUserTier records the change", func() {
 			uid := 1
-			i := model.WithdrawInput{
-				OrderNumber: "1",
-				Sum:         decimal.NewFromInt(1),
-			}
-			bw := model.BalanceWithdrawn{
-				Balance:   decimal.NewFromInt(1),
-				Withdrawn: decimal.NewFromInt(1),
-			}
+			reviewAt := time.Now().AddDate(0, 6, 0)
+			c := model.TierChange{From: model.TierBronze, To: model.TierSilver, Points: decimal.NewFromInt(1000), ReviewAt: &reviewAt}
 
 			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE users SET tier = \\$1, tier_review_at = \\$2 WHERE id = \\$3 AND tier = \\$4").
+				WithArgs(c.To, reviewAt.Format(time.RFC3339), uid, c.From).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO tier_events \\(user_id, from_tier, to_tier, points, created_at\\) VALUES (.+)").
+				WithArgs(uid, c.From, c.To, c.Points, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
 
-			mock.ExpectExec("INSERT INTO withdraw_history (.+) VALUES (.+)").
-				WithArgs().WillReturnError(errors.New("some error"))
+			Expect(repo.SetUserTier(context.Background(), uid, c)).Should(Succeed())
+		})
+		It("SetUserTier without change of tier", func() {
+			uid := 1
+			c := model.TierChange{From: model.TierSilver, To: model.TierSilver, Points: decimal.NewFromInt(1000)}
 
-			err := repo.Withdraw(context.Background(), i, bw, uid)
-			Expect(err).Should(HaveOccurred())
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE users SET tier (.+)").
+				WithArgs(c.To, nil, uid, c.From).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			Expect(repo.SetUserTier(context.Background(), uid, c)).Should(Succeed())
 		})
-		It("MakeAccrual without error", func() {
+		It("SetUserTier after concurrent evaluation", func() {
 			uid := 1
-			status := "NEW"
-			orderNumber := "100"
-			accrual := decimal.NewFromInt(1)
-			balance := decimal.NewFromInt(1)
+			c := model.TierChange{From: model.TierBronze, To: model.TierSilver, Points: decimal.NewFromInt(1000)}
 
 			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE users SET tier (.+)").
+				WithArgs(c.To, nil, uid, c.From).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
 
-			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3").
-				WithArgs(status, accrual, orderNumber).WillReturnResult(sqlmock.NewResult(1, 1))
+			Expect(repo.SetUserTier(context.Background(), uid, c)).Should(Succeed())
+		})
+		It("GetUsersForTierReview without error", func() {
+			before := time.Now()
 
-			mock.ExpectExec("UPDATE users SET balance = \\$1 WHERE id = \\$2").
-				WithArgs(balance, uid).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("SELECT id FROM users WHERE tier_review_at < \\$1 AND id > \\$2 ORDER BY id LIMIT \\$3").
+				WithArgs(before.Format(time.RFC3339), 5, 100).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6).AddRow(9))
 
-			mock.ExpectCommit()
+			uids, err := repo.GetUsersForTierReview(context.Background(), before, 5, 100)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(uids).Should(Equal([]int{6, 9}))
+		})
+		It("SendOrder without error", func() {
+			n := "name"
+			p := 1
+
+			mock.ExpectExec("INSERT INTO orders (.+) VALUES (.+) INSERT INTO accrual_jobs (.+)").
+				WithArgs().WillReturnResult(sqlmock.NewResult(1, 1))
 
-			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, balance)
+			err := repo.SendOrder(context.Background(), n, p)
 			Expect(err).ShouldNot(HaveOccurred())
 		})
-		It("MakeAccrual with error", func() {
-			uid := 1
-			status := "NEW"
-			orderNumber := "100"
-			accrual := decimal.NewFromInt(1)
-			balance := decimal.NewFromInt(1)
+		It("SendOrder with error", func() {
+			n := "name"
+			p := 1
+
+			mock.ExpectExec("INSERT INTO orders (.+) VALUES (.+)").
+				WithArgs().WillReturnError(errors.New("some error"))
+
+			err := repo.SendOrder(context.Background(), n, p)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("Register without error", func() {
+			login := "test"
+			password := "testest"
 
 			mock.ExpectBegin()
+			mock.ExpectQuery("INSERT INTO users (.+)").
+				WithArgs(login, password, "CODE").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectCommit()
 
-			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3").
-				WithArgs(status, accrual, orderNumber).WillReturnError(errors.New("some error"))
+			_, err := repo.Register(context.Background(), login, password, "CODE", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("Register with error", func() {
+			login := "test"
+			password := "testest"
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("INSERT INTO users (.+)").
+				WithArgs(login, password, "CODE").WillReturnError(errors.New("some error"))
+			mock.ExpectRollback()
 
-			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, balance)
+			_, err := repo.Register(context.Background(), login, password, "CODE", nil)
 			Expect(err).Should(HaveOccurred())
 		})
-		It("MakeAccrual with other error", func() {
-			uid := 1
-			status := "NEW"
-			orderNumber := "100"
-			accrual := decimal.NewFromInt(1)
-			balance := decimal.NewFromInt(1)
+		It("Register by referral code records the referral", func() {
+			bonus := decimal.NewFromInt(100)
+			since := time.Now().Add(-internal.ReferralCapWindow)
+			terms := &model.ReferralTerms{Code: "REFERRER", Bonus: bonus, Cap: 10, CapSince: since}
 
 			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE referral_code = \\$1 FOR UPDATE").
+				WithArgs("REFERRER").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			mock.ExpectQuery("INSERT INTO users (.+)").
+				WithArgs("test", "testest", "CODE").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+			mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM referrals (.+)").
+				WithArgs(7, since).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(9))
+			mock.ExpectExec("INSERT INTO referrals (.+)").
+				WithArgs(7, 8, bonus, bonus, false, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
 
-			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3").
-				WithArgs(status, accrual, orderNumber).WillReturnResult(sqlmock.NewResult(1, 1))
+			id, err := repo.Register(context.Background(), "test", "testest", "CODE", terms)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(id).Should(Equal(8))
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("Register by referral code above the cap gives no bonus to the referrer", func() {
+			bonus := decimal.NewFromInt(100)
+			terms := &model.ReferralTerms{Code: "REFERRER", Bonus: bonus, Cap: 10, CapSince: time.Now()}
 
-			mock.ExpectExec("UPDATE users SET balance = \\$1 WHERE id = \\$2").
-				WithArgs(balance, uid).WillReturnError(errors.New("some error"))
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE referral_code = \\$1 FOR UPDATE").
+				WithArgs("REFERRER").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			mock.ExpectQuery("INSERT INTO users (.+)").
+				WithArgs("test", "testest", "CODE").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+			mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM referrals (.+)").
+				WithArgs(7, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+			mock.ExpectExec("INSERT INTO referrals (.+)").
+				WithArgs(7, 8, decimal.Zero, bonus, true, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
 
-			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, balance)
-			Expect(err).Should(HaveOccurred())
+			_, err := repo.Register(context.Background(), "test", "testest", "CODE", terms)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("Register by unknown referral code", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE referral_code = \\$1 FOR UPDATE").
+				WithArgs("UNKNOWN").WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			_, err := repo.Register(context.Background(), "test", "testest", "CODE", &model.ReferralTerms{Code: "UNKNOWN"})
+			Expect(err).Should(Equal(internal.ErrInvalidReferralCode))
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
 		})
 		It("UpdateOrderStatus without error", func() {
 			status := "NEW"
 			orderNumber := "100"
 
-			mock.ExpectExec("UPDATE orders SET status = \\$1 WHERE number = \\$2").
-				WithArgs(status, orderNumber).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE orders SET status = \\$1 WHERE number = \\$2 AND status NOT IN \\(\\$3, \\$4\\)").
+				WithArgs(status, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnResult(sqlmock.NewResult(1, 1))
 
 			err := repo.UpdateOrderStatus(context.Background(), orderNumber, status)
 			Expect(err).ShouldNot(HaveOccurred())
@@ -345,8 +504,8 @@ var _ = Describe("Repository", func() {
 			status := "NEW"
 			orderNumber := "100"
 
-			mock.ExpectExec("UPDATE orders SET status = \\$1 WHERE number = \\$2").
-				WithArgs(status, orderNumber).WillReturnError(errors.New("some error"))
+			mock.ExpectExec("UPDATE orders SET status = \\$1 WHERE number = \\$2 AND status NOT IN \\(\\$3, \\$4\\)").
+				WithArgs(status, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnError(errors.New("some error"))
 
 			err := repo.UpdateOrderStatus(context.Background(), orderNumber, status)
 			Expect(err).Should(HaveOccurred())
@@ -369,93 +528,914 @@ var _ = Describe("Repository", func() {
 			_, err := repo.IsUserExist(context.Background(), login)
 			Expect(err).Should(HaveOccurred())
 		})
-		It("CheckCredentials without error", func() {
+		It("GetUserByLogin without error", func() {
 			login := "test"
-			password := "pass"
 
-			mock.ExpectQuery("SELECT id FROM users WHERE login = \\$1 AND password = \\$2").
-				WithArgs(login, password).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectQuery("SELECT id, login, password FROM users WHERE login = \\$1").
+				WithArgs(login).WillReturnRows(sqlmock.NewRows([]string{"id", "login", "password"}).AddRow(1, login, "hash"))
 
-			_, err := repo.CheckCredentials(context.Background(), login, password)
+			u, err := repo.GetUserByLogin(context.Background(), login)
 			Expect(err).ShouldNot(HaveOccurred())
+			Expect(u.ID).Should(Equal(1))
+			Expect(u.Password).Should(Equal("hash"))
+		})
+		It("GetUserByLogin without records", func() {
+			login := "test"
+
+			mock.ExpectQuery("SELECT id, login, password FROM users WHERE login = \\$1").
+				WithArgs(login).WillReturnRows(sqlmock.NewRows([]string{"id", "login", "password"}))
+
+			_, err := repo.GetUserByLogin(context.Background(), login)
+			Expect(err).Should(Equal(internal.ErrNoRecords))
 		})
-		It("CheckCredentials with error", func() {
+		It("GetUserByLogin with error", func() {
 			login := "test"
-			password := "pass"
 
-			mock.ExpectQuery("SELECT id FROM users WHERE login = \\$1 AND password = \\$2").
-				WithArgs(login, password).WillReturnError(errors.New("some error"))
+			mock.ExpectQuery("SELECT id, login, password FROM users WHERE login = \\$1").
+				WithArgs(login).WillReturnError(errors.New("some error"))
 
-			_, err := repo.CheckCredentials(context.Background(), login, password)
+			_, err := repo.GetUserByLogin(context.Background(), login)
 			Expect(err).Should(HaveOccurred())
 		})
-		It("Withdraw without error", func() {
+		It("UpdatePassword without error", func() {
+			uid := 1
+			password := "hash"
+
+			mock.ExpectExec("UPDATE users SET password = \\$1 WHERE id = \\$2").
+				WithArgs(password, uid).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := repo.UpdatePassword(context.Background(), uid, password)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("UpdatePassword with error", func() {
 			uid := 1
+			password := "hash"
 
+			mock.ExpectExec("UPDATE users SET password = \\$1 WHERE id = \\$2").
+				WithArgs(password, uid).WillReturnError(errors.New("some error"))
+
+			err := repo.UpdatePassword(context.Background(), uid, password)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("LeaseAccrualJobs without error", func() {
+			limit := 10
+			lease := time.Minute
+
+			expectedRows := sqlmock.NewRows([]string{
+				"ID",
+				"OrderNumber",
+				"UserID",
+				"Attempts",
+				"Failures",
+				"CreatedAt",
+				"TraceContext",
+				"RequestID",
+			}).AddRow(1, "100", 1, 1, 0, "2026-10-17 12:00:00", "", "").AddRow(2, "200", 2, 3, 2, "2026-10-17 12:30:05.123456", "", "")
+
+			mock.ExpectQuery("UPDATE accrual_jobs SET (.+) WHERE id IN \\(SELECT id FROM accrual_jobs (.+) FOR UPDATE SKIP LOCKED\\) RETURNING (.+)").
+				WithArgs(lease.Milliseconds(), limit).WillReturnRows(expectedRows).RowsWillBeClosed()
+
+			jobs, err := repo.LeaseAccrualJobs(context.Background(), limit, lease)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(jobs).Should(HaveLen(2))
+			Expect(jobs[1].OrderNumber).Should(Equal("200"))
+			Expect(jobs[1].Attempts).Should(Equal(3))
+			Expect(jobs[1].Failures).Should(Equal(2))
+			Expect(jobs[1].CreatedAt).Should(Equal(time.Date(2026, 10, 17, 12, 30, 5, 123456000, time.Local)))
+		})
+		It("LeaseAccrualJobs with error", func() {
+			limit := 10
+			lease := time.Minute
+
+			mock.ExpectQuery("UPDATE accrual_jobs SET (.+) RETURNING (.+)").
+				WithArgs(lease.Milliseconds(), limit).WillReturnError(errors.New("some error"))
+
+			_, err := repo.LeaseAccrualJobs(context.Background(), limit, lease)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("RescheduleAccrualJob without error", func() {
+			id := 1
+			delay := time.Second
+			lastError := "some error"
+
+			mock.ExpectExec("UPDATE accrual_jobs SET next_attempt_at = (.+), failures = \\$2, last_error = \\$3 WHERE id = \\$4").
+				WithArgs(delay.Milliseconds(), 2, lastError, id).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := repo.RescheduleAccrualJob(context.Background(), id, delay, 2, lastError)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("RescheduleAccrualJob with error", func() {
+			id := 1
+			delay := time.Second
+			lastError := "some error"
+
+			mock.ExpectExec("UPDATE accrual_jobs SET next_attempt_at = (.+), failures = \\$2, last_error = \\$3 WHERE id = \\$4").
+				WithArgs(delay.Milliseconds(), 2, lastError, id).WillReturnError(errors.New("some error"))
+
+			err := repo.RescheduleAccrualJob(context.Background(), id, delay, 2, lastError)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("CompleteAccrualJob without error", func() {
+			id := 1
+
+			mock.ExpectExec("DELETE FROM accrual_jobs WHERE id = \\$1").
+				WithArgs(id).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := repo.CompleteAccrualJob(context.Background(), id)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("CompleteAccrualJob with error", func() {
+			id := 1
+
+			mock.ExpectExec("DELETE FROM accrual_jobs WHERE id = \\$1").
+				WithArgs(id).WillReturnError(errors.New("some error"))
+
+			err := repo.CompleteAccrualJob(context.Background(), id)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("Withdraw without error", func() {
+			uid := 1
 			i := model.WithdrawInput{
 				OrderNumber: "1",
 				Sum:         decimal.NewFromInt(1),
 			}
-			bw := model.BalanceWithdrawn{
-				Balance:   decimal.NewFromInt(1),
-				Withdrawn: decimal.NewFromInt(1),
-			}
 
 			mock.ExpectBegin()
 
-			mock.ExpectExec("INSERT INTO withdraw_history \\(order_number, user_id, amount, processed_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").
-				WithArgs(i.OrderNumber, uid, i.Sum, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE users SET balance = balance - \\$1, withdrawn = withdrawn \\+ \\$1 WHERE id = \\$2 AND balance >= \\$1").
+				WithArgs(i.Sum, uid).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectQuery("INSERT INTO withdraw_history \\(order_number, user_id, amount, processed_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\) RETURNING id").
+				WithArgs(i.OrderNumber, uid, i.Sum, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+
+			// the oldest lot is spent first, the rest is taken from the next one
+			older := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			newer := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+			mock.ExpectQuery("SELECT id, remaining, credited_at FROM accrual_lots WHERE user_id = \\$1 AND remaining > 0 ORDER BY credited_at, id FOR UPDATE").
+				WithArgs(uid).WillReturnRows(sqlmock.NewRows([]string{"id", "remaining", "credited_at"}).AddRow(1, "0.25", older).AddRow(2, "5", newer).AddRow(3, "5", newer))
 
-			mock.ExpectExec("UPDATE users SET balance = \\$1, withdrawn = \\$2 WHERE id = \\$3").
-				WithArgs(bw.Balance, bw.Withdrawn, uid).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE accrual_lots SET remaining = remaining - \\$1 WHERE id = \\$2").
+				WithArgs(decimal.RequireFromString("0.25"), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectExec("UPDATE accrual_lots SET remaining = remaining - \\$1 WHERE id = \\$2").
+				WithArgs(decimal.RequireFromString("0.75"), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			// the consumed parts are kept with the withdrawal for its reversals
+			mock.ExpectExec("INSERT INTO withdrawal_lots \\(withdrawal_id, amount, remaining, credited_at\\) VALUES \\(\\$1, \\$2, \\$2, \\$3\\)").
+				WithArgs(5, decimal.RequireFromString("0.25"), older).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectExec("INSERT INTO withdrawal_lots (.+)").
+				WithArgs(5, decimal.RequireFromString("0.75"), newer).WillReturnResult(sqlmock.NewResult(2, 1))
+
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindWithdrawal, i.OrderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+			mock.ExpectExec("INSERT INTO ledger_entries (.+) VALUES (.+)").
+				WithArgs(1, model.LedgerAccountUser, uid, i.Sum.Neg(), model.LedgerAccountWithdrawals, i.Sum).WillReturnResult(sqlmock.NewResult(1, 2))
 
 			mock.ExpectCommit()
 
-			err := repo.Withdraw(context.Background(), i, bw, uid)
+			err := repo.Withdraw(context.Background(), i, uid)
 			Expect(err).ShouldNot(HaveOccurred())
 		})
 		It("Withdraw with error", func() {
 			uid := 1
+			i := model.WithdrawInput{
+				OrderNumber: "1",
+				Sum:         decimal.NewFromInt(1),
+			}
+
+			mock.ExpectBegin()
 
+			mock.ExpectExec("UPDATE users SET balance = balance - \\$1, withdrawn = withdrawn \\+ \\$1 WHERE id = \\$2 AND balance >= \\$1").
+				WithArgs(i.Sum, uid).WillReturnError(errors.New("some error"))
+
+			mock.ExpectRollback()
+
+			err := repo.Withdraw(context.Background(), i, uid)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("Withdraw with insufficient funds", func() {
+			uid := 1
 			i := model.WithdrawInput{
 				OrderNumber: "1",
 				Sum:         decimal.NewFromInt(1),
 			}
-			bw := model.BalanceWithdrawn{
-				Balance:   decimal.NewFromInt(1),
-				Withdrawn: decimal.NewFromInt(1),
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE users SET balance = balance - \\$1, withdrawn = withdrawn \\+ \\$1 WHERE id = \\$2 AND balance >= \\$1").
+				WithArgs(i.Sum, uid).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mock.ExpectRollback()
+
+			err := repo.Withdraw(context.Background(), i, uid)
+			Expect(err).Should(Equal(internal.ErrInsufficientFunds))
+		})
+		It("Withdraw of an order which is already paid with points", func() {
+			uid := 1
+			i := model.WithdrawInput{
+				OrderNumber: "1",
+				Sum:         decimal.NewFromInt(1),
 			}
 
 			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE users SET balance (.+)").
+				WithArgs(i.Sum, uid).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("INSERT INTO withdraw_history (.+)").
+				WithArgs(i.OrderNumber, uid, i.Sum, sqlmock.AnyArg()).WillReturnError(&pgconn.PgError{Code: "23505"})
+			mock.ExpectRollback()
+
+			err := repo.Withdraw(context.Background(), i, uid)
+			Expect(err).Should(Equal(internal.ErrOrderIsAlreadyWithdrawn))
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("Withdraw with other error", func() {
+			uid := 1
+			i := model.WithdrawInput{
+				OrderNumber: "1",
+				Sum:         decimal.NewFromInt(1),
+			}
 
-			mock.ExpectExec("INSERT INTO withdraw_history \\(order_number, user_id, amount, processed_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE users SET balance = balance - \\$1, withdrawn = withdrawn \\+ \\$1 WHERE id = \\$2 AND balance >= \\$1").
+				WithArgs(i.Sum, uid).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectQuery("INSERT INTO withdraw_history \\(order_number, user_id, amount, processed_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\) RETURNING id").
 				WithArgs(i.OrderNumber, uid, i.Sum, sqlmock.AnyArg()).WillReturnError(errors.New("some error"))
 
-			err := repo.Withdraw(context.Background(), i, bw, uid)
+			mock.ExpectRollback()
+
+			err := repo.Withdraw(context.Background(), i, uid)
 			Expect(err).Should(HaveOccurred())
 		})
-		It("Withdraw with other error", func() {
+		It("Withdraw with ledger error", func() {
 			uid := 1
-
 			i := model.WithdrawInput{
 				OrderNumber: "1",
 				Sum:         decimal.NewFromInt(1),
 			}
-			bw := model.BalanceWithdrawn{
-				Balance:   decimal.NewFromInt(1),
-				Withdrawn: decimal.NewFromInt(1),
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE users SET balance = balance - \\$1, withdrawn = withdrawn \\+ \\$1 WHERE id = \\$2 AND balance >= \\$1").
+				WithArgs(i.Sum, uid).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectQuery("INSERT INTO withdraw_history \\(order_number, user_id, amount, processed_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\) RETURNING id").
+				WithArgs(i.OrderNumber, uid, i.Sum, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+
+			mock.ExpectQuery("SELECT id, remaining, credited_at FROM accrual_lots (.+) FOR UPDATE").
+				WithArgs(uid).WillReturnRows(sqlmock.NewRows([]string{"id", "remaining", "credited_at"}))
+
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindWithdrawal, i.OrderNumber, sqlmock.AnyArg()).WillReturnError(errors.New("some error"))
+
+			mock.ExpectRollback()
+
+			err := repo.Withdraw(context.Background(), i, uid)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("Transfer moves points and lots to the recipient", func() {
+			uid, recipientID := 1, 2
+			i := model.TransferInput{Login: "family", Sum: decimal.NewFromInt(3)}
+			since := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+			older := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			newer := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE login = \\$1").
+				WithArgs(i.Login).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(recipientID))
+			mock.ExpectExec("SELECT id FROM users WHERE id IN \\(\\$1, \\$2\\) ORDER BY id FOR UPDATE").
+				WithArgs(uid, recipientID).WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM transfer_history (.+)").
+				WithArgs(uid, model.TransferDirectionOut, since.Local().Format(time.RFC3339)).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("7"))
+			mock.ExpectExec("UPDATE users SET balance = balance - \\$1 WHERE id = \\$2 AND balance >= \\$1").
+				WithArgs(i.Sum, uid).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE users SET balance = balance \\+ \\$1 WHERE id = \\$2").
+				WithArgs(i.Sum, recipientID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mock.ExpectQuery("SELECT id, remaining, credited_at FROM accrual_lots (.+) FOR UPDATE").
+				WithArgs(uid).WillReturnRows(sqlmock.NewRows([]string{"id", "remaining", "credited_at"}).AddRow(1, "1", older).AddRow(2, "5", newer))
+			mock.ExpectExec("UPDATE accrual_lots SET remaining (.+)").WithArgs(decimal.NewFromInt(1), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE accrual_lots SET remaining (.+)").WithArgs(decimal.NewFromInt(2), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+			// the recipient gets the lots with their credit time
+			mock.ExpectExec("INSERT INTO accrual_lots \\(user_id, amount, remaining, credited_at\\) VALUES \\(\\$1, \\$2, \\$2, \\$3\\)").
+				WithArgs(recipientID, decimal.NewFromInt(1), older).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO accrual_lots (.+)").
+				WithArgs(recipientID, decimal.NewFromInt(2), newer).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectExec("INSERT INTO transfer_history (.+)").
+				WithArgs(uid, recipientID, model.TransferDirectionOut, i.Sum, sqlmock.AnyArg(), model.TransferDirectionIn).WillReturnResult(sqlmock.NewResult(1, 2))
+			for _, leg := range []struct {
+				id     int
+				amount decimal.Decimal
+			}{{uid, i.Sum.Neg()}, {recipientID, i.Sum}} {
+				mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+					WithArgs(model.LedgerKindTransfer, "", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectExec("INSERT INTO ledger_entries (.+)").
+					WithArgs(1, model.LedgerAccountUser, leg.id, leg.amount, model.LedgerAccountTransfers, leg.amount.Neg()).WillReturnResult(sqlmock.NewResult(1, 2))
 			}
+			mock.ExpectCommit()
 
+			err := repo.Transfer(context.Background(), i, uid, model.TransferLimit{Amount: decimal.NewFromInt(10), Since: since})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("Transfer compares the start of the UTC day in local time", func() {
+			local := time.Local
+			time.Local = time.FixedZone("UTC+5", 5*60*60)
+			defer func() { time.Local = local }()
+
+			i := model.TransferInput{Login: "family", Sum: decimal.NewFromInt(4)}
+			since := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE login = \\$1").
+				WithArgs(i.Login).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			mock.ExpectExec("SELECT id FROM users (.+) FOR UPDATE").WillReturnResult(sqlmock.NewResult(0, 2))
+			// transfers are stored with the local time, the UTC midnight is 05:00 of the local day
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM transfer_history (.+)").
+				WithArgs(1, model.TransferDirectionOut, "2026-10-17T05:00:00+05:00").WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("7"))
+			mock.ExpectRollback()
+
+			err := repo.Transfer(context.Background(), i, 1, model.TransferLimit{Amount: decimal.NewFromInt(10), Since: since})
+			Expect(err).Should(Equal(internal.ErrTransferLimitExceeded))
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("Transfer above the daily limit", func() {
+			i := model.TransferInput{Login: "family", Sum: decimal.NewFromInt(4)}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE login = \\$1").
+				WithArgs(i.Login).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			mock.ExpectExec("SELECT id FROM users (.+) FOR UPDATE").WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM transfer_history (.+)").
+				WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("7"))
+			mock.ExpectRollback()
+
+			err := repo.Transfer(context.Background(), i, 1, model.TransferLimit{Amount: decimal.NewFromInt(10), Since: time.Now()})
+			Expect(err).Should(Equal(internal.ErrTransferLimitExceeded))
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("Transfer with insufficient funds", func() {
+			i := model.TransferInput{Login: "family", Sum: decimal.NewFromInt(4)}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE login = \\$1").
+				WithArgs(i.Login).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			mock.ExpectExec("SELECT id FROM users (.+) FOR UPDATE").WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec("UPDATE users SET balance = balance - (.+)").
+				WithArgs(i.Sum, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			err := repo.Transfer(context.Background(), i, 1, model.TransferLimit{})
+			Expect(err).Should(Equal(internal.ErrInsufficientFunds))
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("Transfer to unknown login", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE login = \\$1").
+				WithArgs("nobody").WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			err := repo.Transfer(context.Background(), model.TransferInput{Login: "nobody", Sum: decimal.NewFromInt(1)}, 1, model.TransferLimit{})
+			Expect(err).Should(Equal(internal.ErrRecipientNotFound))
+		})
+		It("Transfer to the sender", func() {
 			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE login = \\$1").
+				WithArgs("me").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectRollback()
 
-			mock.ExpectExec("INSERT INTO withdraw_history \\(order_number, user_id, amount, processed_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").
-				WithArgs(i.OrderNumber, uid, i.Sum, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			err := repo.Transfer(context.Background(), model.TransferInput{Login: "me", Sum: decimal.NewFromInt(1)}, 1, model.TransferLimit{})
+			Expect(err).Should(Equal(internal.ErrSelfTransfer))
+		})
+		It("GetTransferHistory without error", func() {
+			uid := 1
+			processedAt := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+			mock.ExpectQuery("SELECT id, direction, login, amount, processed_at FROM (.+) WHERE user_id = \\$1 ORDER BY processed_at, id LIMIT \\$2").
+				WithArgs(uid, 101).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "direction", "login", "amount", "processed_at"}).
+					AddRow(1, model.TransferDirectionOut, "family", "3", processedAt).
+					AddRow(2, model.TransferDirectionIn, "family", "1", processedAt))
+
+			page, err := repo.GetTransferHistory(context.Background(), uid, model.PageQuery{Limit: 100})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(page.Transfers).Should(HaveLen(2))
+			Expect(page.Transfers[1].Direction).Should(Equal(model.TransferDirectionIn))
+			Expect(page.Next).Should(BeNil())
+		})
+		It("ReverseWithdrawal returns the rest of the withdrawal", func() {
+			orderNumber := "2377225624"
+			withdrawn := decimal.NewFromInt(10)
+			rest := decimal.NewFromInt(6)
+			older := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			newer := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, user_id, amount FROM withdraw_history WHERE order_number = \\$1 FOR UPDATE").
+				WithArgs(orderNumber).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).AddRow(5, 1, withdrawn))
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM withdrawal_reversals WHERE withdrawal_id = \\$1").
+				WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("4"))
+			mock.ExpectQuery("INSERT INTO withdrawal_reversals (.+) RETURNING id").
+				WithArgs(5, rest, "cancelled", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+			mock.ExpectExec("UPDATE users SET balance = balance \\+ \\$1, withdrawn = withdrawn - \\$1 WHERE id = \\$2").
+				WithArgs(rest, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			// the last consumed lot comes back first, the points keep the credit time of their lots
+			mock.ExpectQuery("SELECT id, remaining, credited_at FROM withdrawal_lots WHERE withdrawal_id = \\$1 AND remaining > 0 ORDER BY credited_at DESC, id DESC").
+				WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id", "remaining", "credited_at"}).AddRow(2, "4", newer).AddRow(1, "6", older))
+			mock.ExpectExec("UPDATE withdrawal_lots SET remaining = remaining - \\$1 WHERE id = \\$2").
+				WithArgs(decimal.NewFromInt(4), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE withdrawal_lots SET remaining = remaining - \\$1 WHERE id = \\$2").
+				WithArgs(decimal.NewFromInt(2), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO accrual_lots (.+)").
+				WithArgs(1, orderNumber, decimal.NewFromInt(4), newer).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO accrual_lots (.+)").
+				WithArgs(1, orderNumber, decimal.NewFromInt(2), older).WillReturnResult(sqlmock.NewResult(2, 1))
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindReversal, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectExec("INSERT INTO ledger_entries (.+)").
+				WithArgs(1, model.LedgerAccountUser, 1, rest, model.LedgerAccountWithdrawals, rest.Neg()).WillReturnResult(sqlmock.NewResult(1, 2))
+			mock.ExpectCommit()
 
-			mock.ExpectExec("UPDATE users SET balance = \\$1, withdrawn = \\$2 WHERE id = \\$3").
-				WithArgs(bw.Balance, bw.Withdrawn, uid).WillReturnError(errors.New("some error"))
+			out, err := repo.ReverseWithdrawal(context.Background(), model.ReversalInput{OrderNumber: orderNumber, Reason: "cancelled"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(out.ID).Should(Equal(3))
+			Expect(out.UserID).Should(Equal(1))
+			Expect(out.Sum.Equal(rest)).Should(BeTrue())
+			Expect(out.Reversed.Equal(withdrawn)).Should(BeTrue())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("ReverseWithdrawal credits now the points of a withdrawal without kept lots", func() {
+			orderNumber := "2377225624"
+			sum := decimal.NewFromInt(3)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, user_id, amount FROM withdraw_history (.+)").
+				WithArgs(orderNumber).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).AddRow(5, 1, "10"))
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM withdrawal_reversals (.+)").
+				WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+			mock.ExpectQuery("INSERT INTO withdrawal_reversals (.+) RETURNING id").
+				WithArgs(5, sum, "", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectExec("UPDATE users SET balance (.+)").
+				WithArgs(sum, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("SELECT id, remaining, credited_at FROM withdrawal_lots (.+)").
+				WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id", "remaining", "credited_at"}))
+			mock.ExpectExec("INSERT INTO accrual_lots (.+)").
+				WithArgs(1, orderNumber, sum, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindReversal, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectExec("INSERT INTO ledger_entries (.+)").
+				WithArgs(1, model.LedgerAccountUser, 1, sum, model.LedgerAccountWithdrawals, sum.Neg()).WillReturnResult(sqlmock.NewResult(1, 2))
+			mock.ExpectCommit()
 
-			err := repo.Withdraw(context.Background(), i, bw, uid)
+			_, err := repo.ReverseWithdrawal(context.Background(), model.ReversalInput{OrderNumber: orderNumber, Sum: &sum})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("ReverseWithdrawal above the rest of the withdrawal", func() {
+			sum := decimal.NewFromInt(7)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, user_id, amount FROM withdraw_history (.+)").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).AddRow(5, 1, "10"))
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM withdrawal_reversals (.+)").
+				WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("4"))
+			mock.ExpectRollback()
+
+			_, err := repo.ReverseWithdrawal(context.Background(), model.ReversalInput{OrderNumber: "2377225624", Sum: &sum})
+			Expect(err).Should(Equal(internal.ErrReversalExceedsWithdrawal))
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("ReverseWithdrawal of a fully reversed withdrawal", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, user_id, amount FROM withdraw_history (.+)").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).AddRow(5, 1, "10"))
+			mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM withdrawal_reversals (.+)").
+				WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("10"))
+			mock.ExpectRollback()
+
+			_, err := repo.ReverseWithdrawal(context.Background(), model.ReversalInput{OrderNumber: "2377225624"})
+			Expect(err).Should(Equal(internal.ErrReversalExceedsWithdrawal))
+		})
+		It("ReverseWithdrawal of unknown order", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, user_id, amount FROM withdraw_history (.+)").
+				WithArgs("1").WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			_, err := repo.ReverseWithdrawal(context.Background(), model.ReversalInput{OrderNumber: "1"})
+			Expect(err).Should(Equal(internal.ErrWithdrawalNotFound))
+		})
+		It("MakeAccrual without error", func() {
+			uid := 1
+			status := "PROCESSED"
+			orderNumber := "100"
+			accrual := decimal.NewFromInt(1)
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3 AND status NOT IN \\(\\$4, \\$5\\)").
+				WithArgs(status, accrual, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectQuery("UPDATE referrals SET rewarded_at = \\$1 WHERE referee_id = \\$2 AND rewarded_at IS NULL (.+)").
+				WithArgs(sqlmock.AnyArg(), uid).WillReturnRows(sqlmock.NewRows([]string{"referrer_id", "referrer_bonus", "referee_bonus"}))
+
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindAccrual, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+			mock.ExpectExec("INSERT INTO ledger_entries (.+) VALUES (.+)").
+				WithArgs(1, model.LedgerAccountUser, uid, accrual, model.LedgerAccountAccruals, accrual.Neg()).WillReturnResult(sqlmock.NewResult(1, 2))
+
+			mock.ExpectExec("UPDATE users SET balance = balance \\+ \\$1 WHERE id = \\$2").
+				WithArgs(accrual, uid).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectExec("INSERT INTO accrual_lots \\(user_id, order_number, amount, remaining, credited_at\\) VALUES \\(\\$1, NULLIF\\(\\$2, ''\\), \\$3, \\$3, \\$4\\)").
+				WithArgs(uid, orderNumber, accrual, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectCommit()
+
+			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, decimal.Zero)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("MakeAccrual posts the tier bonus apart from the accrual", func() {
+			uid := 1
+			orderNumber := "100"
+			accrual := decimal.NewFromInt(100)
+			tierBonus := decimal.NewFromInt(25)
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = (.+)").
+				WithArgs(model.OrderStatusProcessed, accrual.Add(tierBonus), orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("UPDATE referrals SET rewarded_at (.+)").
+				WithArgs(sqlmock.AnyArg(), uid).WillReturnRows(sqlmock.NewRows([]string{"referrer_id", "referrer_bonus", "referee_bonus"}))
+			for _, p := range []struct {
+				kind, account string
+				amount        decimal.Decimal
+			}{
+				{model.LedgerKindAccrual, model.LedgerAccountAccruals, accrual},
+				{model.LedgerKindTierBonus, model.LedgerAccountTierBonuses, tierBonus},
+			} {
+				mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+					WithArgs(p.kind, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectExec("INSERT INTO ledger_entries (.+)").
+					WithArgs(1, model.LedgerAccountUser, uid, p.amount, p.account, p.amount.Neg()).WillReturnResult(sqlmock.NewResult(1, 2))
+				mock.ExpectExec("UPDATE users SET balance (.+)").WithArgs(p.amount, uid).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO accrual_lots (.+)").WithArgs(uid, orderNumber, p.amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			mock.ExpectCommit()
+
+			err := repo.MakeAccrual(context.Background(), uid, model.OrderStatusProcessed, orderNumber, accrual, tierBonus)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("MakeAccrual of the first processed order rewards the referral", func() {
+			uid := 8
+			orderNumber := "100"
+			accrual := decimal.NewFromInt(1)
+			bonus := decimal.NewFromInt(100)
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = (.+)").
+				WithArgs(model.OrderStatusProcessed, accrual, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("UPDATE referrals SET rewarded_at (.+) RETURNING referrer_id, referrer_bonus, referee_bonus").
+				WithArgs(sqlmock.AnyArg(), uid).WillReturnRows(sqlmock.NewRows([]string{"referrer_id", "referrer_bonus", "referee_bonus"}).AddRow(7, bonus, bonus))
+			// both users are locked before the credits, the order of ids is kept by the query
+			mock.ExpectExec("SELECT id FROM users WHERE id IN \\(\\$1, \\$2\\) ORDER BY id FOR UPDATE").
+				WithArgs(uid, 7).WillReturnResult(sqlmock.NewResult(0, 2))
+			for _, id := range []int{uid, 7} {
+				mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+					WithArgs(model.LedgerKindReferral, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectExec("INSERT INTO ledger_entries (.+)").
+					WithArgs(2, model.LedgerAccountUser, id, bonus, model.LedgerAccountReferrals, bonus.Neg()).WillReturnResult(sqlmock.NewResult(1, 2))
+				mock.ExpectExec("UPDATE users SET balance (.+)").WithArgs(bonus, id).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO accrual_lots (.+)").WithArgs(id, orderNumber, bonus, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindAccrual, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectExec("INSERT INTO ledger_entries (.+)").WillReturnResult(sqlmock.NewResult(1, 2))
+			mock.ExpectExec("UPDATE users SET balance (.+)").WithArgs(accrual, uid).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO accrual_lots (.+)").WithArgs(uid, orderNumber, accrual, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectCommit()
+
+			err := repo.MakeAccrual(context.Background(), uid, model.OrderStatusProcessed, orderNumber, accrual, decimal.Zero)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("MakeAccrual doesn't credit the capped referrer", func() {
+			uid := 8
+			orderNumber := "100"
+			bonus := decimal.NewFromInt(100)
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = (.+)").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("UPDATE referrals SET rewarded_at (.+)").
+				WithArgs(sqlmock.AnyArg(), uid).WillReturnRows(sqlmock.NewRows([]string{"referrer_id", "referrer_bonus", "referee_bonus"}).AddRow(7, decimal.Zero, bonus))
+			mock.ExpectExec("SELECT id FROM users (.+) FOR UPDATE").WithArgs(uid, 7).WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindReferral, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			mock.ExpectExec("INSERT INTO ledger_entries (.+)").
+				WithArgs(2, model.LedgerAccountUser, uid, bonus, model.LedgerAccountReferrals, bonus.Neg()).WillReturnResult(sqlmock.NewResult(1, 2))
+			mock.ExpectExec("UPDATE users SET balance (.+)").WithArgs(bonus, uid).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO accrual_lots (.+)").WithArgs(uid, orderNumber, bonus, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			err := repo.MakeAccrual(context.Background(), uid, model.OrderStatusProcessed, orderNumber, decimal.Zero, decimal.Zero)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+		It("GetReferrals without error", func() {
+			uid := 7
+			registeredAt := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+			mock.ExpectQuery("SELECT referral_code FROM users WHERE id = \\$1").
+				WithArgs(uid).WillReturnRows(sqlmock.NewRows([]string{"referral_code"}).AddRow("CODE"))
+			mock.ExpectQuery("SELECT id, login, created_at, status, bonus FROM (.+) WHERE referrer_id = \\$1 ORDER BY created_at, id LIMIT \\$5").
+				WithArgs(uid, model.ReferralStatusCapped, model.ReferralStatusPending, model.ReferralStatusRewarded, 2).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "login", "created_at", "status", "bonus"}).
+					AddRow(1, "first", registeredAt, model.ReferralStatusRewarded, decimal.NewFromInt(100)).
+					AddRow(2, "second", registeredAt, model.ReferralStatusPending, decimal.NewFromInt(100)))
+
+			page, err := repo.GetReferrals(context.Background(), uid, model.PageQuery{Limit: 1})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(page.Code).Should(Equal("CODE"))
+			Expect(page.Referrals).Should(HaveLen(1))
+			Expect(page.Referrals[0].Status).Should(Equal(model.ReferralStatusRewarded))
+			Expect(page.Next).Should(Equal(&model.Cursor{Time: registeredAt, ID: 1}))
+		})
+		It("MakeAccrual with error", func() {
+			uid := 1
+			status := "PROCESSED"
+			orderNumber := "100"
+			accrual := decimal.NewFromInt(1)
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3 AND status NOT IN \\(\\$4, \\$5\\)").
+				WithArgs(status, accrual, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnError(errors.New("some error"))
+
+			mock.ExpectRollback()
+
+			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, decimal.Zero)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("MakeAccrual with ledger error", func() {
+			uid := 1
+			status := "PROCESSED"
+			orderNumber := "100"
+			accrual := decimal.NewFromInt(1)
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3 AND status NOT IN \\(\\$4, \\$5\\)").
+				WithArgs(status, accrual, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectQuery("UPDATE referrals SET rewarded_at (.+)").
+				WithArgs(sqlmock.AnyArg(), uid).WillReturnRows(sqlmock.NewRows([]string{"referrer_id", "referrer_bonus", "referee_bonus"}))
+
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindAccrual, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+			mock.ExpectExec("INSERT INTO ledger_entries (.+) VALUES (.+)").
+				WithArgs(1, model.LedgerAccountUser, uid, accrual, model.LedgerAccountAccruals, accrual.Neg()).WillReturnError(errors.New("some error"))
+
+			mock.ExpectRollback()
+
+			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, decimal.Zero)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("MakeAccrual with other error", func() {
+			uid := 1
+			status := "PROCESSED"
+			orderNumber := "100"
+			accrual := decimal.NewFromInt(1)
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3 AND status NOT IN \\(\\$4, \\$5\\)").
+				WithArgs(status, accrual, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectQuery("UPDATE referrals SET rewarded_at (.+)").
+				WithArgs(sqlmock.AnyArg(), uid).WillReturnRows(sqlmock.NewRows([]string{"referrer_id", "referrer_bonus", "referee_bonus"}))
+
+			mock.ExpectQuery("INSERT INTO ledger_transactions (.+) RETURNING id").
+				WithArgs(model.LedgerKindAccrual, orderNumber, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+			mock.ExpectExec("INSERT INTO ledger_entries (.+) VALUES (.+)").
+				WithArgs(1, model.LedgerAccountUser, uid, accrual, model.LedgerAccountAccruals, accrual.Neg()).WillReturnResult(sqlmock.NewResult(1, 2))
+
+			mock.ExpectExec("UPDATE users SET balance = balance \\+ \\$1 WHERE id = \\$2").
+				WithArgs(accrual, uid).WillReturnError(errors.New("some error"))
+
+			mock.ExpectRollback()
+
+			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, decimal.Zero)
+			Expect(err).Should(HaveOccurred())
+		})
+		It("MakeAccrual without accrual", func() {
+			uid := 1
+			status := "INVALID"
+			orderNumber := "100"
+			accrual := decimal.Zero
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3 AND status NOT IN \\(\\$4, \\$5\\)").
+				WithArgs(status, accrual, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectCommit()
+
+			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, decimal.Zero)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("MakeAccrual of already processed order", func() {
+			uid := 1
+			status := "PROCESSED"
+			orderNumber := "100"
+			accrual := decimal.NewFromInt(1)
+
+			mock.ExpectBegin()
+
+			mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3 AND status NOT IN \\(\\$4, \\$5\\)").
+				WithArgs(status, accrual, orderNumber, model.OrderStatusInvalid, model.OrderStatusProcessed).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mock.ExpectCommit()
+
+			err := repo.MakeAccrual(context.Background(), uid, status, orderNumber, accrual, decimal.Zero)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("WithTx commits", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("DELETE FROM accrual_jobs").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err := repo.(internal.Repository).WithTx(context.Background(), func(tx *sql.Tx) error {
+				_, err := tx.Exec("DELETE FROM accrual_jobs")
+				return err
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("WithTx rolls back on error", func() {
+			e := errors.New("some error")
+
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			err := repo.(internal.Repository).WithTx(context.Background(), func(tx *sql.Tx) error {
+				return e
+			})
+			Expect(err).Should(Equal(e))
+		})
+		It("WithTx rolls back on panic", func() {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			Expect(func() {
+				_ = repo.(internal.Repository).WithTx(context.Background(), func(tx *sql.Tx) error {
+					panic("some panic")
+				})
+			}).Should(PanicWith("some panic"))
+		})
+		It("WithTx with begin error", func() {
+			mock.ExpectBegin().WillReturnError(errors.New("some error"))
+
+			called := false
+			err := repo.(internal.Repository).WithTx(context.Background(), func(tx *sql.Tx) error {
+				called = true
+				return nil
+			})
+			Expect(err).Should(HaveOccurred())
+			Expect(called).Should(BeFalse())
+		})
+		It("WithTx honours the context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := repo.(internal.Repository).WithTx(ctx, func(tx *sql.Tx) error {
+				return nil
+			})
+			Expect(err).Should(MatchError(context.Canceled))
+		})
+		It("CreateSession without error", func() {
+			s := model.Session{ID: "sid", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+
+			mock.ExpectExec("INSERT INTO sessions").
+				WithArgs(s.ID, s.UserID, "hash", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := repo.CreateSession(context.Background(), s, "hash")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("GetSessionByRefreshToken without error", func() {
+			expiresAt := time.Now().Add(time.Hour)
+			expectedRows := sqlmock.NewRows([]string{"id", "user_id", "expires_at", "revoked"}).AddRow("sid", 1, expiresAt, true)
+
+			mock.ExpectQuery("SELECT (.+) FROM sessions WHERE refresh_token_hash = \\$1").
+				WithArgs("hash").WillReturnRows(expectedRows)
+
+			s, err := repo.GetSessionByRefreshToken(context.Background(), "hash")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(s).Should(Equal(model.Session{ID: "sid", UserID: 1, ExpiresAt: expiresAt, Revoked: true}))
+		})
+		It("GetSessionByRefreshToken with error no records", func() {
+			mock.ExpectQuery("SELECT (.+) FROM sessions WHERE refresh_token_hash = \\$1").
+				WithArgs("hash").WillReturnError(sql.ErrNoRows)
+
+			_, err := repo.GetSessionByRefreshToken(context.Background(), "hash")
+			Expect(err).Should(Equal(internal.ErrNoRecords))
+		})
+		It("RotateRefreshToken without error", func() {
+			mock.ExpectExec("UPDATE sessions SET refresh_token_hash = \\$1, expires_at = \\$2 WHERE id = \\$3 AND refresh_token_hash = \\$4").
+				WithArgs("new", sqlmock.AnyArg(), "sid", "old").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			err := repo.RotateRefreshToken(context.Background(), "sid", "old", "new", time.Now().Add(time.Hour))
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("RotateRefreshToken with error token already rotated", func() {
+			mock.ExpectExec("UPDATE sessions SET refresh_token_hash = \\$1, expires_at = \\$2 WHERE id = \\$3 AND refresh_token_hash = \\$4").
+				WithArgs("new", sqlmock.AnyArg(), "sid", "old").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			err := repo.RotateRefreshToken(context.Background(), "sid", "old", "new", time.Now().Add(time.Hour))
+			Expect(err).Should(Equal(internal.ErrInvalidToken))
+		})
+		It("IsSessionActive without error", func() {
+			mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM sessions WHERE id = \\$1 (.+)\\)").
+				WithArgs("sid").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+			active, err := repo.IsSessionActive(context.Background(), "sid")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(active).Should(BeTrue())
+		})
+		It("RevokeSession without error", func() {
+			mock.ExpectExec("UPDATE sessions SET revoked_at = \\$1 WHERE id = \\$2").
+				WithArgs(sqlmock.AnyArg(), "sid").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			err := repo.RevokeSession(context.Background(), "sid")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("RevokeUserSessions without error", func() {
+			mock.ExpectExec("UPDATE sessions SET revoked_at = \\$1 WHERE user_id = \\$2").
+				WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 3))
+
+			err := repo.RevokeUserSessions(context.Background(), 1)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("GetMigrationVersion skips rolled back migrations", func() {
+			rows := sqlmock.NewRows([]string{"version_id", "is_applied"}).
+				AddRow(3, false).
+				AddRow(3, true).
+				AddRow(2, true).
+				AddRow(1, true)
+
+			mock.ExpectQuery("SELECT version_id, is_applied FROM goose_db_version ORDER BY id DESC").
+				WillReturnRows(rows).RowsWillBeClosed()
+
+			version, err := repo.GetMigrationVersion(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(version).Should(Equal(int64(2)))
+		})
+		It("GetMigrationVersion with error", func() {
+			mock.ExpectQuery("SELECT version_id, is_applied FROM goose_db_version").
+				WillReturnError(errors.New("relation does not exist"))
+
+			_, err := repo.GetMigrationVersion(context.Background())
 			Expect(err).Should(HaveOccurred())
 		})
+		It("CountAccrualJobs without error", func() {
+			mock.ExpectQuery("SELECT count\\(\\*\\) FROM accrual_jobs").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+
+			n, err := repo.CountAccrualJobs(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(n).Should(Equal(12))
+		})
+		It("GetPendingOrderStats without error", func() {
+			local := time.Local
+			time.Local = time.FixedZone("UTC+5", 5*60*60)
+			defer func() { time.Local = local }()
+
+			// uploaded_at keeps the wall clock of the server, it is read in the local time, not in UTC
+			t := time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+			rows := sqlmock.NewRows([]string{"status", "count", "min"}).
+				AddRow(model.OrderStatusNew, 3, "2026-10-17 12:00:00").
+				AddRow(model.OrderStatusProcessing, 1, "2026-10-17 12:00:00")
+
+			mock.ExpectQuery("SELECT status, count\\(\\*\\), min\\(uploaded_at\\)::text FROM orders WHERE status IN \\(\\$1, \\$2, \\$3\\) GROUP BY status").
+				WithArgs(model.OrderStatusNew, model.OrderStatusRegistered, model.OrderStatusProcessing).
+				WillReturnRows(rows).RowsWillBeClosed()
+
+			stats, err := repo.GetPendingOrderStats(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(stats).Should(Equal([]model.PendingOrderStats{
+				{Status: model.OrderStatusNew, Count: 3, OldestUploadedAt: t},
+				{Status: model.OrderStatusProcessing, Count: 1, OldestUploadedAt: t},
+			}))
+		})
 	})
 })
@@ -2,12 +2,16 @@ package test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -33,25 +37,88 @@ var _ = Describe("Repository", func() {
 		rep = mock_internal.NewMockIRepository(ctrl)
 		acc = mock_internal.NewMockIAccrual(ctrl)
 
-		srv = internal.NewService(rep, acc, "secret", logger.Sugar())
+		srv = internal.NewService(rep, acc, internal.LoyaltyRules{}, "secret", logger.Sugar())
 	})
 	Context("Service tests", func() {
 		It("Login without error", func() {
 			ctx := context.Background()
 			l, p := "login", "pass"
-			h := internal.GetHash(p)
+			h, err := internal.HashPassword(p)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			rep.EXPECT().GetUserByLogin(ctx, l).Return(model.User{ID: 1, Login: l, Password: h}, nil)
+			rep.EXPECT().CreateSession(ctx, gomock.Any(), gomock.Any()).Return(nil)
+
+			t, err := srv.Login(ctx, l, p)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(t.AccessToken).ShouldNot(BeEmpty())
+			Expect(t.RefreshToken).ShouldNot(BeEmpty())
+		})
+		It("Login with legacy hash rehashes the password", func() {
+			ctx := context.Background()
+			l, p := "login", "pass"
+			legacy := base64.StdEncoding.EncodeToString(sha256.New().Sum([]byte(p)))
+
+			rep.EXPECT().GetUserByLogin(ctx, l).Return(model.User{ID: 1, Login: l, Password: legacy}, nil)
+			rep.EXPECT().UpdatePassword(ctx, 1, gomock.Any()).DoAndReturn(func(_ context.Context, _ int, h string) error {
+				Expect(bcrypt.CompareHashAndPassword([]byte(h), []byte(p))).Should(Succeed())
+				return nil
+			})
+			rep.EXPECT().CreateSession(ctx, gomock.Any(), gomock.Any()).Return(nil)
+
+			_, err := srv.Login(ctx, l, p)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("Login with legacy hash of a password longer than bcrypt accepts", func() {
+			ctx := context.Background()
+			l, p := "login", strings.Repeat("long password ", 8)
+			legacy := base64.StdEncoding.EncodeToString(sha256.New().Sum([]byte(p)))
 
-			rep.EXPECT().CheckCredentials(ctx, l, h).Return(1, nil)
+			var stored string
+			rep.EXPECT().GetUserByLogin(ctx, l).Return(model.User{ID: 1, Login: l, Password: legacy}, nil)
+			rep.EXPECT().UpdatePassword(ctx, 1, gomock.Any()).DoAndReturn(func(_ context.Context, _ int, h string) error {
+				stored = h
+				return nil
+			})
+			rep.EXPECT().CreateSession(ctx, gomock.Any(), gomock.Any()).Return(nil).Times(2)
 
 			_, err := srv.Login(ctx, l, p)
 			Expect(err).ShouldNot(HaveOccurred())
+
+			// the next login checks the new bcrypt hash and doesn't rehash again
+			rep.EXPECT().GetUserByLogin(ctx, l).Return(model.User{ID: 1, Login: l, Password: stored}, nil)
+			_, err = srv.Login(ctx, l, p)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			rep.EXPECT().GetUserByLogin(ctx, l).Return(model.User{ID: 1, Login: l, Password: stored}, nil)
+			_, err = srv.Login(ctx, l, p[:len(p)-1])
+			Expect(err).Should(Equal(internal.ErrInvalidCredentials))
+		})
+		It("Login with error wrong password", func() {
+			ctx := context.Background()
+			l, p := "login", "pass"
+			h, err := internal.HashPassword("other")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			rep.EXPECT().GetUserByLogin(ctx, l).Return(model.User{ID: 1, Login: l, Password: h}, nil)
+
+			_, err = srv.Login(ctx, l, p)
+			Expect(err).Should(Equal(internal.ErrInvalidCredentials))
+		})
+		It("Login with error unknown login", func() {
+			ctx := context.Background()
+			l, p := "login", "pass"
+
+			rep.EXPECT().GetUserByLogin(ctx, l).Return(model.User{}, internal.ErrNoRecords)
+
+			_, err := srv.Login(ctx, l, p)
+			Expect(err).Should(Equal(internal.ErrInvalidCredentials))
 		})
 		It("Login with error", func() {
 			ctx := context.Background()
 			l, p := "login", "pass"
-			h := internal.GetHash(p)
 
-			rep.EXPECT().CheckCredentials(ctx, l, h).Return(0, errors.New("some error"))
+			rep.EXPECT().GetUserByLogin(ctx, l).Return(model.User{}, errors.New("some error"))
 
 			_, err := srv.Login(ctx, l, p)
 			Expect(err).Should(HaveOccurred())
@@ -59,12 +126,120 @@ var _ = Describe("Repository", func() {
 		It("Register without error", func() {
 			ctx := context.Background()
 			l, p := "login", "pass"
-			h := internal.GetHash(p)
 
 			rep.EXPECT().IsUserExist(ctx, l).Return(false, nil)
-			rep.EXPECT().Register(ctx, l, h)
+			rep.EXPECT().Register(ctx, l, gomock.Any(), gomock.Any(), nil).DoAndReturn(func(_ context.Context, _ string, h string, code string, _ *model.ReferralTerms) (int, error) {
+				Expect(code).Should(HaveLen(8))
+				Expect(h).ShouldNot(Equal(p))
+				Expect(bcrypt.CompareHashAndPassword([]byte(h), []byte(p))).Should(Succeed())
+				return 1, nil
+			})
+			rep.EXPECT().CreateSession(ctx, gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, s model.Session, h string) error {
+				Expect(s.UserID).Should(Equal(1))
+				Expect(s.ID).ShouldNot(BeEmpty())
+				Expect(h).ShouldNot(BeEmpty())
+				return nil
+			})
+
+			t, err := srv.Register(ctx, l, p, "")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(t.RefreshToken).ShouldNot(BeEmpty())
+		})
+		It("Register with a password longer than bcrypt accepts", func() {
+			ctx := context.Background()
+			l, p := "login", strings.Repeat("long password ", 8)
+
+			rep.EXPECT().IsUserExist(ctx, l).Return(false, nil)
+			rep.EXPECT().Register(ctx, l, gomock.Any(), gomock.Any(), nil).Return(1, nil)
+			rep.EXPECT().CreateSession(ctx, gomock.Any(), gomock.Any()).Return(nil)
+
+			_, err := srv.Register(ctx, l, p, "")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("RefreshTokens without error", func() {
+			ctx := context.Background()
+			rt := "refresh"
+			session := model.Session{ID: "sid", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+
+			rep.EXPECT().GetSessionByRefreshToken(ctx, gomock.Any()).Return(session, nil)
+			rep.EXPECT().RotateRefreshToken(ctx, "sid", gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _, oldHash, newHash string, _ time.Time) error {
+				Expect(oldHash).ShouldNot(Equal(newHash))
+				return nil
+			})
+
+			t, err := srv.RefreshTokens(ctx, rt)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(t.AccessToken).ShouldNot(BeEmpty())
+			Expect(t.RefreshToken).ShouldNot(Equal(rt))
+		})
+		It("RefreshTokens with error unknown token", func() {
+			ctx := context.Background()
+
+			rep.EXPECT().GetSessionByRefreshToken(ctx, gomock.Any()).Return(model.Session{}, internal.ErrNoRecords)
+
+			_, err := srv.RefreshTokens(ctx, "refresh")
+			Expect(err).Should(Equal(internal.ErrInvalidToken))
+		})
+		It("RefreshTokens with error revoked session", func() {
+			ctx := context.Background()
+			session := model.Session{ID: "sid", UserID: 1, ExpiresAt: time.Now().Add(time.Hour), Revoked: true}
+
+			rep.EXPECT().GetSessionByRefreshToken(ctx, gomock.Any()).Return(session, nil)
+
+			_, err := srv.RefreshTokens(ctx, "refresh")
+			Expect(err).Should(Equal(internal.ErrInvalidToken))
+		})
+		It("RefreshTokens with error expired session", func() {
+			ctx := context.Background()
+			session := model.Session{ID: "sid", UserID: 1, ExpiresAt: time.Now().Add(-time.Hour)}
+
+			rep.EXPECT().GetSessionByRefreshToken(ctx, gomock.Any()).Return(session, nil)
+
+			_, err := srv.RefreshTokens(ctx, "refresh")
+			Expect(err).Should(Equal(internal.ErrInvalidToken))
+		})
+		It("RefreshTokens with error token already rotated", func() {
+			ctx := context.Background()
+			session := model.Session{ID: "sid", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
 
-			_, err := srv.Register(ctx, l, p)
+			rep.EXPECT().GetSessionByRefreshToken(ctx, gomock.Any()).Return(session, nil)
+			rep.EXPECT().RotateRefreshToken(ctx, "sid", gomock.Any(), gomock.Any(), gomock.Any()).Return(internal.ErrInvalidToken)
+
+			_, err := srv.RefreshTokens(ctx, "refresh")
+			Expect(err).Should(Equal(internal.ErrInvalidToken))
+		})
+		It("Logout without error", func() {
+			ctx := context.Background()
+
+			rep.EXPECT().RevokeSession(ctx, "sid").Return(nil)
+
+			err := srv.Logout(ctx, "sid")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("LogoutByRefreshToken revokes the expired session", func() {
+			ctx := context.Background()
+			session := model.Session{ID: "sid", UserID: 1, ExpiresAt: time.Now().Add(-time.Hour)}
+
+			rep.EXPECT().GetSessionByRefreshToken(ctx, gomock.Any()).Return(session, nil)
+			rep.EXPECT().RevokeSession(ctx, "sid").Return(nil)
+
+			err := srv.LogoutByRefreshToken(ctx, "refresh")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("LogoutByRefreshToken with error unknown token", func() {
+			ctx := context.Background()
+
+			rep.EXPECT().GetSessionByRefreshToken(ctx, gomock.Any()).Return(model.Session{}, internal.ErrNoRecords)
+
+			err := srv.LogoutByRefreshToken(ctx, "refresh")
+			Expect(err).Should(Equal(internal.ErrInvalidToken))
+		})
+		It("LogoutAll without error", func() {
+			ctx := context.Background()
+
+			rep.EXPECT().RevokeUserSessions(ctx, 1).Return(nil)
+
+			err := srv.LogoutAll(ctx, 1)
 			Expect(err).ShouldNot(HaveOccurred())
 		})
 		It("Register with error already registered", func() {
@@ -73,7 +248,7 @@ var _ = Describe("Repository", func() {
 
 			rep.EXPECT().IsUserExist(ctx, l).Return(true, nil)
 
-			_, err := srv.Register(ctx, l, p)
+			_, err := srv.Register(ctx, l, p, "")
 			Expect(err).Should(HaveOccurred())
 			Expect(err).Should(Equal(internal.ErrLoginIsAlreadyTaken))
 		})
@@ -91,9 +266,9 @@ var _ = Describe("Repository", func() {
 				UploadedAt: t,
 			}
 
-			rep.EXPECT().GetOrderByNumber(ctx, order.Number).Return(order, nil)
-			rep.EXPECT().SendOrder(ctx, order.Number, uid)
-			acc.EXPECT().SendToQueue(ctx, uid, order.Number)
+			rep.EXPECT().GetOrderByNumber(gomock.Any(), order.Number).Return(order, nil)
+			rep.EXPECT().SendOrder(gomock.Any(), order.Number, uid)
+			acc.EXPECT().Notify()
 
 			err := srv.SendOrder(ctx, order.Number, uid)
 			Expect(err).ShouldNot(HaveOccurred())
@@ -107,6 +282,14 @@ var _ = Describe("Repository", func() {
 			Expect(err).Should(HaveOccurred())
 			Expect(err).Should(Equal(internal.ErrLuhnInvalid))
 		})
+		It("SendOrder with error not numeric", func() {
+			ctx := context.Background()
+			uid := 1
+			orderNumber := "12a"
+
+			err := srv.SendOrder(ctx, orderNumber, uid)
+			Expect(err).Should(Equal(internal.ErrOrderNumberIsNotNumeric))
+		})
 		It("SendOrder with error already sent", func() {
 			ctx := context.Background()
 			uid := 1
@@ -121,7 +304,7 @@ var _ = Describe("Repository", func() {
 				UploadedAt: t,
 			}
 
-			rep.EXPECT().GetOrderByNumber(ctx, order.Number).Return(order, nil)
+			rep.EXPECT().GetOrderByNumber(gomock.Any(), order.Number).Return(order, nil)
 
 			err := srv.SendOrder(ctx, order.Number, uid)
 			Expect(err).Should(HaveOccurred())
@@ -141,7 +324,7 @@ var _ = Describe("Repository", func() {
 				UploadedAt: t,
 			}
 
-			rep.EXPECT().GetOrderByNumber(ctx, order.Number).Return(order, nil)
+			rep.EXPECT().GetOrderByNumber(gomock.Any(), order.Number).Return(order, nil)
 
 			err := srv.SendOrder(ctx, order.Number, uid)
 			Expect(err).Should(HaveOccurred())
@@ -150,21 +333,29 @@ var _ = Describe("Repository", func() {
 		It("GetOrders without error", func() {
 			ctx := context.Background()
 			uid := 1
-			o := make([]model.OrderOutput, 1)
+			o := model.OrdersPage{Orders: make([]model.OrderOutput, 1)}
 
-			rep.EXPECT().GetOrders(ctx, uid).Return(o, nil)
+			rep.EXPECT().GetOrders(ctx, uid, model.PageQuery{Limit: internal.DefaultPageLimit}).Return(o, nil)
 
-			_, err := srv.GetOrders(ctx, uid)
+			_, err := srv.GetOrders(ctx, uid, model.PageQuery{})
 			Expect(err).ShouldNot(HaveOccurred())
 		})
-		It("GetOrders with error", func() {
+		It("GetOrders limits page size", func() {
 			ctx := context.Background()
 			uid := 1
-			o := make([]model.OrderOutput, 0)
+			o := model.OrdersPage{Orders: make([]model.OrderOutput, 1)}
 
-			rep.EXPECT().GetOrders(ctx, uid).Return(o, nil)
+			rep.EXPECT().GetOrders(ctx, uid, model.PageQuery{Limit: internal.MaxPageLimit}).Return(o, nil)
+
+			_, err := srv.GetOrders(ctx, uid, model.PageQuery{Limit: internal.MaxPageLimit + 1})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("GetOrders with error", func() {
+			ctx := context.Background()
+			uid := 1
+			rep.EXPECT().GetOrders(ctx, uid, model.PageQuery{Limit: 10}).Return(model.OrdersPage{}, nil)
 
-			_, err := srv.GetOrders(ctx, uid)
+			_, err := srv.GetOrders(ctx, uid, model.PageQuery{Limit: 10})
 			Expect(err).Should(HaveOccurred())
 			Expect(err).Should(Equal(internal.ErrNoRecords))
 		})
@@ -196,6 +387,137 @@ var _ = Describe("Repository", func() {
 			Expect(err).Should(HaveOccurred())
 			Expect(err).Should(Equal(e))
 		})
+		It("GetBalanceByUserID with expiring points", func() {
+			ctx := context.Background()
+			uid := 1
+			srv = internal.NewService(rep, acc, internal.LoyaltyRules{PointsTTL: 12}, "secret", zap.NewNop().Sugar())
+
+			rep.EXPECT().GetBalanceByUserID(ctx, uid).Return(model.BalanceWithdrawn{Balance: decimal.NewFromInt(10)}, nil)
+			// lots credited earlier than 11 months ago expire within 30 days
+			rep.EXPECT().GetExpiringPoints(ctx, uid, gomock.Any()).DoAndReturn(func(_ context.Context, _ int, before time.Time) (decimal.Decimal, error) {
+				Expect(before).Should(BeTemporally("~", time.Now().Add(internal.ExpiringSoonWindow).AddDate(0, -12, 0), time.Minute))
+				return decimal.NewFromInt(4), nil
+			})
+
+			bw, err := srv.GetBalanceByUserID(ctx, uid)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(bw.Balance).Should(Equal(decimal.NewFromInt(10)))
+			Expect(bw.ExpiringSoon).Should(Equal(decimal.NewFromInt(4)))
+		})
+		It("GetTier reports progress to the next tier", func() {
+			ctx := context.Background()
+			uid := 1
+			oldest := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+			reviewAt := time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC)
+
+			rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{
+				Tier: model.TierSilver, ReviewAt: &reviewAt, Points: decimal.NewFromInt(1200), OldestAccrualAt: &oldest,
+			}, nil)
+
+			t, err := srv.GetTier(ctx, uid)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(t.Tier).Should(Equal(model.TierSilver))
+			Expect(t.Multiplier.String()).Should(Equal("1.1"))
+			Expect(t.NextTier).Should(Equal(model.TierGold))
+			Expect(t.NextTierPoints.String()).Should(Equal("5000"))
+			Expect(t.PointsToNextTier.String()).Should(Equal("3800"))
+			Expect(t.ReevaluatedAt.Time).Should(Equal(reviewAt))
+		})
+		It("GetTier reports the lower tier when accruals left the window without storing it", func() {
+			ctx := context.Background()
+			uid := 1
+			reviewAt := time.Now().Add(-time.Hour)
+
+			rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{
+				Tier: model.TierGold, ReviewAt: &reviewAt, Points: decimal.Zero,
+			}, nil)
+			// SetUserTier isn't expected, the tier review stores the change
+
+			t, err := srv.GetTier(ctx, uid)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(t.Tier).Should(Equal(model.TierBronze))
+			Expect(t.NextTier).Should(Equal(model.TierSilver))
+			Expect(t.ReevaluatedAt).Should(BeNil())
+		})
+		It("GetTier of the top tier has no next tier", func() {
+			ctx := context.Background()
+			uid := 1
+			oldest := time.Now().AddDate(0, -2, 0)
+			reviewAt := oldest.AddDate(0, internal.TierWindow, 0)
+
+			rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{
+				Tier: model.TierGold, ReviewAt: &reviewAt, Points: decimal.NewFromInt(7000), OldestAccrualAt: &oldest,
+			}, nil)
+
+			t, err := srv.GetTier(ctx, uid)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(t.NextTier).Should(BeEmpty())
+			Expect(t.PointsToNextTier).Should(BeNil())
+		})
+		It("GetTier of unknown user", func() {
+			ctx := context.Background()
+			uid := 1
+
+			rep.EXPECT().GetUserTier(ctx, uid, gomock.Any()).Return(model.TierState{}, internal.ErrNoRecords)
+
+			_, err := srv.GetTier(ctx, uid)
+			Expect(err).Should(Equal(internal.ErrUserNotFound))
+		})
+		It("Register by referral code passes the referral terms", func() {
+			ctx := context.Background()
+			bonus := decimal.NewFromInt(100)
+			srv = internal.NewService(rep, acc, internal.LoyaltyRules{ReferralBonus: bonus, ReferralCap: 10}, "secret", zap.NewNop().Sugar())
+
+			rep.EXPECT().IsUserExist(ctx, "login").Return(false, nil)
+			rep.EXPECT().Register(ctx, "login", gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _, _, _ string, terms *model.ReferralTerms) (int, error) {
+				Expect(terms.Code).Should(Equal("ABCDEFGH"))
+				Expect(terms.Bonus.Equal(bonus)).Should(BeTrue())
+				Expect(terms.Cap).Should(Equal(10))
+				Expect(terms.CapSince).Should(BeTemporally("~", time.Now().Add(-internal.ReferralCapWindow), time.Minute))
+				return 0, internal.ErrInvalidReferralCode
+			})
+
+			_, err := srv.Register(ctx, "login", "pass", " abcdefgh ")
+			Expect(err).Should(Equal(internal.ErrInvalidReferralCode))
+		})
+		It("GetReferrals masks logins of referees", func() {
+			ctx := context.Background()
+			uid := 1
+
+			rep.EXPECT().GetReferrals(ctx, uid, model.PageQuery{Limit: internal.DefaultPageLimit}).Return(model.ReferralsPage{
+				Code:      "ABCDEFGH",
+				Referrals: []model.ReferralOutput{{Login: "friend"}, {Login: "ab"}},
+			}, nil)
+
+			page, err := srv.GetReferrals(ctx, uid, model.PageQuery{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(page.Code).Should(Equal("ABCDEFGH"))
+			Expect(page.Referrals[0].Login).Should(Equal("f***d"))
+			Expect(page.Referrals[1].Login).Should(Equal("a***"))
+		})
+		It("Transfer passes the daily limit since the start of the UTC day", func() {
+			ctx := context.Background()
+			limit := decimal.NewFromInt(1000)
+			srv = internal.NewService(rep, acc, internal.LoyaltyRules{TransferDailyLimit: limit}, "secret", zap.NewNop().Sugar())
+			i := model.TransferInput{Login: "family", Sum: decimal.NewFromInt(10)}
+
+			rep.EXPECT().Transfer(gomock.Any(), i, 1, gomock.Any()).DoAndReturn(func(_ context.Context, _ model.TransferInput, _ int, l model.TransferLimit) error {
+				Expect(l.Amount.Equal(limit)).Should(BeTrue())
+				Expect(l.Since).Should(Equal(time.Now().UTC().Truncate(24 * time.Hour)))
+				return internal.ErrInsufficientFunds
+			})
+
+			err := srv.Transfer(ctx, i, 1)
+			Expect(err).Should(Equal(internal.ErrInsufficientFunds))
+		})
+		It("GetTransferHistory without transfers", func() {
+			ctx := context.Background()
+
+			rep.EXPECT().GetTransferHistory(ctx, 1, model.PageQuery{Limit: internal.DefaultPageLimit}).Return(model.TransfersPage{}, nil)
+
+			_, err := srv.GetTransferHistory(ctx, 1, model.PageQuery{})
+			Expect(err).Should(Equal(internal.ErrNoRecords))
+		})
 		It("Withdraw without error", func() {
 			ctx := context.Background()
 			uid := 1
@@ -204,18 +526,7 @@ var _ = Describe("Repository", func() {
 				Sum:         decimal.NewFromInt(10),
 			}
 
-			bw := model.BalanceWithdrawn{
-				Balance:   decimal.NewFromInt(10),
-				Withdrawn: decimal.NewFromInt(10),
-			}
-
-			newBw := model.BalanceWithdrawn{
-				Balance:   bw.Balance.Sub(i.Sum),
-				Withdrawn: bw.Withdrawn.Add(i.Sum),
-			}
-
-			rep.EXPECT().GetBalanceByUserID(ctx, uid).Return(bw, nil)
-			rep.EXPECT().Withdraw(ctx, i, newBw, uid).Return(nil)
+			rep.EXPECT().Withdraw(gomock.Any(), i, uid).Return(nil)
 
 			err := srv.Withdraw(ctx, i, uid)
 			Expect(err).ShouldNot(HaveOccurred())
@@ -240,12 +551,7 @@ var _ = Describe("Repository", func() {
 				Sum:         decimal.NewFromInt(100),
 			}
 
-			bw := model.BalanceWithdrawn{
-				Balance:   decimal.NewFromInt(10),
-				Withdrawn: decimal.NewFromInt(10),
-			}
-
-			rep.EXPECT().GetBalanceByUserID(ctx, uid).Return(bw, nil)
+			rep.EXPECT().Withdraw(gomock.Any(), i, uid).Return(internal.ErrInsufficientFunds)
 
 			err := srv.Withdraw(ctx, i, uid)
 			Expect(err).Should(HaveOccurred())
@@ -254,21 +560,19 @@ var _ = Describe("Repository", func() {
 		It("GetWithdrawHistory without error", func() {
 			ctx := context.Background()
 			uid := 1
-			i := make([]model.WithdrawOutput, 1)
+			i := model.WithdrawalsPage{Withdrawals: make([]model.WithdrawOutput, 1)}
 
-			rep.EXPECT().GetWithdrawHistory(ctx, uid).Return(i, nil)
+			rep.EXPECT().GetWithdrawHistory(ctx, uid, model.PageQuery{Limit: internal.DefaultPageLimit}).Return(i, nil)
 
-			_, err := srv.GetWithdrawHistory(ctx, uid)
+			_, err := srv.GetWithdrawHistory(ctx, uid, model.PageQuery{})
 			Expect(err).ShouldNot(HaveOccurred())
 		})
 		It("GetWithdrawHistory with error", func() {
 			ctx := context.Background()
 			uid := 1
-			i := make([]model.WithdrawOutput, 0)
-
-			rep.EXPECT().GetWithdrawHistory(ctx, uid).Return(i, nil)
+			rep.EXPECT().GetWithdrawHistory(ctx, uid, model.PageQuery{Limit: 10}).Return(model.WithdrawalsPage{}, nil)
 
-			_, err := srv.GetWithdrawHistory(ctx, uid)
+			_, err := srv.GetWithdrawHistory(ctx, uid, model.PageQuery{Limit: 10})
 			Expect(err).Should(HaveOccurred())
 			Expect(err).Should(Equal(internal.ErrNoRecords))
 		})
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+var _ = Describe("TierReview", func() {
+	var (
+		rep    *mock_internal.MockIRepository
+		logger *zap.SugaredLogger
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		logger = zap.NewNop().Sugar()
+		rep = mock_internal.NewMockIRepository(ctrl)
+	})
+
+	Context("TierReview tests", func() {
+		It("Review stores the lower tier when accruals left the window", func() {
+			now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+			reviewAt := now.Add(-time.Hour)
+			oldest := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+			nextReview := oldest.AddDate(0, internal.TierWindow, 0)
+			r := internal.NewTierReview(rep, time.Hour, context.Background(), logger)
+
+			rep.EXPECT().GetUsersForTierReview(gomock.Any(), now, 0, 100).Return([]int{1, 2}, nil)
+			rep.EXPECT().GetUserTier(gomock.Any(), 1, now.AddDate(0, -internal.TierWindow, 0)).Return(model.TierState{
+				Tier: model.TierGold, ReviewAt: &reviewAt, Points: decimal.Zero,
+			}, nil)
+			rep.EXPECT().SetUserTier(gomock.Any(), 1, model.TierChange{From: model.TierGold, To: model.TierBronze, Points: decimal.Zero}).Return(nil)
+			rep.EXPECT().GetUserTier(gomock.Any(), 2, gomock.Any()).Return(model.TierState{
+				Tier: model.TierSilver, ReviewAt: &reviewAt, Points: decimal.NewFromInt(1200), OldestAccrualAt: &oldest,
+			}, nil)
+			// the tier stays, only the time of the next review moves
+			rep.EXPECT().SetUserTier(gomock.Any(), 2, model.TierChange{
+				From: model.TierSilver, To: model.TierSilver, Points: decimal.NewFromInt(1200), ReviewAt: &nextReview,
+			}).Return(nil)
+
+			users, err := r.Review(context.Background(), now)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(users).Should(Equal(2))
+		})
+		It("Review goes on after the last user while batches are full", func() {
+			r := internal.NewTierReview(rep, time.Hour, context.Background(), logger)
+
+			full := make([]int, 100)
+			for i := range full {
+				full[i] = i + 1
+			}
+
+			gomock.InOrder(
+				rep.EXPECT().GetUsersForTierReview(gomock.Any(), gomock.Any(), 0, 100).Return(full, nil),
+				rep.EXPECT().GetUsersForTierReview(gomock.Any(), gomock.Any(), 100, 100).Return(nil, nil),
+			)
+			rep.EXPECT().GetUserTier(gomock.Any(), gomock.Any(), gomock.Any()).Return(model.TierState{Tier: model.TierBronze, Points: decimal.Zero}, nil).Times(100)
+
+			users, err := r.Review(context.Background(), time.Now())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(users).Should(Equal(100))
+		})
+		It("Review stops on error", func() {
+			r := internal.NewTierReview(rep, time.Hour, context.Background(), logger)
+
+			rep.EXPECT().GetUsersForTierReview(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]int{1, 2}, nil)
+			rep.EXPECT().GetUserTier(gomock.Any(), 1, gomock.Any()).Return(model.TierState{}, errors.New("some error"))
+
+			users, err := r.Review(context.Background(), time.Now())
+			Expect(err).Should(HaveOccurred())
+			Expect(users).Should(BeZero())
+		})
+		It("Run reviews tiers until Stop is called", func() {
+			r := internal.NewTierReview(rep, time.Hour, context.Background(), logger)
+
+			checked := make(chan struct{})
+			rep.EXPECT().GetUsersForTierReview(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(context.Context, time.Time, int, int) {
+				close(checked)
+			}).Return(nil, nil)
+
+			go r.Run()
+			Eventually(checked).Should(BeClosed())
+			Expect(r.Stop(context.Background())).Should(Succeed())
+		})
+	})
+})
@@ -0,0 +1,197 @@
+package test
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/DrGermanius/Gophermart/internal"
+	mock_internal "github.com/DrGermanius/Gophermart/internal/mock"
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+var (
+	exporter    = tracetest.NewInMemoryExporter()
+	tracingOnce sync.Once
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		rep    *mock_internal.MockIRepository
+		acc    *mock_internal.MockIAccrual
+		app    *fiber.App
+		logger *zap.SugaredLogger
+		token  string
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		// the global provider delegates only once, so all specs share it.
+		tracingOnce.Do(func() {
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+			otel.SetTextMapPropagator(propagation.TraceContext{})
+		})
+		exporter.Reset()
+
+		z, err := zap.NewDevelopment()
+		Expect(err).ShouldNot(HaveOccurred())
+		logger = z.Sugar()
+
+		rep = mock_internal.NewMockIRepository(ctrl)
+		acc = mock_internal.NewMockIAccrual(ctrl)
+		rep.EXPECT().IsSessionActive(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+
+		srv := internal.NewService(rep, acc, internal.LoyaltyRules{}, "secret", logger)
+		app = fiber.New(fiber.Config{ErrorHandler: internal.NewErrorHandler(logger)})
+		app.Use(internal.Tracing())
+		internal.RegisterRoutes(app, internal.NewHandlers(srv, "secret", "", logger))
+
+		token, err = srv.GetJWTToken("1", "sid")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	span := func(name string) tracetest.SpanStub {
+		for _, s := range exporter.GetSpans() {
+			if s.Name == name {
+				return s
+			}
+		}
+		Fail("span " + name + " isn't exported")
+		return tracetest.SpanStub{}
+	}
+
+	attributes := func(s tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value, len(s.Attributes))
+		for _, kv := range s.Attributes {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	sendOrder := func(traceParent string) *http.Response {
+		orderNumber := "79927398713"
+		rep.EXPECT().GetOrderByNumber(gomock.Any(), orderNumber).Return(model.Order{UserID: -1}, nil)
+		rep.EXPECT().SendOrder(gomock.Any(), orderNumber, 1).Return(nil)
+		acc.EXPECT().Notify()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(orderNumber))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Authorization", "Bearer "+token)
+		if traceParent != "" {
+			req.Header.Set("traceparent", traceParent)
+		}
+
+		res, err := app.Test(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	Context("Tracing tests", func() {
+		It("Service span is a child of the request span with order and user", func() {
+			res := sendOrder("")
+			Expect(res.StatusCode).Should(Equal(http.StatusAccepted))
+
+			server := span("POST /api/user/orders")
+			Expect(server.SpanKind).Should(Equal(trace.SpanKindServer))
+			Expect(attributes(server)[attribute.Key("user.id")].AsInt64()).Should(Equal(int64(1)))
+			Expect(attributes(server)[attribute.Key("http.status_code")].AsInt64()).Should(Equal(int64(http.StatusAccepted)))
+
+			service := span("Service.SendOrder")
+			Expect(service.Parent.SpanID()).Should(Equal(server.SpanContext.SpanID()))
+			Expect(service.SpanContext.TraceID()).Should(Equal(server.SpanContext.TraceID()))
+			Expect(attributes(service)[attribute.Key("order.number")].AsString()).Should(Equal("79927398713"))
+			Expect(attributes(service)[attribute.Key("user.id")].AsInt64()).Should(Equal(int64(1)))
+		})
+		It("Request span continues the trace of the caller", func() {
+			traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+			sendOrder(traceParent)
+
+			server := span("POST /api/user/orders")
+			Expect(server.SpanContext.TraceID().String()).Should(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(server.Parent.SpanID().String()).Should(Equal("00f067aa0ba902b7"))
+			Expect(server.Parent.IsRemote()).Should(BeTrue())
+		})
+		It("Accrual request carries trace context", func() {
+			var traceParent string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				traceParent = r.Header.Get("traceparent")
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"order":"79927398713","status":"PROCESSED","accrual":500}`))
+			}))
+			defer server.Close()
+
+			rep.EXPECT().GetUserTier(gomock.Any(), 1, gomock.Any()).Return(model.TierState{Tier: model.TierBronze}, nil).AnyTimes()
+			rep.EXPECT().MakeAccrual(gomock.Any(), 1, model.OrderStatusProcessed, "79927398713", gomock.Any(), gomock.Any()).Return(nil)
+
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+			accrual := internal.NewAccrualService(rep, server.URL, 1, 0, context.Background(), logger)
+			Expect(accrual.ProcessAccrual(ctx, 1, "79927398713")).Should(Succeed())
+			parent.End()
+
+			client := span("GET /api/orders/{number}")
+			Expect(client.SpanKind).Should(Equal(trace.SpanKindClient))
+			Expect(client.Parent.SpanID()).Should(Equal(parent.SpanContext().SpanID()))
+			Expect(traceParent).Should(ContainSubstring(client.SpanContext.TraceID().String()))
+			Expect(traceParent).Should(ContainSubstring(client.SpanContext.SpanID().String()))
+		})
+		It("Repository stores trace context with the accrual job", func() {
+			db, mock, err := sqlmock.New()
+			Expect(err).ShouldNot(HaveOccurred())
+			repo := internal.Repository{Conn: db, Logger: logger}
+
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+			defer parent.End()
+
+			mock.ExpectExec("INSERT INTO orders (.+) INSERT INTO accrual_jobs (.+)").
+				WithArgs("79927398713", 1, model.OrderStatusNew, sqlmock.AnyArg(), traceParentArg{parent.SpanContext().TraceID()}, "").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Expect(repo.SendOrder(ctx, "79927398713", 1)).Should(Succeed())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+
+			Expect(span("Repository.SendOrder").Parent.SpanID()).Should(Equal(parent.SpanContext().SpanID()))
+		})
+		It("Failed span records the error", func() {
+			rep.EXPECT().GetOrderByNumber(gomock.Any(), "79927398713").Return(model.Order{UserID: 1}, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("79927398713"))
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Authorization", "Bearer "+token)
+			_, err := app.Test(req, int(time.Second.Milliseconds()))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			service := span("Service.SendOrder")
+			Expect(service.Status.Description).Should(Equal(internal.ErrOrderIsAlreadySent.Error()))
+			Expect(service.Events).ShouldNot(BeEmpty())
+		})
+	})
+})
+
+// traceParentArg matches the traceparent of a span of the trace.
+type traceParentArg struct {
+	traceID trace.TraceID
+}
+
+func (a traceParentArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "00-"+a.traceID.String()+"-")
+}
@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Gophermart/internal/model"
+)
+
+// TierWindow is the number of months of accruals which count for the tier.
+const TierWindow = 12
+
+const tierReviewBatch = 100
+
+// their accruals are multiplied by Multiplier. Only the accruals count toward Threshold, not the bonus of the tier.
+// their accruals are multiplied by Multiplier.
+type TierRule struct {
+	Tier       string
+	Threshold  decimal.Decimal
+	Multiplier decimal.Decimal
+}
+
+// Tiers are sorted by threshold, the first one is the tier of new users.
+var Tiers = []TierRule{
+	{Tier: model.TierBronze, Threshold: decimal.Zero, Multiplier: decimal.NewFromInt(1)},
+	{Tier: model.TierSilver, Threshold: decimal.NewFromInt(1000), Multiplier: decimal.RequireFromString("1.1")},
+	{Tier: model.TierGold, Threshold: decimal.NewFromInt(5000), Multiplier: decimal.RequireFromString("1.25")},
+}
+
+// tierRule returns the rule of the tier, unknown tiers get the first one.
+func tierRule(tier string) TierRule {
+	for _, t := range Tiers {
+		if t.Tier == tier {
+			return t
+		}
+	}
+	return Tiers[0]
+}
+
+func tierForPoints(points decimal.Decimal) TierRule {
+	rule := Tiers[0]
+	for _, t := range Tiers {
+		if points.GreaterThanOrEqual(t.Threshold) {
+			rule = t
+		}
+	}
+	return rule
+}
+
+// nextTier returns the tier above the given one, ok is false for the top tier.
+func nextTier(tier string) (TierRule, bool) {
+	for i, t := range Tiers {
+		if t.Tier == tier && i+1 < len(Tiers) {
+			return Tiers[i+1], true
+		}
+	}
+	return TierRule{}, false
+}
+
+// computeTier returns the tier the user has by the points of the state and the time of its next review,
+// the tier can go down only when the oldest accrual leaves the window.
+func computeTier(state model.TierState) (string, *time.Time) {
+	var reviewAt *time.Time
+	if state.OldestAccrualAt != nil {
+		t := state.OldestAccrualAt.AddDate(0, TierWindow, 0)
+		reviewAt = &t
+	}
+
+	return tierForPoints(state.Points).Tier, reviewAt
+}
+
+// evaluateTier computes the tier of the user from points accrued in TierWindow before now
+// and stores it if the tier or the time of the next review has changed.
+func evaluateTier(ctx context.Context, repo IRepository, uid int, now time.Time) (model.TierState, error) {
+	state, err := repo.GetUserTier(ctx, uid, now.AddDate(0, -TierWindow, 0))
+	if err != nil {
+		return model.TierState{}, err
+	}
+
+	tier, reviewAt := computeTier(state)
+	if tier == state.Tier && sameTime(reviewAt, state.ReviewAt) {
+		return state, nil
+	}
+
+	err = repo.SetUserTier(ctx, uid, model.TierChange{From: state.Tier, To: tier, Points: state.Points, ReviewAt: reviewAt})
+	if err != nil {
+		return model.TierState{}, err
+	}
+
+	state.Tier = tier
+	state.ReviewAt = reviewAt
+	return state, nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// TierReview evaluates tiers of users whose review is due every interval, so a tier goes down when
+// the accruals leave the window even if the user doesn't accrue or ask for the tier.
+type TierReview struct {
+	repo     IRepository
+	interval time.Duration
+	ctx      context.Context
+	logger   *zap.SugaredLogger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func NewTierReview(repo IRepository, interval time.Duration, ctx context.Context, logger *zap.SugaredLogger) *TierReview {
+	return &TierReview{
+		repo:     repo,
+		interval: interval,
+		ctx:      ctx,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run reviews tiers right away and then every interval until Stop is called or ctx of the job is done.
+func (t *TierReview) Run() {
+	defer close(t.done)
+
+	for {
+		_, err := t.Review(t.ctx, time.Now())
+		if err != nil {
+			t.logger.Errorf("Review error: %s", err.Error())
+		}
+
+		timer := time.NewTimer(t.interval)
+		select {
+		case <-timer.C:
+		case <-t.stop:
+			timer.Stop()
+			t.logger.Info("tier review job is stopped")
+			return
+		case <-t.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Review evaluates the tier of every user whose review is due at the moment, it returns the number of reviewed users.
+func (t *TierReview) Review(ctx context.Context, now time.Time) (int, error) {
+	users := 0
+	// users are taken after the last reviewed one, so a user whose evaluation was skipped isn't taken again
+	afterID := 0
+
+	for {
+		uids, err := t.repo.GetUsersForTierReview(ctx, now, afterID, tierReviewBatch)
+		if err != nil {
+			return users, err
+		}
+
+		for _, uid := range uids {
+			state, err := evaluateTier(ctx, t.repo, uid, now)
+			if err != nil {
+				return users, err
+			}
+
+			t.logger.Infow("tier is reviewed", "user_id", uid, "tier", state.Tier)
+			afterID = uid
+			users++
+		}
+
+		if len(uids) < tierReviewBatch {
+			return users, nil
+		}
+	}
+}
+
+// Stop makes Run return and waits for the current review unless ctx is done first.
+func (t *TierReview) Stop(ctx context.Context) error {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}